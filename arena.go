@@ -0,0 +1,181 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+const (
+	// arenaLengthBits and arenaOffsetBits split the storage of each
+	// entry of a quotient filter with a value arena into the length and
+	// offset of its value
+	arenaLengthBits = 16
+	arenaOffsetBits = 32
+	arenaRefBits    = arenaLengthBits + arenaOffsetBits
+
+	// MaxArenaValue is the length of the longest value which can be
+	// stored in a value arena
+	MaxArenaValue = 1<<arenaLengthBits - 1
+
+	// arenaCompactMin is the number of bytes of overwritten values
+	// below which the arena is never compacted
+	arenaCompactMin = 4 << 10
+)
+
+// ErrArenaFull is returned when the value arena of a quotient filter
+// can't hold another value
+var ErrArenaFull = errors.New("value arena is full")
+
+// arenaRef returns the storage of a value of length n at off
+func arenaRef(off, n uint64) uint64 {
+	return off<<arenaLengthBits | n
+}
+
+// arenaSpan returns the offset and length of the value of ref
+func arenaSpan(ref uint64) (off, n uint64) {
+	return ref >> arenaLengthBits, ref & MaxArenaValue
+}
+
+// InsertWithBytes stores the key (byte slice) and a byte string value
+// in the value arena of the quotient filter, which must have been
+// created with Config.ValueArena.  It returns whether a value already
+// existed.  Like InsertWithValue, it panics with ErrFilterFull if a
+// quotient filter of fixed capacity is full, and it panics if the
+// value is longer than MaxArenaValue or the arena is full
+func (qf *Filter) InsertWithBytes(key, value []byte) (update bool) {
+	update, err := qf.TryInsertWithBytes(key, value)
+	if err != nil {
+		panic(err)
+	}
+	return update
+}
+
+// TryInsertWithBytes is like InsertWithBytes, but returns an error
+// rather than panicking when the value can't be stored
+func (qf *Filter) TryInsertWithBytes(key, value []byte) (update bool, err error) {
+	if !qf.config.ValueArena {
+		panic("quotient filter has no value arena, see Config.ValueArena")
+	}
+	return qf.tryInsertBytes(qf.hasher.sum(key), value)
+}
+
+// tryInsertBytes is TryInsertWithBytes for a pre-calculated raw hash
+// value
+func (qf *Filter) tryInsertBytes(hv uint64, value []byte) (update bool, err error) {
+	if len(value) > MaxArenaValue {
+		return false, fmt.Errorf("%d byte value exceeds the %d bytes of a value arena", len(value), MaxArenaValue)
+	}
+	ref := uint64(0)
+	if len(value) > 0 {
+		if uint64(len(qf.arena)+len(value)) > 1<<arenaOffsetBits {
+			if qf.CompactArena(); uint64(len(qf.arena)+len(value)) > 1<<arenaOffsetBits {
+				return false, ErrArenaFull
+			}
+		}
+		ref = arenaRef(uint64(len(qf.arena)), uint64(len(value)))
+	}
+	// the value of an entry which expired but is still stored is
+	// overwritten too.  Sweeping and doubling count the values of the
+	// expired entries they drop, so do both before looking for it
+	if e := qf.expiry; e != nil {
+		if now := e.now(); e.due(now) {
+			qf.expire(now)
+		}
+	}
+	// a full quotient filter may still update the entry, so the error
+	// is left to tryInsertRawHash
+	_ = qf.reserve(1)
+	found, old := qf.lookupStored(hv)
+	if update, err = qf.tryInsertRawHash(hv, ref); err != nil {
+		return false, err
+	}
+	qf.arena = append(qf.arena, value...)
+	if found {
+		if qf.expiry != nil {
+			old = qf.expiry.value(old)
+		}
+		_, n := arenaSpan(old)
+		qf.garbage += n
+		if qf.garbage > arenaCompactMin && 2*qf.garbage > uint64(len(qf.arena)) {
+			qf.CompactArena()
+		}
+	}
+	return update, nil
+}
+
+// InsertStringWithBytes is like InsertWithBytes, for a string key
+func (qf *Filter) InsertStringWithBytes(key string, value []byte) (update bool) {
+	return qf.InsertWithBytes(unsafe.Slice(unsafe.StringData(key), len(key)), value)
+}
+
+// LookupBytes searches for key and returns whether it exists, and the
+// byte string value stored with it in the value arena.  The value is
+// shared with the arena and must not be modified
+func (qf *Filter) LookupBytes(key []byte) (bool, []byte) {
+	found, ref := qf.Lookup(key)
+	if !found || !qf.config.ValueArena {
+		return found, nil
+	}
+	off, n := arenaSpan(ref)
+	return true, qf.arena[off : off+n : off+n]
+}
+
+// LookupStringBytes is like LookupBytes, for a string key
+func (qf *Filter) LookupStringBytes(key string) (bool, []byte) {
+	return qf.LookupBytes(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// ArenaBytes reports the size of the value arena, and how much of it
+// is held by values which were overwritten
+func (qf *Filter) ArenaBytes() (size, garbage uint64) {
+	return uint64(len(qf.arena)), qf.garbage
+}
+
+// CompactArena copies the values of every entry into a new arena,
+// dropping those which were overwritten.  It happens automatically as
+// values are overwritten, once they take up half the arena
+func (qf *Filter) CompactArena() {
+	if qf.storage == nil || qf.garbage == 0 {
+		return
+	}
+	arena := make([]byte, 0, uint64(len(qf.arena))-qf.garbage)
+	qf.eachHashValue(func(_, slot uint64) {
+		stored := qf.storage.Get(slot)
+		ref := stored
+		if qf.expiry != nil {
+			ref = qf.expiry.value(stored)
+		}
+		off, n := arenaSpan(ref)
+		if n == 0 {
+			return
+		}
+		moved := arenaRef(uint64(len(arena)), n)
+		arena = append(arena, qf.arena[off:off+n]...)
+		qf.storage.Set(slot, stored&^lowMask(arenaRefBits)|moved)
+	})
+	qf.arena, qf.garbage = arena, 0
+}
+
+// LookupBytes searches for key and returns whether it exists, and the
+// byte string value stored with it in the value arena, which is read
+// from disk
+func (ext *Disk) LookupBytes(key []byte) (bool, []byte) {
+	found, ref := ext.Lookup(key)
+	if !found || ext.arenaOff < 0 {
+		return found, nil
+	}
+	off, n := arenaSpan(ref)
+	value := make([]byte, n)
+	if _, err := ext.f.ReadAt(value, ext.arenaOff+int64(off)); err != nil {
+		panic(fmt.Sprintf("error: %s", err))
+	}
+	return true, value
+}
+
+// LookupStringBytes is like LookupBytes, for a string key
+func (ext *Disk) LookupStringBytes(key string) (bool, []byte) {
+	return ext.LookupBytes(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueArena(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		qf := NewWithConfig(Config{ValueArena: true, Layout: layout})
+		label := func(i, gen int) []byte {
+			return []byte(fmt.Sprintf(`{"label":"l%d","gen":%d}`, i, gen))
+		}
+		for i := 0; i < 2000; i++ {
+			assert.False(t, qf.InsertWithBytes([]byte(fmt.Sprintf("k%d", i)), label(i, 0)))
+		}
+		qf.InsertStringWithBytes("empty", nil)
+		qf.InsertString("plain")
+		found, v := qf.LookupStringBytes("k7")
+		assert.True(t, found)
+		assert.Equal(t, label(7, 0), v)
+		found, v = qf.LookupStringBytes("empty")
+		assert.True(t, found)
+		assert.Empty(t, v)
+		found, v = qf.LookupStringBytes("plain")
+		assert.True(t, found)
+		assert.Empty(t, v)
+		found, _ = qf.LookupStringBytes("nope")
+		assert.False(t, found)
+
+		// overwritten values are compacted away
+		size, _ := qf.ArenaBytes()
+		for gen := 1; gen < 5; gen++ {
+			for i := 0; i < 2000; i += 2 {
+				assert.True(t, qf.InsertWithBytes([]byte(fmt.Sprintf("k%d", i)), label(i, gen)))
+			}
+		}
+		after, garbage := qf.ArenaBytes()
+		assert.Less(t, after, 2*size)
+		assert.LessOrEqual(t, 2*garbage, after)
+		check := func(r interface {
+			LookupStringBytes(string) (bool, []byte)
+		}) {
+			for i := 0; i < 2000; i++ {
+				found, v := r.LookupStringBytes(fmt.Sprintf("k%d", i))
+				assert.True(t, found)
+				want := label(i, 0)
+				if i%2 == 0 {
+					want = label(i, 4)
+				}
+				assert.Equal(t, want, v)
+			}
+		}
+		check(qf)
+		qf.CompactArena()
+		size, garbage = qf.ArenaBytes()
+		assert.Zero(t, garbage)
+		check(qf)
+
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		var cpy Filter
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		check(&cpy)
+		cpySize, _ := cpy.ArenaBytes()
+		assert.Equal(t, size, cpySize)
+
+		name, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		check(ext)
+		found, v = ext.LookupStringBytes("empty")
+		assert.True(t, found)
+		assert.Empty(t, v)
+		ext.Close()
+		os.Remove(name)
+	}
+
+	qf := NewWithConfig(Config{ValueArena: true})
+	_, err := qf.TryInsertWithBytes([]byte("long"), []byte(strings.Repeat("x", MaxArenaValue+1)))
+	assert.Error(t, err)
+	assert.False(t, qf.Contains([]byte("long")))
+	assert.Panics(t, func() { NewWithConfig(Config{ValueArena: true, BitsOfStoragePerEntry: 8}) })
+	assert.Panics(t, func() { New().InsertWithBytes([]byte("k"), []byte("v")) })
+}
+
+// the storage of a quotient filter with a value arena holds references
+// into the arena, so integer values are refused
+func TestValueArenaRejectsIntegers(t *testing.T) {
+	qf := NewWithConfig(Config{ValueArena: true})
+	qf.InsertStringWithBytes("k", []byte("value"))
+	assert.PanicsWithValue(t, errArenaInteger, func() { qf.InsertStringWithValue("k", 0x1234) })
+	assert.PanicsWithValue(t, errArenaInteger, func() { qf.InsertRawHash(qf.hasher.sum([]byte("j")), 7) })
+	assert.PanicsWithValue(t, errArenaInteger, func() { qf.InsertUint64WithValue(3, 7) })
+	assert.PanicsWithValue(t, errArenaInteger, func() {
+		BuildParallel(Config{ValueArena: true}, [][]byte{[]byte("k")}, []uint64{1}, 1)
+	})
+	found, v := qf.LookupStringBytes("k")
+	assert.True(t, found)
+	assert.Equal(t, []byte("value"), v)
+	assert.Equal(t, uint64(1), qf.Len())
+
+	// inserting without a value overwrites it with an empty one
+	assert.True(t, qf.InsertString("k"))
+	found, v = qf.LookupStringBytes("k")
+	assert.True(t, found)
+	assert.Empty(t, v)
+	_, garbage := qf.ArenaBytes()
+	assert.Equal(t, uint64(len("value")), garbage)
+
+	_, err := OpenCascade(t.TempDir(), CascadeConfig{Config: Config{ValueArena: true}})
+	assert.Error(t, err)
+}
+
+func TestValueArenaExpires(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	qf := NewWithConfig(Config{ValueArena: true, TTL: time.Hour, Clock: clock.now})
+	qf.InsertWithBytes([]byte("old"), []byte("old value"))
+	clock.t = clock.t.Add(time.Hour / 2)
+	qf.InsertWithBytes([]byte("new"), []byte("new value"))
+	clock.t = clock.t.Add(time.Hour)
+	found, _ := qf.LookupStringBytes("old")
+	assert.False(t, found)
+	assert.Equal(t, uint64(1), qf.Expire(clock.t))
+	_, garbage := qf.ArenaBytes()
+	assert.Equal(t, uint64(len("old value")), garbage)
+	qf.CompactArena()
+	found, v := qf.LookupStringBytes("new")
+	assert.True(t, found)
+	assert.Equal(t, []byte("new value"), v)
+	size, _ := qf.ArenaBytes()
+	assert.Equal(t, uint64(len("new value")), size)
+}
+
+func TestValueArenaOverwritesExpired(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		clock := &fakeClock{t: time.Now()}
+		qf := NewWithConfig(Config{ValueArena: true, TTL: time.Hour, Clock: clock.now, Layout: layout})
+		clock.t = clock.t.Add(time.Hour / 10)
+		qf.InsertStringWithBytes("k", []byte("old value"))
+		// a sweep is due a TTL after the last, while k is still live
+		clock.t = clock.t.Add(time.Hour * 9 / 10)
+		qf.InsertStringWithBytes("other", []byte("other"))
+		// k expires, but no sweep is due to drop it
+		clock.t = clock.t.Add(time.Hour * 15 / 100)
+		found, _ := qf.LookupStringBytes("k")
+		assert.False(t, found)
+		assert.False(t, qf.InsertStringWithBytes("k", []byte("fresh")))
+		_, garbage := qf.ArenaBytes()
+		assert.Equal(t, uint64(len("old value")), garbage)
+		found, v := qf.LookupStringBytes("k")
+		assert.True(t, found)
+		assert.Equal(t, []byte("fresh"), v)
+
+		// an overwrite which sweeps counts each expired value once
+		clock.t = clock.t.Add(time.Hour * 11 / 10)
+		qf.InsertStringWithBytes("k", []byte("again"))
+		_, garbage = qf.ArenaBytes()
+		assert.Equal(t, uint64(len("old value")+len("other")+len("fresh")), garbage)
+	}
+}
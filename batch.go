@@ -0,0 +1,177 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// batchWindow is the number of keys which are hashed and whose home
+// buckets are touched together during a batched lookup.  Reading a
+// window of independent buckets before resolving any of them lets the
+// cpu overlap the cache misses rather than paying for them serially
+const batchWindow = 64
+
+// diskPageSize is the granularity at which batched lookups against a
+// Disk filter read (and cache) the underlying file
+const diskPageSize = 64 << 10
+
+func checkBatch(keys [][]byte, found []bool, values []uint64) {
+	if len(found) < len(keys) {
+		panic(fmt.Sprintf("batch of %d keys has only %d result slots", len(keys), len(found)))
+	}
+	if values != nil && len(values) < len(keys) {
+		panic(fmt.Sprintf("batch of %d keys has only %d value slots", len(keys), len(values)))
+	}
+}
+
+// LookupBatch searches for every key in keys, storing whether
+// keys[i] exists in found[i] and the value stored with it (if any)
+// in values[i].  values may be nil if the caller is not interested
+// in external storage.  It is equivalent to calling Lookup for each
+// key, but is considerably faster for large filters
+func (qf *Filter) LookupBatch(keys [][]byte, found []bool, values []uint64) {
+	checkBatch(keys, found, values)
+	if qf.expiry != nil || qf.fpBits != 0 {
+		// the storage of every entry is needed to tell if it expired,
+		// and a fingerprint is matched across its whole run
+		for i, key := range keys {
+			f, v := qf.Lookup(key)
+			found[i] = f
+			if values != nil {
+				values[i] = v
+			}
+		}
+		return
+	}
+	if qf.rs != nil {
+		qf.rsLookupBatch(keys, found, values)
+		return
+	}
+	var storageFn readFn
+	if qf.storage != nil && values != nil {
+		storageFn = qf.storage.Get
+	}
+	var dqs, drs [batchWindow]uint64
+	var sds [batchWindow]slotData
+	for base := 0; base < len(keys); base += batchWindow {
+		window := keys[base:]
+		if len(window) > batchWindow {
+			window = window[:batchWindow]
+		}
+		for i, key := range window {
+			dqs[i], drs[i] = hash(&qf.hasher, key, qf.rBits, qf.rMask)
+		}
+		// touch every home bucket before resolving any of them
+		for i := range window {
+			sds[i] = qf.read(dqs[i])
+		}
+		for i := range window {
+			f, v := lookupByHashFrom(sds[i], dqs[i], drs[i], qf.size, qf.filter.Get, storageFn)
+			found[base+i] = f
+			if values != nil {
+				values[base+i] = v
+			}
+		}
+	}
+}
+
+func (qf *Filter) rsLookupBatch(keys [][]byte, found []bool, values []uint64) {
+	view := qf.view()
+	var dqs, drs [batchWindow]uint64
+	for base := 0; base < len(keys); base += batchWindow {
+		window := keys[base:]
+		if len(window) > batchWindow {
+			window = window[:batchWindow]
+		}
+		for i, key := range window {
+			dqs[i], drs[i] = hash(&qf.hasher, key, qf.rBits, qf.rMask)
+		}
+		// touch every home block before resolving any of them
+		for i := range window {
+			qf.rs.occupieds(dqs[i] / slotsPerBlock)
+		}
+		for i := range window {
+			f, v := view.lookup(dqs[i], drs[i])
+			found[base+i] = f
+			if values != nil {
+				values[base+i] = v
+			}
+		}
+	}
+}
+
+// ContainsBatch stores in found[i] whether keys[i] is contained
+// within the quotient filter
+func (qf *Filter) ContainsBatch(keys [][]byte, found []bool) {
+	qf.LookupBatch(keys, found, nil)
+}
+
+// LookupBatch is like Lookup for every key in keys, see
+// Filter.LookupBatch.  Probes are sorted by their position in the
+// file so that neighboring probes are served by a single read
+func (ext *Disk) LookupBatch(keys [][]byte, found []bool, values []uint64) {
+	checkBatch(keys, found, values)
+	type probe struct {
+		dq, dr uint64
+		ix     int
+	}
+	probes := make([]probe, len(keys))
+	for i, key := range keys {
+		dq, dr := hash(&ext.hasher, key, ext.rBits, ext.rMask)
+		probes[i] = probe{dq, dr, i}
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].dq < probes[j].dq
+	})
+
+	view := ext.view(true)
+	for _, p := range probes {
+		f, v := view.lookup(p.dq, p.dr)
+		if ext.expiry != nil {
+			f, v = ext.expiry.visible(f, v)
+		}
+		found[p.ix] = f
+		if values != nil {
+			values[p.ix] = v
+		}
+	}
+}
+
+// ContainsBatch stores in found[i] whether keys[i] is contained
+// within the quotient filter
+func (ext *Disk) ContainsBatch(keys [][]byte, found []bool) {
+	ext.LookupBatch(keys, found, nil)
+}
+
+// pageCache is an io.ReaderAt which holds on to the most recently
+// read page of the underlying reader, so that reads which land close
+// to each other are coalesced into a single read
+type pageCache struct {
+	r    io.ReaderAt
+	off  int64
+	page []byte
+	buf  []byte
+}
+
+func (c *pageCache) ReadAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if off < c.off || end > c.off+int64(len(c.page)) {
+		if c.buf == nil {
+			c.buf = make([]byte, diskPageSize)
+		}
+		start := off - off%diskPageSize
+		n, err := c.r.ReadAt(c.buf, start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		c.off, c.page = start, c.buf[:n]
+	}
+	if off < c.off || end > c.off+int64(len(c.page)) {
+		// straddles a page boundary or the end of file
+		return c.r.ReadAt(p, off)
+	}
+	return copy(p, c.page[off-c.off:]), nil
+}
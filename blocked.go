@@ -0,0 +1,283 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"unsafe"
+)
+
+// qfBlockedVectorVersion is the version of the blocked vector
+// serialization format.
+const qfBlockedVectorVersion = uint64(1)
+
+// cacheLineWords is the number of 64 bit words in a cache line, the
+// unit into which the blocked vector packs slots
+const cacheLineWords = 8
+
+const cacheLineBits = cacheLineWords * bitsPerWord
+
+// blockedLayout locates slots within a blocked vector.  Slots are
+// packed into 64 byte blocks, as many as fit, such that no slot
+// crosses a block boundary.  A slot may still span two words of its
+// block, but both lie in the same cache line
+type blockedLayout struct {
+	bits     uint
+	mask     uint64
+	perBlock uint64
+	blockOf  divisor
+	// wordShift is log2 of the number of slots per word when bits is
+	// a power of two, in which case no slot ever spans two words
+	wordShift uint
+	pow2      bool
+}
+
+func newBlockedLayout(b uint) blockedLayout {
+	if b == 0 || b > bitsPerWord {
+		panic(fmt.Sprintf("bit size of %d is not supported by blocked vectors, must be between 1 and %d",
+			b, bitsPerWord))
+	}
+	l := blockedLayout{
+		bits:     b,
+		mask:     ^genForbiddenMask(b),
+		perBlock: uint64(cacheLineBits / b),
+		pow2:     b&(b-1) == 0,
+	}
+	if b == bitsPerWord {
+		l.mask = ^uint64(0)
+	}
+	l.blockOf = newDivisor(l.perBlock)
+	if l.pow2 {
+		l.wordShift = uint(bits.TrailingZeros(bitsPerWord / b))
+	}
+	return l
+}
+
+// words reports the number of words needed to store size slots, always
+// a whole number of blocks
+func (l *blockedLayout) words(size uint64) uint64 {
+	return (size + l.perBlock - 1) / l.perBlock * cacheLineWords
+}
+
+// locate returns the word in which slot ix starts and its bit offset
+// within that word
+func (l *blockedLayout) locate(ix uint64) (word uint64, shift uint) {
+	if l.pow2 {
+		// slots tile words exactly, there are no gaps at the end of
+		// blocks
+		spw := uint64(1) << l.wordShift
+		return ix >> l.wordShift, uint(ix&(spw-1)) * l.bits
+	}
+	block := l.blockOf.div(ix)
+	off := (ix - block*l.perBlock) * uint64(l.bits)
+	return block*cacheLineWords + off/bitsPerWord, uint(off % bitsPerWord)
+}
+
+// spans reports whether a slot starting at shift continues into the
+// following word
+func (l *blockedLayout) spans(shift uint) bool {
+	return shift+l.bits > bitsPerWord
+}
+
+func (l *blockedLayout) extract(lo, hi uint64, shift uint) uint64 {
+	val := lo >> shift
+	if l.spans(shift) {
+		val |= hi << (bitsPerWord - shift)
+	}
+	return val & l.mask
+}
+
+type blockedHeader struct {
+	Version uint64
+	Bits    uint64
+	Size    uint64
+}
+
+type blocked struct {
+	blockedLayout
+	space []uint64
+	size  uint64
+}
+
+var _ Vector = (*blocked)(nil)
+
+// BlockedVectorAllocate allocates bitpacked storage in which no entry
+// straddles a cache line, trading a little space for lookups nearly as
+// fast as unpacked storage.  Like BitPackedVectorAllocate the
+// serialization format is not portable between architectures
+func BlockedVectorAllocate(bits uint, size uint64) Vector {
+	if bits == 0 {
+		// sized by a subsequent ReadFrom
+		return &blocked{}
+	}
+	l := newBlockedLayout(bits)
+	return &blocked{l, alignedWords(l.words(size)), size}
+}
+
+// alignedWords allocates n words starting on a cache line boundary
+func alignedWords(n uint64) []uint64 {
+	if n == 0 {
+		return nil
+	}
+	space := make([]uint64, n+cacheLineWords-1)
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(space)))
+	skip := (cacheLineWords - addr/bytesPerWord%cacheLineWords) % cacheLineWords
+	return space[skip : skip+uintptr(n) : skip+uintptr(n)]
+}
+
+func (v *blocked) Get(ix uint64) uint64 {
+	w, shift := v.locate(ix)
+	if v.pow2 {
+		return v.space[w] >> shift & v.mask
+	}
+	var hi uint64
+	if v.spans(shift) {
+		hi = v.space[w+1]
+	}
+	return v.extract(v.space[w], hi, shift)
+}
+
+func (v *blocked) Set(ix uint64, val uint64) {
+	if val&^v.mask != 0 {
+		panic(fmt.Sprintf("attempt to store out of range value.  numeric overflow: %x (%x)", val&^v.mask, val))
+	}
+	w, shift := v.locate(ix)
+	v.space[w] = v.space[w]&^(v.mask<<shift) | val<<shift
+	if !v.pow2 && v.spans(shift) {
+		rem := bitsPerWord - shift
+		v.space[w+1] = v.space[w+1]&^(v.mask>>rem) | val>>rem
+	}
+}
+
+func (v *blocked) ShiftRight(from, to uint64) {
+	b := uint64(v.bits)
+	if v.pow2 {
+		// slots are contiguous
+		shiftBitsUp(v.space, from*b, to*b, v.bits)
+		return
+	}
+	// shift within each block from the top down, carrying the last
+	// slot of the preceding block into the first slot of the next
+	for to > from {
+		block := to / v.perBlock
+		lo := block * v.perBlock
+		if lo < from {
+			lo = from
+		}
+		base := block*cacheLineBits - block*v.perBlock*b
+		shiftBitsUp(v.space, base+lo*b, base+to*b, v.bits)
+		if lo == from {
+			return
+		}
+		v.Set(lo, v.Get(lo-1))
+		to = lo - 1
+	}
+}
+
+func (v *blocked) Swap(ix uint64, val uint64) (oldval uint64) {
+	oldval = v.Get(ix)
+	v.Set(ix, val)
+	return
+}
+
+func (v *blocked) WriteTo(stream io.Writer) (n int64, err error) {
+	h := blockedHeader{
+		Version: qfBlockedVectorVersion,
+		Bits:    uint64(v.bits),
+		Size:    v.size,
+	}
+	if err = binary.Write(stream, binary.LittleEndian, h); err != nil {
+		return
+	}
+	n, err = writeUintSlice(stream, v.space)
+	n += int64(unsafe.Sizeof(h))
+	return
+}
+
+func (v *blocked) ReadFrom(stream io.Reader) (n int64, err error) {
+	var h blockedHeader
+	if err = binary.Read(stream, binary.LittleEndian, &h); err != nil {
+		return
+	}
+	if err = checkBlockedHeader(&h); err != nil {
+		return
+	}
+	v.blockedLayout = newBlockedLayout(uint(h.Bits))
+	v.size = h.Size
+	v.space, n, err = readUintSliceInto(stream, alignedWords)
+	n += int64(unsafe.Sizeof(h))
+	if err == nil && uint64(len(v.space)) != v.words(v.size) {
+		err = fmt.Errorf("invalid file format, blocked vector of %d entries has %d words",
+			v.size, len(v.space))
+	}
+	return
+}
+
+func checkBlockedHeader(h *blockedHeader) error {
+	if h.Version != qfBlockedVectorVersion {
+		return fmt.Errorf("invalid file format, blocked vector version mismatch, got %x, expected %x",
+			h.Version, qfBlockedVectorVersion)
+	}
+	if h.Bits == 0 || h.Bits > bitsPerWord {
+		return fmt.Errorf("invalid file format, blocked vector has %d bit entries", h.Bits)
+	}
+	return nil
+}
+
+type blockedDiskReader struct {
+	blockedLayout
+	r     io.ReaderAt
+	start uint64
+	size  uint64
+}
+
+func initBlockedDiskReader(stream *os.File) (*blockedDiskReader, error) {
+	var h blockedHeader
+	if err := binary.Read(stream, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if err := checkBlockedHeader(&h); err != nil {
+		return nil, err
+	}
+	var words uint64
+	if err := binary.Read(stream, binary.LittleEndian, &words); err != nil {
+		return nil, err
+	}
+	cur, err := stream.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	// seek to end
+	_, err = stream.Seek(cur+int64(8*words), io.SeekStart)
+	return &blockedDiskReader{newBlockedLayout(uint(h.Bits)), stream, uint64(cur), h.Size}, err
+}
+
+func (r blockedDiskReader) withReaderAt(ra io.ReaderAt) extReader {
+	r.r = ra
+	return r
+}
+
+func (r blockedDiskReader) bytes() uint64 {
+	return r.words(r.size) * bytesPerWord
+}
+
+func (r blockedDiskReader) Read(ix uint64) (val uint64, err error) {
+	w, shift := r.locate(ix)
+	var data [16]byte
+	cnt := 8
+	if r.spans(shift) {
+		cnt = 16
+	}
+	n, err := r.r.ReadAt(data[:cnt], int64(r.start+w*8))
+	if err != nil {
+		return 0, err
+	}
+	if n != cnt {
+		return 0, fmt.Errorf("short read: %d/%d", n, cnt)
+	}
+	return r.extract(binary.LittleEndian.Uint64(data[:8]), binary.LittleEndian.Uint64(data[8:]), shift), nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocked(t *testing.T) {
+	r := rand.NewSource(77) //intentionally fixed seed
+	for bits := uint(1); bits <= 64; bits++ {
+		n := uint64(300)
+		v := BlockedVectorAllocate(bits, n).(*blocked)
+		assert.Zero(t, uintptr(unsafe.Pointer(&v.space[0]))%(cacheLineWords*bytesPerWord),
+			"%d bit vector is not cache line aligned", bits)
+		expect := make([]uint64, n)
+		for j := 0; j < 10; j++ {
+			for i := uint64(0); i < n; i++ {
+				x := uint64(r.Int63()) & v.mask
+				expect[i] = x
+				v.Set(i, x)
+			}
+			// check all, setting one slot must not disturb its neighbors
+			for i := uint64(0); i < n; i++ {
+				if !assert.Equal(t, expect[i], v.Get(i), "%d bit slot %d", bits, i) {
+					return
+				}
+			}
+		}
+		// no slot may straddle a cache line
+		for i := uint64(0); i < n; i++ {
+			w, shift := v.locate(i)
+			last := (w*bitsPerWord + uint64(shift) + uint64(bits) - 1) / bitsPerWord
+			assert.Equal(t, w/cacheLineWords, last/cacheLineWords, "%d bit slot %d", bits, i)
+		}
+
+		var buf bytes.Buffer
+		_, err := v.WriteTo(&buf)
+		assert.NoError(t, err)
+		cpy := BlockedVectorAllocate(0, 0)
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		for i := uint64(0); i < n; i++ {
+			assert.Equal(t, expect[i], cpy.Get(i))
+		}
+	}
+}
+
+func TestBlockedFilter(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		qf := NewWithConfig(Config{
+			BitsOfStoragePerEntry: 11,
+			VectorAllocate:        BlockedVectorAllocate,
+			Layout:                layout,
+		})
+		for i, s := range testStrings {
+			qf.InsertStringWithValue(s, uint64(i))
+		}
+		assert.NoError(t, qf.Validate())
+
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		// the header selects the vector implementation
+		cpy := New()
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.IsType(t, &blocked{}, cpy.filter)
+
+		name, err := writeQFToTempFile(qf)
+		defer os.Remove(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		ext, err := OpenReadOnlyFromPath(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ext.Close()
+		assert.NoError(t, ext.Validate())
+		assert.Equal(t, qf.Stats(), ext.Stats())
+		for _, s := range testStrings {
+			_, val := qf.LookupString(s)
+			f, v := cpy.LookupString(s)
+			assert.True(t, f, "%q missing", s)
+			assert.Equal(t, val, v)
+			f, v = ext.LookupString(s)
+			assert.True(t, f, "%q missing on disk", s)
+			assert.Equal(t, val, v)
+		}
+	}
+}
+
+func BenchmarkBlockedFilterLookup(b *testing.B) {
+	c := Config{VectorAllocate: BlockedVectorAllocate, ExpectedEntries: uint64(len(testStrings))}
+	qf := NewWithConfig(c)
+	for _, s := range testStrings {
+		qf.InsertString(s)
+	}
+
+	numStrings := len(testStrings)
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		qf.ContainsString(testStrings[n%numStrings])
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// partitionsPerWorker is the number of quotient ranges built per
+// worker, more partitions than workers evens out their load
+const partitionsPerWorker = 4
+
+// BuildParallel builds a quotient filter from keys (and values, which
+// may be nil) using the specified number of goroutines, or GOMAXPROCS
+// when workers is not positive.  Keys are hashed in parallel and
+// partitioned by their quotients into disjoint ranges of slots, each of
+// which is laid out on its own goroutine.
+//
+// The quotient filter is sized as NewWithConfig(c) would, doubling as
+// required to fit the distinct keys (or failing with ErrFilterFull if
+// c.FixedCapacity is set), and is identical to the one built by
+// inserting each key in turn.  Where a key is repeated the last value
+// wins, unless c.Multiset is set in which case the keys are inserted
+// in turn to keep each value.  The rank and select layout and vectors which can't be written
+// concurrently are built sequentially
+func BuildParallel(c Config, keys [][]byte, values []uint64, workers int) (*Filter, error) {
+	if values != nil && len(values) < len(keys) {
+		panic(fmt.Sprintf("batch of %d keys has only %d values", len(keys), len(values)))
+	}
+	if c.ValueArena {
+		panic(errArenaInteger)
+	}
+	if c.FingerprintBits != 0 {
+		panic("a quotient filter with fingerprints can't be built in parallel")
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	qf := NewWithConfig(c)
+	if c.Multiset {
+		for i, key := range keys {
+			var value uint64
+			if values != nil {
+				value = values[i]
+			}
+			if _, err := qf.TryInsertWithValue(key, value); err != nil {
+				return nil, err
+			}
+		}
+		return qf, nil
+	}
+
+	// hash
+	hvs := make([]uint64, len(keys))
+	parallelChunks(len(keys), workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			hvs[i] = qf.hasher.sum(keys[i])
+		}
+	})
+
+	// partition by the top bits of the hash, which are the top bits of
+	// the quotient whatever the final size of the table
+	pBits := uint(bits.Len(uint(workers*partitionsPerWorker) - 1))
+	parts := partitionHashes(hvs, values, pBits, workers)
+
+	// sort each partition and drop repeated hashes, keeping the last
+	var distinct uint64
+	var mu sync.Mutex
+	parallelEach(len(parts), workers, func(p int) {
+		parts[p] = dedupe(parts[p])
+		mu.Lock()
+		distinct += uint64(len(parts[p]))
+		mu.Unlock()
+	})
+
+	// size the table
+	qBits := qf.qBits
+	for uint64(math.Ceil(float64(uint64(1)<<qBits)*c.loadFactor())) < distinct {
+		if c.FixedCapacity {
+			return nil, ErrFilterFull
+		}
+		qBits++
+	}
+	if qBits != qf.qBits {
+		qf.initForQuotientBits(qBits)
+		qf.allocStorage(qf.allocfn)
+	}
+
+	if qf.rs != nil || qf.size>>pBits < bitsPerWord ||
+		!concurrentlyWritable(qf.filter) || (qf.storage != nil && !concurrentlyWritable(qf.storage)) {
+		for _, part := range parts {
+			for _, e := range part {
+				if _, err := qf.TryInsertRawHash(e.hv, e.value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return qf, nil
+	}
+	if e := qf.expiry; e != nil {
+		now := e.now()
+		for _, part := range parts {
+			for i := range part {
+				part[i].value = e.stamp(part[i].value, now)
+			}
+		}
+	}
+	qf.layoutPartitions(parts, pBits, workers)
+	qf.entries = distinct
+	return qf, nil
+}
+
+// hashedEntry is a hashed key, and its value, awaiting insertion.  ix
+// is the position of the key, which orders repeated keys
+type hashedEntry struct {
+	hv, value uint64
+	ix        int
+}
+
+// partitionHashes distributes the hashes (and values) by their top
+// pBits, preserving their order within each partition
+func partitionHashes(hvs, values []uint64, pBits uint, workers int) [][]hashedEntry {
+	nparts := 1 << pBits
+	part := func(hv uint64) int {
+		if pBits == 0 {
+			return 0
+		}
+		return int(hv >> (bitsPerWord - pBits))
+	}
+	// count per chunk, so that each chunk scatters into its own region
+	// of each partition
+	chunks := chunkBounds(len(hvs), workers)
+	counts := make([][]int, len(chunks))
+	parallelEach(len(chunks), workers, func(w int) {
+		counts[w] = make([]int, nparts)
+		for i := chunks[w][0]; i < chunks[w][1]; i++ {
+			counts[w][part(hvs[i])]++
+		}
+	})
+	parts := make([][]hashedEntry, nparts)
+	offsets := make([][]int, len(chunks))
+	for w := range chunks {
+		offsets[w] = make([]int, nparts)
+	}
+	for p := range parts {
+		n := 0
+		for w := range chunks {
+			offsets[w][p] = n
+			n += counts[w][p]
+		}
+		parts[p] = make([]hashedEntry, n)
+	}
+	parallelEach(len(chunks), workers, func(w int) {
+		for i := chunks[w][0]; i < chunks[w][1]; i++ {
+			p := part(hvs[i])
+			e := hashedEntry{hv: hvs[i], ix: i}
+			if values != nil {
+				e.value = values[i]
+			}
+			parts[p][offsets[w][p]] = e
+			offsets[w][p]++
+		}
+	})
+	return parts
+}
+
+// dedupe sorts entries by hash, keeping only the last of any entries
+// with the same hash
+func dedupe(entries []hashedEntry) []hashedEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].hv != entries[j].hv {
+			return entries[i].hv < entries[j].hv
+		}
+		return entries[i].ix < entries[j].ix
+	})
+	out := entries[:0]
+	for i, e := range entries {
+		if i+1 < len(entries) && entries[i+1].hv == e.hv {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// concurrentlyWritable reports whether disjoint ranges of v which start
+// on multiples of 64 elements may be written concurrently, that is,
+// whether such ranges never share a word
+func concurrentlyWritable(v Vector) bool {
+	switch x := v.(type) {
+	case *unpacked, *packed:
+		return true
+	case *blocked:
+		return x.pow2
+	}
+	return false
+}
+
+// slotSpill is a slot written by a partition beyond the end of its own
+// range of slots
+type slotSpill struct {
+	slot  uint64
+	sd    slotData
+	value uint64
+}
+
+// layoutPartitions writes the sorted, distinct hashes of each partition
+// into an empty classic quotient filter.  Partition p holds quotients
+// [p*span, (p+1)*span) and writes the slots in that range, slots beyond
+// it (including those wrapping around the end of the table) are
+// written once all partitions are done
+func (qf *Filter) layoutPartitions(parts [][]hashedEntry, pBits uint, workers int) {
+	span := qf.size >> pBits
+	// first, where each partition's runs would end were they not pushed
+	// along by those of the previous partition
+	ends := make([]uint64, len(parts))
+	parallelEach(len(parts), workers, func(p int) {
+		ends[p] = qf.eachPlacement(parts[p], uint64(p)*span, func(uint64, int, bool) {})
+	})
+
+	// then, where each partition's runs start.  Runs of the final
+	// partition may wrap around into the first, so iterate until the
+	// wrap around is stable
+	starts := make([]uint64, len(parts))
+	for wrap := uint64(0); ; {
+		end := wrap
+		for p := range parts {
+			starts[p] = uint64(p) * span
+			if end > starts[p] {
+				starts[p] = end
+			}
+			end = ends[p]
+			if n := starts[p] + uint64(len(parts[p])); n > end {
+				end = n
+			}
+		}
+		if end <= qf.size || end-qf.size == wrap {
+			break
+		}
+		wrap = end - qf.size
+	}
+
+	// now lay out each partition, setting occupied bits once its own
+	// range is written
+	spills := make([][]slotSpill, len(parts))
+	parallelEach(len(parts), workers, func(p int) {
+		hi := uint64(p+1) * span
+		part := parts[p]
+		qf.eachPlacement(part, starts[p], func(slot uint64, ix int, first bool) {
+			q := part[ix].hv >> qf.rBits
+			var sd slotData
+			sd.setShifted(slot != q)
+			sd.setContinuation(!first)
+			sd.setR(part[ix].hv & qf.rMask)
+			if slot >= hi {
+				spills[p] = append(spills[p], slotSpill{slot % qf.size, sd, part[ix].value})
+				return
+			}
+			qf.write(slot, sd)
+			if qf.storage != nil {
+				qf.storage.Set(slot, part[ix].value)
+			}
+		})
+		// every quotient of the partition lies within its range
+		for _, e := range part {
+			q := e.hv >> qf.rBits
+			sd := qf.read(q)
+			sd.setOccupied(true)
+			qf.write(q, sd)
+		}
+	})
+	for _, spill := range spills {
+		for _, s := range spill {
+			s.sd.setOccupied(qf.read(s.slot).occupied())
+			qf.write(s.slot, s.sd)
+			if qf.storage != nil {
+				qf.storage.Set(s.slot, s.value)
+			}
+		}
+	}
+}
+
+// eachPlacement computes where the sorted, distinct hashes of entries
+// are placed when their runs start no earlier than start, calling cb
+// with each slot (which may exceed the size of the table), the index of
+// the entry placed there and whether it starts a run.  It returns the
+// slot after the last entry placed
+func (qf *Filter) eachPlacement(entries []hashedEntry, start uint64, cb func(slot uint64, ix int, first bool)) uint64 {
+	slot := start
+	for i := range entries {
+		q := entries[i].hv >> qf.rBits
+		first := i == 0 || entries[i-1].hv>>qf.rBits != q
+		if first && q > slot {
+			slot = q
+		}
+		cb(slot, i, first)
+		slot++
+	}
+	return slot
+}
+
+// chunkBounds splits [0, n) into at most workers contiguous chunks
+func chunkBounds(n, workers int) [][2]int {
+	size := (n + workers - 1) / workers
+	if size == 0 {
+		size = 1
+	}
+	var chunks [][2]int
+	for lo := 0; lo < n; lo += size {
+		hi := lo + size
+		if hi > n {
+			hi = n
+		}
+		chunks = append(chunks, [2]int{lo, hi})
+	}
+	return chunks
+}
+
+// parallelChunks calls fn for contiguous chunks of [0, n) on up to
+// workers goroutines
+func parallelChunks(n, workers int, fn func(lo, hi int)) {
+	chunks := chunkBounds(n, workers)
+	parallelEach(len(chunks), workers, func(i int) {
+		fn(chunks[i][0], chunks[i][1])
+	})
+}
+
+// parallelEach calls fn for each of [0, n) on up to workers goroutines
+func parallelEach(n, workers int, fn func(i int)) {
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
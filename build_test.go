@@ -0,0 +1,146 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serialized(t testing.TB, qf *Filter) []byte {
+	var buf bytes.Buffer
+	_, err := qf.WriteTo(&buf)
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+// buildSequential inserts each key in turn, into a quotient filter
+// presized so that it needn't double
+func buildSequential(c Config, keys [][]byte, values []uint64) *Filter {
+	if c.ExpectedEntries < uint64(len(keys)) {
+		c.ExpectedEntries = uint64(len(keys))
+	}
+	qf := NewWithConfig(c)
+	for i, key := range keys {
+		var v uint64
+		if values != nil {
+			v = values[i]
+		}
+		qf.InsertWithValue(key, v)
+	}
+	return qf
+}
+
+func TestBuildParallel(t *testing.T) {
+	var keys [][]byte
+	var values []uint64
+	for i := 0; i < 20000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key %d", i)))
+		values = append(values, uint64(i))
+	}
+	// repeated keys, the last value wins
+	for i := 0; i < 100; i++ {
+		keys = append(keys, keys[i*7])
+		values = append(values, uint64(i))
+	}
+	configs := map[string]Config{
+		"unpacked":    {},
+		"packed":      {BitPacked: true},
+		"blocked":     {VectorAllocate: BlockedVectorAllocate},
+		"rank-select": {Layout: LayoutRankSelect},
+		"high load":   {BitPacked: true, LoadFactor: 0.95},
+	}
+	for name, c := range configs {
+		c.BitsOfStoragePerEntry = 16
+		c.ExpectedEntries = uint64(len(keys))
+		expect := serialized(t, buildSequential(c, keys, values))
+		for _, workers := range []int{1, 3, 8} {
+			qf, err := BuildParallel(c, keys, values, workers)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			assert.NoError(t, qf.Validate(), "%s with %d workers", name, workers)
+			assert.True(t, bytes.Equal(expect, serialized(t, qf)), "%s with %d workers", name, workers)
+		}
+	}
+}
+
+// clusters which cross partitions, and wrap around the end of the table
+func TestBuildParallelClusters(t *testing.T) {
+	c := Config{
+		BitsOfStoragePerEntry: 8,
+		ExpectedEntries:       1500,
+		LoadFactor:            0.75,
+		// the key is its own hash
+		HashFn: func(key []byte) uint64 {
+			return binary.BigEndian.Uint64(key)
+		},
+	}
+	size := uint64(1) << c.QBits()
+	qBits := c.QBits()
+	var keys [][]byte
+	add := func(q uint64, n int) {
+		for i := 0; i < n; i++ {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, q<<(64-qBits)|uint64(len(keys)))
+			keys = append(keys, key)
+		}
+	}
+	// pile up runs before the boundaries of 16 partitions
+	for p := uint64(1); p <= 16; p++ {
+		add(p*size/16-3, 40)
+	}
+	// and at the end of the table, which wrap around
+	add(size-2, 100)
+	add(0, 10)
+	values := make([]uint64, len(keys))
+	for i := range values {
+		values[i] = uint64(i) & 0xff
+	}
+	expect := buildSequential(c, keys, values)
+	assert.NoError(t, expect.Validate())
+	for _, workers := range []int{1, 2, 4} {
+		qf, err := BuildParallel(c, keys, values, workers)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, expect.size, qf.size)
+		assert.NoError(t, qf.Validate())
+		assert.True(t, bytes.Equal(serialized(t, expect), serialized(t, qf)), "%d workers", workers)
+	}
+}
+
+func TestBuildParallelFull(t *testing.T) {
+	keys := make([][]byte, 100)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key %d", i))
+	}
+	_, err := BuildParallel(Config{ExpectedEntries: 10, FixedCapacity: true}, keys, nil, 2)
+	assert.Equal(t, ErrFilterFull, err)
+}
+
+func BenchmarkBuildParallel(b *testing.B) {
+	keys := make([][]byte, 1<<20)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key %d", i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		BuildParallel(Config{BitPacked: true}, keys, nil, 0)
+	}
+}
+
+func BenchmarkBuildSequential(b *testing.B) {
+	keys := make([][]byte, 1<<20)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key %d", i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		NewWithConfig(Config{BitPacked: true}).InsertBatch(keys, nil)
+	}
+}
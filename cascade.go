@@ -0,0 +1,465 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+const (
+	// cascadeManifestName is the file in which a Cascade records its
+	// levels, it is replaced whole as levels change
+	cascadeManifestName = "MANIFEST"
+	// cascadeVersion is the version of the manifest
+	cascadeVersion = 1
+	// cascadeLevelPrefix begins the name of the file of every level,
+	// and of the temporary files written before they are renamed
+	cascadeLevelPrefix = "level-"
+	tmpSuffix          = ".tmp"
+
+	defaultMemoryEntries = 1 << 16
+	defaultGrowth        = 4
+)
+
+// CascadeConfig controls the behavior of a Cascade
+type CascadeConfig struct {
+	// Config configures the quotient filter of every level, its
+	// ExpectedEntries is ignored.  Its hash is used when the cascade is
+	// created, thereafter the hash the levels were built with is used
+	Config
+	// MemoryEntries is the number of entries the level in memory
+	// holds before it is flushed to disk, 65536 when zero
+	MemoryEntries uint64
+	// Growth is the ratio of the capacity of each level on disk to the
+	// one before, 4 when zero
+	Growth uint64
+}
+
+// cascadeManifest records the levels of a Cascade on disk
+type cascadeManifest struct {
+	Version int
+	// Sequence numbers the files of levels, so that none is reused
+	Sequence uint64
+	// Levels names the file of each level from the newest, or is
+	// empty for a level which is empty
+	Levels []string
+}
+
+// Cascade is a quotient filter made up of levels, like a log
+// structured merge tree.  Keys are inserted into a small Filter in
+// memory, which when full is merged into the first of a series of
+// exponentially larger levels on disk, each of which is merged into
+// the next as it outgrows its capacity.  Merges walk the levels in
+// hash order, and each level on disk is in the format written by
+// Filter.WriteTo and read as a Disk.  Lookups probe the levels from
+// the newest, so the most recently inserted value of a key wins.
+//
+// The levels on disk are listed in a manifest, and every merge writes
+// its level and then the manifest to temporary files which are renamed
+// over the originals, so a crash leaves the levels as they were before
+// or after the merge.  Keys in memory are lost unless flushed, by Flush
+// or Close.  A merge streams its level straight to disk, holding none
+// of it in memory, and a Cascade must not be used concurrently
+type Cascade struct {
+	dir                string
+	config             Config
+	memEntries, growth uint64
+	mem                *Filter
+	// levels holds the levels on disk from the newest, nil where empty
+	levels   []*Disk
+	manifest cascadeManifest
+}
+
+// OpenCascade opens the cascade in directory dir, creating it if it
+// does not exist.  Files left behind by merges which were interrupted
+// are removed
+func OpenCascade(dir string, c CascadeConfig) (*Cascade, error) {
+	if c.HashFn != nil {
+		return nil, errors.New("a cascade can't use a custom hash function, as its levels are read from disk")
+	}
+	if c.TTL != 0 {
+		return nil, errors.New("a cascade can't expire entries")
+	}
+	if c.ValueArena {
+		return nil, errors.New("a cascade can't have a value arena, as its levels are merged by their integer values")
+	}
+	if c.Multiset {
+		return nil, errors.New("a cascade can't be a multiset, as newer levels replace the values of older ones")
+	}
+	if len(c.Columns) != 0 {
+		return nil, errors.New("a cascade can't have columns, as its levels are merged by their hash values alone")
+	}
+	if c.FingerprintBits != 0 {
+		return nil, errors.New("a cascade can't have fingerprints, as its levels are read from disk")
+	}
+	cas := &Cascade{dir: dir, memEntries: c.MemoryEntries, growth: c.Growth}
+	if cas.memEntries == 0 {
+		cas.memEntries = defaultMemoryEntries
+	}
+	if cas.growth == 0 {
+		cas.growth = defaultGrowth
+	}
+	if cas.growth < 2 {
+		return nil, fmt.Errorf("cascade levels must grow, growth of %d is too small", cas.growth)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, cascadeManifestName))
+	switch {
+	case os.IsNotExist(err):
+		cas.manifest.Version = cascadeVersion
+	case err != nil:
+		return nil, err
+	default:
+		if err = json.Unmarshal(data, &cas.manifest); err != nil {
+			return nil, fmt.Errorf("invalid cascade manifest: %w", err)
+		}
+		if cas.manifest.Version != cascadeVersion {
+			return nil, fmt.Errorf("incompatible cascade manifest: version is %d, expected %d",
+				cas.manifest.Version, cascadeVersion)
+		}
+	}
+
+	c.Config.ExpectedEntries = cas.memEntries
+	c.Config.FixedCapacity = false
+	adopted := false
+	for _, name := range cas.manifest.Levels {
+		if name == "" {
+			cas.levels = append(cas.levels, nil)
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if !adopted {
+			// adopt the hash the levels were built with
+			adopted = true
+			h, err := ReadHeaderFromPath(path)
+			if err != nil {
+				cas.Close()
+				return nil, err
+			}
+			c.Config.HashAlgorithm = HashAlgorithm(h.HashAlgorithm)
+			c.Config.KeyedHash, c.Config.HashKey = h.KeyedHash, h.HashKey
+			c.Config.IntegerMixer = IntegerMixer(h.IntegerMixer)
+		}
+		ext, err := OpenReadOnlyFromPath(path)
+		if err != nil {
+			cas.Close()
+			return nil, err
+		}
+		cas.levels = append(cas.levels, ext)
+	}
+	cas.mem = NewWithConfig(c.Config)
+	// with any hash key generated for the level in memory
+	cas.config = cas.mem.config
+
+	if err = cas.removeStrays(); err != nil {
+		cas.Close()
+		return nil, err
+	}
+	return cas, nil
+}
+
+// removeStrays removes the files of levels which aren't in the manifest
+func (cas *Cascade) removeStrays() error {
+	live := map[string]bool{}
+	for _, name := range cas.manifest.Levels {
+		live[name] = true
+	}
+	entries, err := os.ReadDir(cas.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		stray := strings.HasPrefix(name, cascadeLevelPrefix) && !live[name]
+		if stray || name == cascadeManifestName+tmpSuffix {
+			if err = os.Remove(filepath.Join(cas.dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// capacity returns the number of entries level i on disk holds before
+// it is merged into the next
+func (cas *Cascade) capacity(i int) uint64 {
+	n := cas.memEntries
+	for ; i >= 0; i-- {
+		n *= cas.growth
+	}
+	return n
+}
+
+// Levels returns the number of levels on disk, including empty ones
+func (cas *Cascade) Levels() int {
+	return len(cas.levels)
+}
+
+// Len returns the number of entries in all levels, a key inserted
+// again after its level was flushed is counted in each level it is in
+func (cas *Cascade) Len() uint64 {
+	n := cas.mem.Len()
+	for _, l := range cas.levels {
+		if l != nil {
+			n += l.Len()
+		}
+	}
+	return n
+}
+
+// InsertWithValue stores the key (byte slice) and an integer value in
+// the cascade, flushing the level in memory to disk when it is full
+func (cas *Cascade) InsertWithValue(key []byte, value uint64) error {
+	cas.mem.InsertRawHash(cas.mem.hasher.sum(key), value)
+	if cas.mem.Len() >= cas.memEntries {
+		return cas.Flush()
+	}
+	return nil
+}
+
+// Insert stores the key (byte slice) in the cascade, see
+// InsertWithValue
+func (cas *Cascade) Insert(key []byte) error {
+	return cas.InsertWithValue(key, 0)
+}
+
+// InsertString stores the string key in the cascade, see
+// InsertWithValue
+func (cas *Cascade) InsertString(key string) error {
+	return cas.InsertWithValue(unsafe.Slice(unsafe.StringData(key), len(key)), 0)
+}
+
+// Lookup searches for key and returns whether it exists, and the value
+// most recently stored with it (if any)
+func (cas *Cascade) Lookup(key []byte) (bool, uint64) {
+	hv := cas.mem.hasher.sum(key)
+	if found, v := cas.mem.LookupRawHash(hv); found {
+		return true, v
+	}
+	for _, l := range cas.levels {
+		if l == nil {
+			continue
+		}
+		if found, v := l.LookupRawHash(hv); found {
+			return true, v
+		}
+	}
+	return false, 0
+}
+
+// LookupString searches for the string key, see Lookup
+func (cas *Cascade) LookupString(key string) (bool, uint64) {
+	return cas.Lookup(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// Contains returns whether the key (byte slice) is contained within
+// the cascade
+func (cas *Cascade) Contains(key []byte) bool {
+	found, _ := cas.Lookup(key)
+	return found
+}
+
+// ContainsString returns whether the string key is contained within
+// the cascade
+func (cas *Cascade) ContainsString(key string) bool {
+	found, _ := cas.LookupString(key)
+	return found
+}
+
+// Flush merges the level in memory into the first level on disk, and
+// merges each level which then exceeds its capacity into the next
+func (cas *Cascade) Flush() error {
+	if cas.mem.Len() == 0 {
+		return nil
+	}
+	if len(cas.levels) == 0 {
+		cas.levels = append(cas.levels, nil)
+	}
+	mem := cas.mem
+	newer := func() *hashCursor {
+		mv := mem.view()
+		return newHashCursor(&mv, mem.rBits)
+	}
+	if err := cas.mergeInto(0, newer, mem.Len()); err != nil {
+		return err
+	}
+	cas.mem = NewWithConfig(cas.config)
+
+	for i := 0; i < len(cas.levels); i++ {
+		l := cas.levels[i]
+		if l == nil || l.Len() <= cas.capacity(i) {
+			continue
+		}
+		if i+1 == len(cas.levels) {
+			cas.levels = append(cas.levels, nil)
+		}
+		var err error
+		if cas.levels[i+1] == nil {
+			// nothing to merge with, the level moves down whole
+			cas.levels[i], cas.levels[i+1] = nil, l
+			err = cas.commit(nil)
+		} else {
+			err = cas.mergeInto(i+1, l.hashes, l.Len())
+			if err == nil {
+				cas.levels[i] = nil
+				err = cas.commit([]*Disk{l})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeInto merges the hash values of newer, which holds entries, with
+// level i on disk (if any), replacing level i.  Where both hold the same
+// hash value the value of newer wins.  The merge is streamed straight
+// into the file of the new level
+func (cas *Cascade) mergeInto(i int, newer mergeSource, entries uint64) error {
+	old := cas.levels[i]
+	c := cas.config
+	c.ExpectedEntries = entries
+	var older mergeSource
+	if old != nil {
+		c.ExpectedEntries += old.Len()
+		older = old.hashes
+	}
+	ls, ok, err := newLevelStream(c, newer, older)
+	if err != nil {
+		return err
+	}
+	var level io.WriterTo = ls
+	if !ok {
+		// vectors of a custom allocator can't be streamed, so the
+		// level is built in memory
+		level = buildMerge(c, newer, older)
+	}
+	ext, err := cas.writeLevel(level)
+	if err != nil {
+		return err
+	}
+	cas.levels[i] = ext
+	var obsolete []*Disk
+	if old != nil {
+		obsolete = append(obsolete, old)
+	}
+	return cas.commit(obsolete)
+}
+
+// buildMerge builds the merge of newer and older (which may be nil) in
+// memory
+func buildMerge(c Config, newer, older mergeSource) *Filter {
+	merged := NewWithConfig(c)
+	m := newMergeCursor(newer, older)
+	for hv, value, ok := m.next(); ok; hv, value, ok = m.next() {
+		merged.InsertRawHash(hv, value)
+	}
+	return merged
+}
+
+// writeLevel writes level durably to the file of a new level, and opens
+// it
+func (cas *Cascade) writeLevel(level io.WriterTo) (*Disk, error) {
+	cas.manifest.Sequence++
+	name := fmt.Sprintf("%s%06d.qf", cascadeLevelPrefix, cas.manifest.Sequence)
+	err := writeFileAtomic(filepath.Join(cas.dir, name), func(w *bufio.Writer) error {
+		_, err := level.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return OpenReadOnlyFromPath(filepath.Join(cas.dir, name))
+}
+
+// commit records the levels in the manifest, then closes and removes
+// the files of obsolete levels
+func (cas *Cascade) commit(obsolete []*Disk) error {
+	cas.manifest.Levels = cas.manifest.Levels[:0]
+	for _, l := range cas.levels {
+		name := ""
+		if l != nil {
+			name = filepath.Base(l.f.Name())
+		}
+		cas.manifest.Levels = append(cas.manifest.Levels, name)
+	}
+	data, err := json.Marshal(&cas.manifest)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(filepath.Join(cas.dir, cascadeManifestName), func(w *bufio.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, l := range obsolete {
+		l.Close()
+		if err = os.Remove(l.f.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the level in memory to disk, and closes the levels
+func (cas *Cascade) Close() error {
+	var err error
+	if cas.mem != nil {
+		err = cas.Flush()
+	}
+	for _, l := range cas.levels {
+		if l != nil {
+			l.Close()
+		}
+	}
+	cas.levels = nil
+	return err
+}
+
+// writeFileAtomic writes the file at path through write, such that
+// after a crash it holds either its previous contents or all of what
+// was written.  It writes a temporary file which is synced and renamed
+// over path
+func writeFileAtomic(path string, write func(w *bufio.Writer) error) error {
+	tmp := path + tmpSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = write(w); err == nil {
+		if err = w.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// make the rename durable
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCascade(t *testing.T) {
+	dir := t.TempDir()
+	c := CascadeConfig{
+		Config:        Config{BitsOfStoragePerEntry: 16, KeyedHash: true},
+		MemoryEntries: 100,
+		Growth:        2,
+	}
+	cas, err := OpenCascade(dir, c)
+	assert.NoError(t, err)
+	for i := 0; i < 5000; i++ {
+		assert.NoError(t, cas.InsertWithValue([]byte(fmt.Sprintf("k%d", i)), uint64(i)))
+	}
+	assert.Greater(t, cas.Levels(), 3)
+	// newer values win over those merged into older levels
+	for i := 0; i < 5000; i += 10 {
+		assert.NoError(t, cas.InsertWithValue([]byte(fmt.Sprintf("k%d", i)), uint64(i+1)))
+	}
+	check := func(cas *Cascade) {
+		for i := 0; i < 5000; i++ {
+			found, v := cas.Lookup([]byte(fmt.Sprintf("k%d", i)))
+			assert.True(t, found)
+			want := uint64(i)
+			if i%10 == 0 {
+				want++
+			}
+			assert.Equal(t, want, v)
+		}
+		assert.False(t, cas.ContainsString("nope"))
+	}
+	check(cas)
+	// levels are merged as they outgrow their capacity
+	for i, l := range cas.levels {
+		if l != nil {
+			assert.LessOrEqual(t, l.Len(), cas.capacity(i))
+			assert.NoError(t, l.Validate())
+		}
+	}
+	assert.NoError(t, cas.Close())
+
+	// reopened, with the hash key it was created with
+	cas, err = OpenCascade(dir, c)
+	assert.NoError(t, err)
+	check(cas)
+	// keys inserted again may be counted in more than one level
+	assert.GreaterOrEqual(t, cas.Len(), uint64(5000))
+	assert.LessOrEqual(t, cas.Len(), uint64(5500))
+	assert.NoError(t, cas.InsertString("new"))
+	assert.NoError(t, cas.Flush())
+	assert.True(t, cas.ContainsString("new"))
+	assert.NoError(t, cas.Close())
+
+	// the files of interrupted merges are removed
+	for _, name := range []string{"level-999999.qf", "level-999999.qf.tmp", "MANIFEST.tmp"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("partial"), 0o644))
+	}
+	cas, err = OpenCascade(dir, c)
+	assert.NoError(t, err)
+	check(cas)
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Len(t, files, cas.Levels()-countNil(cas.levels)+1)
+	assert.NoError(t, cas.Close())
+
+	// a manifest naming a missing level fails
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, cascadeManifestName),
+		[]byte(`{"Version":1,"Sequence":1,"Levels":["level-000001.qf"]}`), 0o644))
+	_, err = OpenCascade(dir, c)
+	assert.Error(t, err)
+
+	_, err = OpenCascade(t.TempDir(), CascadeConfig{Config: Config{HashFn: FNV64}})
+	assert.Error(t, err)
+	// a merge would clear the columns of every entry
+	_, err = OpenCascade(t.TempDir(), CascadeConfig{Config: Config{Columns: []Column{{Name: "hits", Bits: 4}}}})
+	assert.Error(t, err)
+	_, err = OpenCascade(t.TempDir(), CascadeConfig{Growth: 1})
+	assert.Error(t, err)
+}
+
+func countNil(levels []*Disk) (n int) {
+	for _, l := range levels {
+		if l == nil {
+			n++
+		}
+	}
+	return
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"log"
@@ -16,6 +17,10 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// compileBatchSize is the number of terms inserted into the quotient
+// filter at a time when compiling
+const compileBatchSize = 1 << 16
+
 func main() {
 	app := &cli.App{
 		Commands: []*cli.Command{
@@ -39,6 +44,26 @@ func main() {
 						Aliases: []string{"p"},
 						Usage:   "whether to bitpack the output",
 					},
+					&cli.BoolFlag{
+						Name:    "blocked",
+						Aliases: []string{"b"},
+						Usage:   "whether to pack the output into cache line blocks",
+					},
+					&cli.BoolFlag{
+						Name:    "rank-select",
+						Aliases: []string{"r"},
+						Usage:   "whether to use the rank and select metadata layout",
+					},
+					&cli.StringFlag{
+						Name:  "hash",
+						Value: qf.HashMurmur.String(),
+						Usage: "hash function: murmur, fnv, xxh64, xxh3 or siphash (which implies --keyed)",
+					},
+					&cli.BoolFlag{
+						Name:    "keyed",
+						Aliases: []string{"k"},
+						Usage:   "whether to hash with a random secret key, which is stored in the output",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					output := c.String("output")
@@ -61,9 +86,30 @@ func main() {
 						reader = os.Stdin
 					}
 
-					filter := qf.NewWithConfig(qf.Config{BitPacked: c.Bool("bitpacked")})
+					layout := qf.LayoutClassic
+					if c.Bool("rank-select") {
+						layout = qf.LayoutRankSelect
+					}
+					hash, err := qf.ParseHashAlgorithm(c.String("hash"))
+					if err != nil {
+						return err
+					}
+					if hash == qf.HashCustom {
+						return fmt.Errorf("a custom hash function can't be selected by name")
+					}
+					config := qf.Config{
+						HashAlgorithm: hash,
+						BitPacked:     c.Bool("bitpacked"),
+						Layout:        layout,
+						KeyedHash:     c.Bool("keyed"),
+					}
+					if c.Bool("blocked") {
+						config.VectorAllocate = qf.BlockedVectorAllocate
+					}
+					filter := qf.NewWithConfig(config)
 					rdr := bufio.NewReader(reader)
 					start := time.Now()
+					batch := make([][]byte, 0, compileBatchSize)
 					for {
 						l, _, err := rdr.ReadLine()
 						if err != nil {
@@ -72,9 +118,13 @@ func main() {
 							}
 							return err
 						}
-						s := strings.TrimSpace(string(l))
-						filter.InsertString(s)
+						batch = append(batch, bytes.TrimSpace(append([]byte(nil), l...)))
+						if len(batch) == compileBatchSize {
+							filter.InsertBatch(batch, nil)
+							batch = batch[:0]
+						}
 					}
+					filter.InsertBatch(batch, nil)
 					log.Printf("built in memory quotient filter in %s", time.Since(start))
 					o, e := os.Create(output)
 					if e != nil {
@@ -131,12 +181,93 @@ func main() {
 						return fmt.Errorf("describe: can't read input file: %w", err)
 					}
 					fmt.Printf("Quotient filter version %d\n", h.Version)
-					not := "not "
-					if h.BitPacked {
-						not = ""
+					format := "not bitpacked"
+					if h.Blocked {
+						format = "cache line blocked"
+					} else if h.BitPacked {
+						format = "bitpacked"
+					}
+					fmt.Printf("%s - %d entries, %d quotient bits, %d storage bits\n",
+						format, h.Entries, h.QBits, h.StorageBits)
+					fmt.Printf("%s layout\n", qf.Layout(h.Layout))
+					if h.FixedCapacity {
+						fmt.Printf("fixed capacity at %0.3f loading\n", h.LoadFactor)
+					} else {
+						fmt.Printf("doubles at %0.3f loading\n", h.LoadFactor)
+					}
+					if h.FingerprintBits != 0 {
+						fmt.Printf("%d bit fingerprints, %d bits for new entries\n", h.FingerprintBits, h.FingerprintWidth)
+					}
+					fmt.Printf("integer keys hashed with %s\n", qf.IntegerMixer(h.IntegerMixer))
+					fmt.Printf("keys hashed with %s\n", qf.HashAlgorithm(h.HashAlgorithm))
+					if h.ValueArena {
+						fmt.Printf("%d byte value arena, %d bytes overwritten\n", h.ArenaBytes, h.ArenaGarbage)
+					}
+					if h.TTL != 0 {
+						fmt.Printf("entries expire after %s, %d storage bits hold their epoch\n",
+							time.Duration(h.TTL), h.EpochBits)
+					}
+					if h.Multiset {
+						fmt.Printf("multiset of up to %d values per key\n", h.MaxValuesPerKey)
+					}
+					if h.Columns != 0 {
+						fmt.Printf("%d value columns\n", h.Columns)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "scan a quotient filter and report on its structure",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "input",
+						Aliases: []string{"in", "i"},
+						Usage:   "file containing quotient filter",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					filter, err := qf.OpenReadOnlyFromPath(c.String("i"))
+					if err != nil {
+						return fmt.Errorf("stats: can't read input file: %w", err)
+					}
+					defer filter.Close()
+					s := filter.Stats()
+					fmt.Printf("%d slots, %d entries (loaded %0.3f)\n", s.Size, s.Entries, s.LoadFactor)
+					fmt.Printf("%d runs, %d clusters, %d shifted slots\n", s.Runs, s.Clusters, s.ShiftedSlots)
+					fmt.Printf("cluster length: max %d, mean %0.2f, p99 %d\n",
+						s.MaxClusterLength, s.MeanClusterLength, s.P99ClusterLength)
+					fmt.Printf("average probe length: %0.2f\n", s.AverageProbeLength)
+					fmt.Printf("filter bytes: %d, storage bytes: %d\n", s.FilterBytes, s.StorageBytes)
+					fmt.Printf("run length histogram:\n")
+					for length, count := range s.RunLengthHistogram {
+						if count > 0 {
+							fmt.Printf("  %4d: %d\n", length, count)
+						}
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "fsck",
+				Usage: "verify the structural consistency of a quotient filter",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "input",
+						Aliases: []string{"in", "i"},
+						Usage:   "file containing quotient filter",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					filter, err := qf.OpenReadOnlyFromPath(c.String("i"))
+					if err != nil {
+						return fmt.Errorf("fsck: can't read input file: %w", err)
+					}
+					defer filter.Close()
+					if err := filter.Validate(); err != nil {
+						return fmt.Errorf("fsck: %w", err)
 					}
-					fmt.Printf("%sbitpacked - %d entries, %d quotient bits, %d storage bits\n",
-						not, h.Entries, h.QBits, h.StorageBits)
+					fmt.Printf("%s: ok, %d entries\n", c.String("i"), filter.Len())
 					return nil
 				},
 			},
@@ -0,0 +1,238 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+const (
+	// maxColumnName is the longest column name supported, in bytes
+	maxColumnName = 255
+	// maxColumns bounds the number of columns read from a file, so
+	// that a corrupt header can't cause a huge allocation
+	maxColumns = 1 << 16
+)
+
+// Column describes one of the named columns of integer values kept
+// with each entry, see Config.Columns
+type Column struct {
+	// Name identifies the column, it must be unique and non-empty
+	Name string
+	// Bits is the width of the column, between 1 and 64
+	Bits uint
+}
+
+// mask returns the bits of a value which the column can hold
+func (c Column) mask() uint64 {
+	if c.Bits >= bitsPerWord {
+		return ^uint64(0)
+	}
+	return 1<<c.Bits - 1
+}
+
+// validColumns checks that a schema of columns can be allocated and
+// serialized
+func validColumns(cols []Column) error {
+	seen := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		switch {
+		case c.Name == "" || len(c.Name) > maxColumnName:
+			return fmt.Errorf("column name %q must be between 1 and %d bytes", c.Name, maxColumnName)
+		case seen[c.Name]:
+			return fmt.Errorf("column %q is defined twice", c.Name)
+		case c.Bits == 0 || c.Bits > bitsPerWord:
+			return fmt.Errorf("column %q has %d bits, must be between 1 and %d", c.Name, c.Bits, bitsPerWord)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// columnBits reports the total width of a schema of columns
+func columnBits(cols []Column) (bits uint) {
+	for _, c := range cols {
+		bits += c.Bits
+	}
+	return
+}
+
+// columnIndex returns the index of the column called name, or -1
+func columnIndex(cols []Column, name string) int {
+	for i, c := range cols {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// namedColumn is columnIndex, which panics when there is no such column
+func namedColumn(cols []Column, name string) int {
+	i := columnIndex(cols, name)
+	if i < 0 {
+		panic(fmt.Sprintf("no column named %q", name))
+	}
+	return i
+}
+
+// writeColumns writes the schema of columns, which follows the header,
+// as the width and name length of each column followed by its name
+func writeColumns(stream io.Writer, cols []Column) (i int64, err error) {
+	for _, c := range cols {
+		if err = binary.Write(stream, binary.LittleEndian, [2]uint64{uint64(c.Bits), uint64(len(c.Name))}); err != nil {
+			return
+		}
+		i += 2 * bytesPerWord
+		var n int
+		n, err = io.WriteString(stream, c.Name)
+		i += int64(n)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readColumns reads a schema of n columns written by writeColumns
+func readColumns(stream io.Reader, n uint64) (cols []Column, i int64, err error) {
+	if n > maxColumns {
+		return nil, 0, fmt.Errorf("invalid file format, %d columns", n)
+	}
+	cols = make([]Column, n)
+	for j := range cols {
+		var w [2]uint64
+		if err = binary.Read(stream, binary.LittleEndian, &w); err != nil {
+			return
+		}
+		i += 2 * bytesPerWord
+		if w[1] > maxColumnName {
+			return nil, i, fmt.Errorf("invalid file format, column name is %d bytes", w[1])
+		}
+		name := make([]byte, w[1])
+		var np int
+		np, err = io.ReadFull(stream, name)
+		i += int64(np)
+		if err != nil {
+			return
+		}
+		cols[j] = Column{Name: string(name), Bits: uint(w[0])}
+	}
+	if err = validColumns(cols); err != nil {
+		return nil, i, fmt.Errorf("invalid file format, %w", err)
+	}
+	return
+}
+
+// clearColumns zeroes the columns of a new entry at slot
+func (qf *Filter) clearColumns(slot uint64) {
+	for _, col := range qf.columns {
+		col.Set(slot, 0)
+	}
+}
+
+// Columns returns the schema of columns kept with each entry
+func (qf *Filter) Columns() []Column {
+	return append([]Column(nil), qf.config.Columns...)
+}
+
+// ColumnIndex returns the index of the column called name, or -1 if
+// there is no such column
+func (qf *Filter) ColumnIndex(name string) int {
+	return columnIndex(qf.config.Columns, name)
+}
+
+// entrySlot returns whether the key with hash hv is present, and the
+// slot holding it
+func (qf *Filter) entrySlot(hv uint64) (bool, uint64) {
+	v := qf.view()
+	found, slot := v.lookupSlot(hv>>qf.rBits, hv&qf.rMask)
+	if found && qf.expiry != nil {
+		found, _ = qf.expiry.visible(true, qf.storage.Get(slot))
+	}
+	return found, slot
+}
+
+// Get searches for key and returns whether it exists, and the value of
+// column col for it.  It panics if there is no such column
+func (qf *Filter) Get(key []byte, col int) (bool, uint64) {
+	c := qf.columns[col]
+	found, slot := qf.entrySlot(qf.hasher.sum(key))
+	if !found {
+		return false, 0
+	}
+	return true, c.Get(slot)
+}
+
+// Set sets the value of column col for key, truncated to the width of
+// the column, and returns whether key exists.  Keys which don't exist
+// aren't inserted.  It panics if there is no such column
+func (qf *Filter) Set(key []byte, col int, value uint64) bool {
+	mask := qf.config.Columns[col].mask()
+	found, slot := qf.entrySlot(qf.hasher.sum(key))
+	if found {
+		qf.columns[col].Set(slot, value&mask)
+	}
+	return found
+}
+
+// GetNamed is like Get, for the column called name
+func (qf *Filter) GetNamed(key []byte, name string) (bool, uint64) {
+	return qf.Get(key, namedColumn(qf.config.Columns, name))
+}
+
+// SetNamed is like Set, for the column called name
+func (qf *Filter) SetNamed(key []byte, name string, value uint64) bool {
+	return qf.Set(key, namedColumn(qf.config.Columns, name), value)
+}
+
+// GetString is like Get, for a string key
+func (qf *Filter) GetString(key string, col int) (bool, uint64) {
+	return qf.Get(unsafe.Slice(unsafe.StringData(key), len(key)), col)
+}
+
+// SetString is like Set, for a string key
+func (qf *Filter) SetString(key string, col int, value uint64) bool {
+	return qf.Set(unsafe.Slice(unsafe.StringData(key), len(key)), col, value)
+}
+
+// Columns returns the schema of columns kept with each entry
+func (ext *Disk) Columns() []Column {
+	return append([]Column(nil), ext.columns...)
+}
+
+// ColumnIndex returns the index of the column called name, or -1 if
+// there is no such column
+func (ext *Disk) ColumnIndex(name string) int {
+	return columnIndex(ext.columns, name)
+}
+
+// Get searches for key and returns whether it exists, and the value of
+// column col for it.  It panics if there is no such column.  Entries
+// which expire do so by the wall clock
+func (ext *Disk) Get(key []byte, col int) (bool, uint64) {
+	read := mustRead(ext.columnsRead[col])
+	hv := ext.hasher.sum(key)
+	v := ext.view(false)
+	found, slot := v.lookupSlot(hv>>ext.rBits, hv&ext.rMask)
+	if found && ext.expiry != nil {
+		found, _ = ext.expiry.visible(true, v.storage(slot))
+	}
+	if !found {
+		return false, 0
+	}
+	return true, read(slot)
+}
+
+// GetNamed is like Get, for the column called name
+func (ext *Disk) GetNamed(key []byte, name string) (bool, uint64) {
+	return ext.Get(key, namedColumn(ext.columns, name))
+}
+
+// GetString is like Get, for a string key
+func (ext *Disk) GetString(key string, col int) (bool, uint64) {
+	return ext.Get(unsafe.Slice(unsafe.StringData(key), len(key)), col)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumns(t *testing.T) {
+	schema := []Column{{Name: "count", Bits: 12}, {Name: "flags", Bits: 3}, {Name: "wide", Bits: 64}}
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8, Columns: schema, Layout: layout})
+		count, flags, wide := qf.ColumnIndex("count"), qf.ColumnIndex("flags"), qf.ColumnIndex("wide")
+		assert.Equal(t, []int{0, 1, 2}, []int{count, flags, wide})
+		assert.Equal(t, -1, qf.ColumnIndex("nope"))
+		assert.Equal(t, schema, qf.Columns())
+
+		// set columns as entries are inserted, so that every insert
+		// shifts columns which have already been set, and the filter
+		// doubles a few times
+		const n = 3000
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("k%d", i)
+			qf.InsertStringWithValue(key, uint64(i%256))
+			assert.True(t, qf.SetString(key, count, uint64(i)))
+			assert.True(t, qf.SetNamed([]byte(key), "flags", uint64(i%8)))
+			assert.True(t, qf.SetString(key, wide, ^uint64(i)))
+		}
+		assert.False(t, qf.SetString("nope", count, 1))
+		// updating an entry keeps its columns, new entries start at zero
+		qf.InsertStringWithValue("k1", 99)
+		qf.InsertString("fresh")
+		found, v := qf.GetString("fresh", count)
+		assert.True(t, found)
+		assert.Zero(t, v)
+		// values are truncated to the width of the column
+		qf.SetString("fresh", flags, 0xf)
+		_, v = qf.GetNamed([]byte("fresh"), "flags")
+		assert.Equal(t, uint64(7), v)
+
+		type getter interface {
+			GetString(string, int) (bool, uint64)
+			GetNamed([]byte, string) (bool, uint64)
+			LookupString(string) (bool, uint64)
+		}
+		check := func(r getter) {
+			for i := 0; i < n; i++ {
+				key := fmt.Sprintf("k%d", i)
+				found, v := r.GetString(key, count)
+				assert.True(t, found)
+				assert.Equal(t, uint64(i), v)
+				_, v = r.GetNamed([]byte(key), "flags")
+				assert.Equal(t, uint64(i%8), v)
+				_, v = r.GetString(key, wide)
+				assert.Equal(t, ^uint64(i), v)
+				want := uint64(i % 256)
+				if i == 1 {
+					want = 99
+				}
+				_, v = r.LookupString(key)
+				assert.Equal(t, want, v)
+			}
+			found, _ := r.GetString("nope", count)
+			assert.False(t, found)
+		}
+		check(qf)
+
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		var cpy Filter
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, schema, cpy.Columns())
+		check(&cpy)
+
+		name, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		assert.Equal(t, schema, ext.Columns())
+		assert.Equal(t, wide, ext.ColumnIndex("wide"))
+		check(ext)
+		assert.Equal(t, qf.Stats().StorageBytes, ext.Stats().StorageBytes)
+		ext.Close()
+		os.Remove(name)
+
+		assert.Panics(t, func() { qf.GetNamed([]byte("k1"), "nope") })
+		assert.Panics(t, func() { qf.Set([]byte("k1"), 3, 1) })
+	}
+
+	for _, bad := range [][]Column{
+		{{Name: "", Bits: 1}},
+		{{Name: "a", Bits: 0}},
+		{{Name: "a", Bits: 65}},
+		{{Name: "a", Bits: 1}, {Name: "a", Bits: 2}},
+	} {
+		assert.Panics(t, func() { NewWithConfig(Config{Columns: bad}) })
+	}
+}
+
+func TestColumnsExpire(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	qf := NewWithConfig(Config{TTL: time.Minute, Clock: clock.now, Columns: []Column{{Name: "c", Bits: 8}}})
+	qf.InsertString("old")
+	qf.SetString("old", 0, 1)
+	clock.t = clock.t.Add(30 * time.Second)
+	qf.InsertString("new")
+	qf.SetString("new", 0, 2)
+	clock.t = clock.t.Add(45 * time.Second)
+	found, _ := qf.GetString("old", 0)
+	assert.False(t, found)
+	assert.False(t, qf.SetString("old", 0, 3))
+	assert.Equal(t, uint64(1), qf.Expire(clock.t))
+	found, v := qf.GetString("new", 0)
+	assert.True(t, found)
+	assert.Equal(t, uint64(2), v)
+}
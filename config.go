@@ -2,7 +2,10 @@
 
 package qf
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // minQBits is the initial number of quotient bits when no explicit
 // configuration is provided, and the minimum number of qbits supported
@@ -27,12 +30,129 @@ type Config struct {
 	// hold the expected number of entries without exceeding a reasonable
 	// loading factor
 	ExpectedEntries uint64
+	// HashAlgorithm selects one of the hash functions provided by this
+	// package, the default is HashMurmur.  Unlike HashFn it is recorded
+	// in the serialized quotient filter
+	HashAlgorithm HashAlgorithm
 	// HashFn may be specified to over-ride the default used by the
 	// implementation (64 bit murmur hash).  When over-ridded, caller must
 	// take care that when a quotient filter is loaded the hash function
 	// is set to the same hash function used when populating the quotient
-	// filter
+	// filter, and it can't be opened with OpenReadOnlyFromPath
 	HashFn HashFn
+	// LoadFactor is the loading at which the quotient filter is
+	// considered full, and is used to initially size the table.  It
+	// must be between 0 and 1, when zero MaxLoadingFactor is used
+	LoadFactor float64
+	// FixedCapacity, when true, prevents the quotient filter from ever
+	// doubling.  Inserting a new key into a full quotient filter fails
+	// with ErrFilterFull
+	FixedCapacity bool
+	// SegmentedGrowth, when true, allocates the tables of the quotient
+	// filter in segments, so that as it doubles the old table is
+	// released while it is copied and peak memory stays close to the
+	// size of the new table, rather than three times the old.  Lookups
+	// pay for an extra indirection through the segments, so otherwise
+	// tables are allocated whole.  A table read by ReadFrom is whole
+	// until it first doubles
+	SegmentedGrowth bool
+	// Layout selects the arrangement of slots and metadata, the default
+	// is LayoutClassic
+	Layout Layout
+	// VectorAllocate may be specified to over-ride the storage selected
+	// by BitPacked, for instance BlockedVectorAllocate.  Only the
+	// vectors provided by this package may be read by OpenReadOnlyFromPath
+	VectorAllocate VectorAllocateFn
+	// IntegerMixer selects the hash used by InsertUint64 and friends,
+	// the default is MixMurmur3.  It is recorded in the serialized
+	// quotient filter
+	IntegerMixer IntegerMixer
+	// KeyedHash, when true, hashes keys with SipHash-2-4 keyed by a
+	// per filter secret, so that keys which collide can't be crafted
+	// without it.  The key is recorded in the serialized quotient
+	// filter, which must be kept as private as the key itself.  It may
+	// not be combined with HashFn
+	KeyedHash bool
+	// HashKey is the secret used when KeyedHash is set.  When zero,
+	// NewWithConfig generates a random one
+	HashKey [16]byte
+	// TTL, when non-zero, expires entries once they are TTL old.  The
+	// epoch in which each entry was inserted (or last updated) is kept
+	// in EpochBits bits of storage above the BitsOfStoragePerEntry
+	// which hold its value.  The TTL spans half the epochs which can be
+	// represented, so entries live for between TTL and TTL plus one
+	// epoch.  Expired entries are absent to lookups but occupy the
+	// quotient filter until removed by Expire, by doubling, or by the
+	// first insertion a TTL after either, which sweeps them so that
+	// the epoch of every entry can be told despite wrapping around
+	TTL time.Duration
+	// EpochBits is the number of bits of storage which record the
+	// epoch of each entry when TTL is set, between 3 and 32.  When zero
+	// 8 bits are used, for epochs of TTL/128
+	EpochBits uint
+	// ValueArena, when true, stores a byte string value with each entry
+	// (see InsertWithBytes) in an append-only arena, and the offset
+	// and length of the value in its storage.  BitsOfStoragePerEntry
+	// must be zero, the storage is sized for the arena.  Keys can't be
+	// inserted with integer values, those inserted without a value
+	// have an empty one
+	ValueArena bool
+	// Columns, when set, keeps a named column of integer values of its
+	// own width with each entry, in addition to the storage.  Columns
+	// of new entries are zero, see Filter.Get and Filter.Set
+	Columns []Column
+	// Multiset, when true, keeps each distinct value inserted with a
+	// key rather than overwriting its value, see LookupAll and
+	// DeleteValue.  Every value is an entry of its own.  Values are
+	// truncated to BitsOfStoragePerEntry, which must be non-zero, and
+	// it may not be combined with ValueArena, TTL or Columns
+	Multiset bool
+	// MaxValuesPerKey caps the number of values of each key of a
+	// multiset, inserting another fails with ErrTooManyValues.  When
+	// zero 16 values are allowed
+	MaxValuesPerKey uint
+	// FingerprintBits, when non-zero, stores a fingerprint of this many
+	// bits of the hash of each key rather than the rest of its hash,
+	// for a false positive rate of about 2^-FingerprintBits, and
+	// expands as InfiniFilter does.  Each doubling moves a bit of every
+	// fingerprint into the quotient, so older entries keep ever
+	// shorter fingerprints, marked with their length, while new entries
+	// get fingerprints a bit wider for each doubling.  The false
+	// positive rate stays steady as the quotient filter grows, at the
+	// cost of a bit per slot for each doubling.  An aged entry can't be
+	// told from another key's, so inserting its key again adds an entry,
+	// and lookups return the value of the longest matching fingerprint.
+	// It may not be combined with Multiset, TTL, ValueArena or Columns,
+	// and the quotient filter can't be read by OpenReadOnlyFromPath
+	FingerprintBits uint
+	// Clock returns the current time used to expire entries, and to
+	// advance a WindowFilter, it is time.Now when nil
+	Clock func() time.Time
+}
+
+func (c *Config) loadFactor() float64 {
+	if c.LoadFactor == 0 {
+		return MaxLoadingFactor
+	}
+	return c.LoadFactor
+}
+
+// epochBits returns the number of bits of storage per entry which
+// record its epoch, see TTL
+func (c *Config) epochBits() uint {
+	switch {
+	case c.TTL == 0:
+		return 0
+	case c.EpochBits == 0:
+		return defaultEpochBits
+	}
+	return c.EpochBits
+}
+
+// integerKey returns the word which integer keys are combined with
+// before mixing, see integerKey
+func (c *Config) integerKey() uint64 {
+	return integerKey(c.KeyedHash, c.HashKey)
 }
 
 // ExpectedLoading reports the expected percentage loading given the
@@ -44,7 +164,12 @@ func (c *Config) ExpectedLoading() float64 {
 // BytesRequired reports the approximate amount of space required to represent
 // the quotient filter on disk or in ram (assuming bit packing).
 func (c *Config) BytesRequired() uint {
-	bitsPerEntry := (64 - c.QBits()) + 3 + uint(c.BitsOfStoragePerEntry)
+	rBits := 64 - c.QBits()
+	if c.FingerprintBits != 0 {
+		// and a marker bit
+		rBits = fingerprintWidth(c.FingerprintBits-1, c.QBits()) + 1
+	}
+	bitsPerEntry := rBits + 3 + uint(c.BitsOfStoragePerEntry) + c.epochBits() + columnBits(c.Columns)
 	return c.BucketCount() * bitsPerEntry / 8
 }
 
@@ -59,7 +184,7 @@ func (c *Config) BucketCount() uint {
 func (c *Config) QBits() uint {
 	x := uint(1)
 	bits := uint(0)
-	for (float64(x) * MaxLoadingFactor) < float64(c.ExpectedEntries) {
+	for (float64(x) * c.loadFactor()) < float64(c.ExpectedEntries) {
 		x <<= 1
 		bits++
 	}
@@ -72,9 +197,25 @@ func (c *Config) QBits() uint {
 // ExplainIndent will print an indented summary of the configuration to stdout
 func (c *Config) ExplainIndent(indent string) {
 	fmt.Printf("%s%2d bits configured for quotient (%d buckets)\n", indent, c.QBits(), c.BucketCount())
-	fmt.Printf("%s%2d bits needed per bucket for remainder\n", indent, bitsPerWord-c.QBits())
+	if c.FingerprintBits != 0 {
+		fmt.Printf("%s%2d bits needed per bucket for fingerprint, growing a bit as it doubles\n", indent, fingerprintWidth(c.FingerprintBits-1, c.QBits())+1)
+	} else {
+		fmt.Printf("%s%2d bits needed per bucket for remainder\n", indent, bitsPerWord-c.QBits())
+	}
 	fmt.Printf("%s%2d bits metadata per bucket\n", indent, 3)
 	fmt.Printf("%s%2d bits external storage\n", indent, c.BitsOfStoragePerEntry)
+	if c.TTL != 0 {
+		fmt.Printf("%s%2d bits epoch, entries expire after %s\n", indent, c.epochBits(), c.TTL)
+	}
+	for _, col := range c.Columns {
+		fmt.Printf("%s%2d bits column %s\n", indent, col.Bits, col.Name)
+	}
+	if c.Multiset {
+		fmt.Printf("%s   multiset of up to %d values per key\n", indent, c.maxValuesPerKey())
+	}
+	if c.SegmentedGrowth {
+		fmt.Printf("%s   tables allocated in segments of %d slots\n", indent, segmentSlots)
+	}
 	fmt.Printf("%s   %s storage size expected\n", indent, humanBytes(c.BytesRequired()))
 }
 
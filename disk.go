@@ -5,12 +5,17 @@ package qf
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"unsafe"
 )
 
 type extReader interface {
 	Read(ix uint64) (val uint64, err error)
+	// withReaderAt returns a copy of the reader which reads through r
+	withReaderAt(r io.ReaderAt) extReader
+	// bytes reports the size of the vector data on disk
+	bytes() uint64
 }
 
 // Disk is a read-only quotient filter that interacts with a
@@ -18,12 +23,26 @@ type extReader interface {
 type Disk struct {
 	entries                 uint64
 	size                    uint64
-	hashfn                  HashFn
+	hasher                  keyHash
+	mixer                   IntegerMixer
+	mixKey                  uint64
 	rBits                   uint
 	rMask                   uint64
 	f                       *os.File
 	filterRead, storageRead extReader
-	storageBits             uint
+	// the block metadata of the rank and select layout, if in use
+	rsRead      []extReader
+	storageBits uint
+	// expiry is set when entries expire, see Config.TTL
+	expiry *expiry
+	// arenaOff is the position in the file of the value arena, or -1
+	// when there is none
+	arenaOff int64
+	// the schema of columns, and the readers of their vectors
+	columns     []Column
+	columnsRead []extReader
+	// multiset is set when a key may have many values
+	multiset bool
 }
 
 // OpenReadOnlyFromFile initializes a read only quotient filter
@@ -33,44 +52,133 @@ func OpenReadOnlyFromPath(path string) (*Disk, error) {
 	if err != nil {
 		return nil, err
 	}
+	ext, err := openDisk(rdr)
+	if err != nil {
+		rdr.Close()
+		return nil, err
+	}
+	return ext, nil
+}
+
+func openDisk(rdr *os.File) (*Disk, error) {
 	// read header
 	var h QFHeader
-	if err = binary.Read(rdr, binary.LittleEndian, &h); err != nil {
+	if err := binary.Read(rdr, binary.LittleEndian, &h); err != nil {
 		return nil, err
 	}
+	if h.Version != qfVersion {
+		return nil, fmt.Errorf("incompatible file format: version is %d, expected %d",
+			h.Version, qfVersion)
+	}
+	if h.FingerprintBits != 0 {
+		return nil, fmt.Errorf("a quotient filter with fingerprints can't be read from disk, read it with ReadFrom")
+	}
 	var ext Disk
+	var err error
+	if ext.columns, _, err = readColumns(rdr, h.Columns); err != nil {
+		return nil, err
+	}
 	ext.f = rdr
+	ext.multiset = h.Multiset
 	ext.entries = h.Entries
 	ext.rBits, ext.rMask, ext.size = initForQuotientBits(uint(h.QBits))
-	ext.storageBits = uint(h.StorageBits)
-	if h.BitPacked {
-		ext.filterRead, err = initPackedDiskReader(rdr)
-		if err != nil {
-			return nil, err
+	initReader := func(f *os.File) (extReader, error) {
+		return initUnpackedDiskReader(f)
+	}
+	if h.Blocked {
+		initReader = func(f *os.File) (extReader, error) {
+			return initBlockedDiskReader(f)
 		}
-		if h.StorageBits > 0 {
-			ext.storageRead, err = initPackedDiskReader(rdr)
-			if err != nil {
-				return nil, err
-			}
+	} else if h.BitPacked {
+		initReader = func(f *os.File) (extReader, error) {
+			return initPackedDiskReader(f)
 		}
-	} else {
-		ext.filterRead, err = initUnpackedDiskReader(rdr)
-		if err != nil {
+	}
+	if ext.filterRead, err = initReader(rdr); err != nil {
+		return nil, err
+	}
+	if h.StorageBits > 0 {
+		if ext.storageRead, err = initReader(rdr); err != nil {
+			return nil, err
+		}
+	}
+	ext.columnsRead = make([]extReader, len(ext.columns))
+	for i := range ext.columnsRead {
+		if ext.columnsRead[i], err = initReader(rdr); err != nil {
 			return nil, err
 		}
-		if h.StorageBits > 0 {
-			ext.storageRead, err = initUnpackedDiskReader(rdr)
-			if err != nil {
+	}
+	switch Layout(h.Layout) {
+	case LayoutClassic:
+	case LayoutRankSelect:
+		// occupieds, runends and offsets
+		ext.rsRead = make([]extReader, 3)
+		for i := range ext.rsRead {
+			if ext.rsRead[i], err = initReader(rdr); err != nil {
 				return nil, err
 			}
 		}
+	default:
+		return nil, fmt.Errorf("unsupported quotient filter layout: %s", Layout(h.Layout))
+	}
+	if ext.mixer = IntegerMixer(h.IntegerMixer); !ext.mixer.valid() {
+		return nil, fmt.Errorf("unsupported integer mixer: %s", ext.mixer)
+	}
+	if ext.hasher, err = h.keyHash(nil); err != nil {
+		return nil, err
+	}
+	ext.mixKey = integerKey(h.KeyedHash, h.HashKey)
+	if ext.expiry, err = h.expiry(nil); err != nil {
+		return nil, err
+	}
+	ext.storageBits = uint(h.StorageBits - h.EpochBits)
+	ext.arenaOff = -1
+	if h.ValueArena {
+		// the arena follows everything else
+		if ext.arenaOff, err = rdr.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
 	}
-	// XXX: handle variable hash functions
-	ext.hashfn = murmurhash64
 	return &ext, nil
 }
 
+// view returns a slotView reading the quotient filter on disk.  When
+// cached is set reads go through page caches, which is appropriate
+// for sequential scans and sorted probes
+func (ext *Disk) view(cached bool) slotView {
+	via := func(r extReader) extReader {
+		if cached && r != nil {
+			return r.withReaderAt(&pageCache{r: ext.f})
+		}
+		return r
+	}
+	filterFn, storageFn := readFns(via(ext.filterRead), via(ext.storageRead))
+	v := slotView{filter: filterFn, storage: storageFn, size: ext.size}
+	if ext.rsRead != nil {
+		v.rs = &rsReader{
+			occupieds:  mustRead(via(ext.rsRead[0])),
+			runends:    mustRead(via(ext.rsRead[1])),
+			offsets:    mustRead(via(ext.rsRead[2])),
+			remainders: filterFn,
+			blocks:     rsBlocks(ext.size),
+		}
+	}
+	return v
+}
+
+// hashes opens a cursor over the hash values of ext, read through a
+// page cache
+func (ext *Disk) hashes() *hashCursor {
+	v := ext.view(true)
+	return newHashCursor(&v, ext.rBits)
+}
+
+// filterReaders returns the readers of the vectors making up the
+// filter, but not the external storage
+func (ext *Disk) filterReaders() []extReader {
+	return append([]extReader{ext.filterRead}, ext.rsRead...)
+}
+
 // BitsOfStoragePerEntry reports the number of bits of integer storage associated
 // with each entry in the quotient filter
 func (ext *Disk) BitsOfStoragePerEntry() uint {
@@ -111,26 +219,40 @@ func (ext *Disk) ContainsString(s string) bool {
 // Lookup checks whether the byte string is stored within the quotient filter and
 // returns a boolean indicating its presence and external integer data if applicable
 func (ext *Disk) Lookup(key []byte) (bool, uint64) {
-	dq, dr := hash(ext.hashfn, key, ext.rBits, ext.rMask)
+	return ext.LookupRawHash(ext.hasher.sum(key))
+}
+
+// LookupRawHash searches for a pre-calculated raw hash value, see
+// Filter.InsertRawHash, and returns whether it exists and the value
+// stored with it (if any).  Entries which expire do so by the wall
+// clock
+func (ext *Disk) LookupRawHash(hv uint64) (bool, uint64) {
+	v := ext.view(false)
+	if ext.expiry != nil {
+		return ext.expiry.visible(v.lookup(hv>>ext.rBits, hv&ext.rMask))
+	}
+	return v.lookup(hv>>ext.rBits, hv&ext.rMask)
+}
 
-	var filterFn, storageFn readFn
-	filterFn = func(v uint64) uint64 {
-		x, err := ext.filterRead.Read(v)
+// readFns adapts the filter and (optional) storage readers into
+// readFns which panic on i/o error
+func readFns(filterRead, storageRead extReader) (filterFn, storageFn readFn) {
+	filterFn = mustRead(filterRead)
+	if storageRead != nil {
+		storageFn = mustRead(storageRead)
+	}
+	return
+}
+
+// mustRead adapts an extReader into a readFn which panics on i/o error
+func mustRead(r extReader) readFn {
+	return func(v uint64) uint64 {
+		x, err := r.Read(v)
 		if err != nil {
 			panic(fmt.Sprintf("error: %s", err))
 		}
 		return x
 	}
-	if ext.storageRead != nil {
-		storageFn = func(v uint64) uint64 {
-			x, err := ext.storageRead.Read(v)
-			if err != nil {
-				panic(fmt.Sprintf("error: %s", err))
-			}
-			return x
-		}
-	}
-	return lookupByHash(dq, dr, ext.size, filterFn, storageFn)
 }
 
 // LookupString is like Lookup, but for strings
@@ -0,0 +1,164 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// A quotient filter with Config.FingerprintBits stores a fingerprint of
+// each hash in place of its remainder, and expands like InfiniFilter.
+// Each doubling moves the top bit of every fingerprint into the
+// quotient, so the fingerprints of older entries shrink by a bit a
+// generation while those of new entries are a bit longer each
+// generation.  A slot of fingerprint width w holds a fingerprint fp of
+// length l <= w as
+//
+//	(fp<<1 | 1) << (w-l)
+//
+// so that the lowest set bit marks the end of the fingerprint, and the
+// trailing zeros count the generations the entry has aged.  An entry
+// which has lost its whole fingerprint matches every key of its
+// quotient, and is copied to both halves of its quotient as the filter
+// doubles.  The false positive rate of the entries of each generation
+// falls geometrically with their age, so the rate of the whole filter
+// stays close to 2^-FingerprintBits however much it grows, until the
+// 64 bit hash can't supply the quotient and a longer fingerprint
+
+// maxFingerprintBits is the widest fingerprint which may be configured,
+// which leaves the quotient bits of the smallest table
+const maxFingerprintBits = bitsPerWord - minQBits
+
+// fingerprintWidth returns the width of the fingerprints of new
+// entries of a quotient filter of qBits quotient bits, one bit longer
+// than those of the generation before (which was prev bits wide),
+// limited by the bits of the hash which remain after the quotient
+func fingerprintWidth(prev, qBits uint) uint {
+	w := prev + 1
+	if w > bitsPerWord-qBits {
+		w = bitsPerWord - qBits
+	}
+	return w
+}
+
+// encodeFingerprint returns the slot holding fingerprint fp of length l
+// in a slot of fingerprint width w
+func encodeFingerprint(fp uint64, l, w uint) uint64 {
+	return (fp<<1 | 1) << (w - l)
+}
+
+// decodeFingerprint returns the fingerprint held by slot r of
+// fingerprint width w, and its length
+func decodeFingerprint(r uint64, w uint) (fp uint64, l uint) {
+	tz := uint(bits.TrailingZeros64(r))
+	return r >> (tz + 1), w - tz
+}
+
+// splitFingerprint returns the quotient of hv, and the slot holding its
+// fingerprint in full
+func (qf *Filter) splitFingerprint(hv uint64) (dq, dr uint64) {
+	dq = hv >> (bitsPerWord - qf.qBits)
+	fp := hv << qf.qBits >> (bitsPerWord - qf.fpBits)
+	return dq, encodeFingerprint(fp, qf.fpBits, qf.fpBits)
+}
+
+// split returns the quotient of hv, and the remainder (or fingerprint)
+// stored for it
+func (qf *Filter) split(hv uint64) (dq, dr uint64) {
+	if qf.fpBits != 0 {
+		return qf.splitFingerprint(hv)
+	}
+	return hv >> qf.rBits, hv & qf.rMask
+}
+
+// lookupFingerprint searches the run of quotient dq for fingerprints
+// matching the full fingerprint dr, of width w, and returns the value
+// of the longest match
+func (v *slotView) lookupFingerprint(dq, dr uint64, w uint) (found bool, value uint64) {
+	fp, _ := decodeFingerprint(dr, w)
+	best := uint(0)
+	v.eachInRun(dq, func(slot uint64, r uint64) {
+		efp, l := decodeFingerprint(r, w)
+		if efp != fp>>(w-l) || (found && l <= best) {
+			return
+		}
+		found, best = true, l
+		if v.storage != nil {
+			value = v.storage(slot)
+		}
+	})
+	return
+}
+
+// eachInRun calls cb with each slot in the run of quotient dq, and the
+// remainder stored there
+func (v *slotView) eachInRun(dq uint64, cb func(slot, r uint64)) {
+	if !v.occupied(dq) {
+		return
+	}
+	if v.rs != nil {
+		r := v.rs
+		for slot := r.runsEnd(dq) - 1; ; slot-- {
+			cb(slot, r.remainders(slot))
+			if slot == dq || r.isRunEnd(slot-1) {
+				return
+			}
+		}
+	}
+	slot := dq
+	if slotData(v.filter(dq)).shifted() {
+		slot = findStart(dq, v.size, v.filter)
+	}
+	for {
+		cb(slot, slotData(v.filter(slot)).r())
+		right(&slot, v.size)
+		if !slotData(v.filter(slot)).continuation() {
+			return
+		}
+	}
+}
+
+// expand doubles a quotient filter with fingerprints.  The top bit of
+// each fingerprint moves into the quotient, and entries whose
+// fingerprint is exhausted are copied to both new quotients
+func (qf *Filter) expand() {
+	cpy := *qf
+	cpy.entries = 0
+	cpy.fpBits = fingerprintWidth(qf.fpBits, qf.qBits+1)
+	cpy.initForQuotientBits(qf.qBits + 1)
+	cpy.allocStorage(cpy.tableAlloc())
+	insert := func(dq, dr, value uint64) {
+		if cpy.rs == nil {
+			cpy.insertByHash(dq, dr, value)
+		} else if _, _, err := cpy.rsInsert(dq, dr, value); err != nil {
+			panic(fmt.Sprintf("internal inconsistency: %s while expanding", err))
+		}
+	}
+	release := segmentReleaser(qf.filter, qf.storage)
+	v := qf.view()
+	v.eachRun(func(q, start, end uint64) {
+		for slot := start; ; slot++ {
+			if v.rs == nil {
+				slot %= qf.size
+			}
+			var value uint64
+			if qf.storage != nil {
+				value = qf.storage.Get(slot)
+			}
+			fp, l := decodeFingerprint(v.remainder(slot), qf.fpBits)
+			if l == 0 {
+				insert(q<<1, encodeFingerprint(0, 0, cpy.fpBits), value)
+				insert(q<<1|1, encodeFingerprint(0, 0, cpy.fpBits), value)
+			} else {
+				l--
+				insert(q<<1|fp>>l, encodeFingerprint(fp&lowMask(uint64(l)), l, cpy.fpBits), value)
+			}
+			release(slot)
+			if slot == end {
+				break
+			}
+		}
+	})
+	*qf = cpy
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprintEncoding(t *testing.T) {
+	for w := uint(1); w <= 60; w++ {
+		for l := uint(0); l <= w; l++ {
+			fp := uint64(0x5a5a5a5a5a5a5a5a) & lowMask(uint64(l))
+			r := encodeFingerprint(fp, l, w)
+			assert.Less(t, r, uint64(1)<<(w+1))
+			gfp, gl := decodeFingerprint(r, w)
+			assert.Equal(t, fp, gfp)
+			assert.Equal(t, l, gl)
+		}
+	}
+}
+
+// falsePositiveRate probes qf with n keys which were never inserted
+func falsePositiveRate(qf *Filter, n int) float64 {
+	fp := 0
+	for i := 0; i < n; i++ {
+		if qf.ContainsString(fmt.Sprintf("absent %d", i)) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(n)
+}
+
+func TestFingerprintExpansion(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		t.Run(layout.String(), func(t *testing.T) {
+			qf := NewWithConfig(Config{FingerprintBits: 10, BitsOfStoragePerEntry: 20, Layout: layout})
+			assert.Equal(t, uint(10), qf.fpBits)
+			const n = 1 << 17
+			var rates []float64
+			wrong := 0
+			for i := 0; i < n; i++ {
+				qf.InsertStringWithValue(fmt.Sprintf("key %d", i), uint64(i))
+				if i+1 >= 1<<12 && (i+1)&i == 0 {
+					rates = append(rates, falsePositiveRate(qf, 50000))
+				}
+			}
+			assert.NoError(t, qf.Validate())
+			// new entries have wider fingerprints
+			assert.Equal(t, uint(10+qf.qBits-minQBits), qf.fpBits)
+			for i := 0; i < n; i++ {
+				found, v := qf.LookupString(fmt.Sprintf("key %d", i))
+				assert.True(t, found)
+				if v != uint64(i) {
+					wrong++
+				}
+			}
+			// a key sharing the longer fingerprint of another is rare
+			assert.Less(t, wrong, n/1000)
+			// the false positive rate stays close to 2^-10 as the
+			// quotient filter grows 32 fold
+			for _, rate := range rates {
+				assert.Less(t, rate, 3./1024)
+			}
+			assert.Less(t, rates[len(rates)-1], 2*rates[0]+1./1024)
+		})
+	}
+}
+
+func TestFingerprintExhausted(t *testing.T) {
+	// two bit fingerprints are gone within a couple of doublings, yet
+	// every key is still found
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		qf := NewWithConfig(Config{FingerprintBits: 2, Layout: layout})
+		for i := 0; i < 5000; i++ {
+			qf.InsertString(fmt.Sprintf("key %d", i))
+		}
+		assert.NoError(t, qf.Validate())
+		for i := 0; i < 5000; i++ {
+			assert.True(t, qf.ContainsString(fmt.Sprintf("key %d", i)))
+		}
+	}
+}
+
+func TestFingerprintSerialization(t *testing.T) {
+	for _, c := range []Config{
+		{FingerprintBits: 12, BitsOfStoragePerEntry: 8},
+		{FingerprintBits: 12, BitPacked: true},
+		{FingerprintBits: 12, Layout: LayoutRankSelect},
+	} {
+		qf := NewWithConfig(c)
+		for i := 0; i < 3000; i++ {
+			qf.InsertStringWithValue(fmt.Sprintf("key %d", i), uint64(i)&0xff)
+		}
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		path, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		defer os.Remove(path)
+
+		var cpy Filter
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, qf.fpBits, cpy.fpBits)
+		assert.Equal(t, c.FingerprintBits, cpy.config.FingerprintBits)
+		assert.Equal(t, qf.Len(), cpy.Len())
+		// the copy keeps expanding
+		for i := 3000; i < 10000; i++ {
+			cpy.InsertStringWithValue(fmt.Sprintf("key %d", i), uint64(i)&0xff)
+		}
+		assert.NoError(t, cpy.Validate())
+		assert.Greater(t, cpy.fpBits, qf.fpBits)
+		for i := 0; i < 10000; i++ {
+			found, _ := cpy.LookupString(fmt.Sprintf("key %d", i))
+			assert.True(t, found)
+		}
+		assert.Less(t, falsePositiveRate(&cpy, 20000), 3./4096)
+
+		h, err := ReadHeaderFromPath(path)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(12), h.FingerprintBits)
+		assert.Equal(t, uint64(qf.fpBits), h.FingerprintWidth)
+		_, err = OpenReadOnlyFromPath(path)
+		assert.Error(t, err)
+	}
+}
+
+func TestFingerprintConfig(t *testing.T) {
+	assert.Panics(t, func() { NewWithConfig(Config{FingerprintBits: 61}) })
+	assert.Panics(t, func() { NewWithConfig(Config{FingerprintBits: 8, TTL: time.Minute}) })
+	assert.Panics(t, func() { NewWithConfig(Config{FingerprintBits: 8, Multiset: true, BitsOfStoragePerEntry: 4}) })
+	assert.Panics(t, func() { NewWithConfig(Config{FingerprintBits: 8, ValueArena: true}) })
+	assert.Panics(t, func() { NewWithConfig(Config{FingerprintBits: 8, Columns: []Column{{Name: "c", Bits: 1}}}) })
+	assert.Panics(t, func() { BuildParallel(Config{FingerprintBits: 8}, nil, nil, 1) })
+	assert.Panics(t, func() { NewRangeFilter(RangeConfig{Config: Config{FingerprintBits: 8}}) })
+	_, err := OpenCascade(t.TempDir(), CascadeConfig{Config: Config{FingerprintBits: 8}})
+	assert.Error(t, err)
+	assert.Panics(t, func() { NewWithConfig(Config{FingerprintBits: 8}).EachUint64(func(_, _ uint64) {}) })
+
+	// a fingerprint wider than the hash leaves after the quotient is
+	// narrowed
+	qf := NewWithConfig(Config{FingerprintBits: 60, ExpectedEntries: 1 << 10})
+	assert.Equal(t, bitsPerWord-qf.qBits, qf.fpBits)
+	qf.InsertString("x")
+	qf.double()
+	assert.Equal(t, bitsPerWord-qf.qBits, qf.fpBits)
+	assert.True(t, qf.ContainsString("x"))
+	assert.False(t, qf.ContainsString("y"))
+}
@@ -2,6 +2,13 @@
 
 package qf
 
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
 // HashFn is the signature for hash functions used
 type HashFn func([]byte) uint64
 
@@ -11,7 +18,8 @@ const (
 	prime64  = uint64(1099511628211)
 )
 
-func fnvhash(v []byte) uint64 {
+// FNV64 returns the 64 bit FNV-1 hash of v
+func FNV64(v []byte) uint64 {
 	// inline fnv 64
 	hv := offset64
 	for _, c := range v {
@@ -27,15 +35,16 @@ const (
 	bigR = 47
 )
 
-func murmurhash64(v []byte) uint64 {
+// Murmur64 returns the 64 bit MurmurHash2 (MurmurHash64A) of v with a
+// seed of zero, the default hash of a quotient filter
+func Murmur64(v []byte) uint64 {
 	var off int
 	var h, k uint64
 
 	h = uint64(len(v)) * bigM
 
 	for l := (len(v) - off); l >= 8; l -= 8 {
-		k = uint64(v[off+0]) | uint64(v[off+1])<<8 | uint64(v[off+2])<<16 | uint64(v[off+3])<<24 |
-			uint64(v[off+4])<<32 | uint64(v[off+5])<<40 | uint64(v[off+6])<<48 | uint64(v[off+7])<<56
+		k = binary.LittleEndian.Uint64(v[off:])
 
 		k *= bigM
 		k ^= k >> bigR
@@ -77,3 +86,219 @@ func murmurhash64(v []byte) uint64 {
 
 	return uint64(h)
 }
+
+// HashAlgorithm selects the hash function of a quotient filter, which
+// is recorded in the serialized quotient filter
+type HashAlgorithm uint64
+
+const (
+	// HashMurmur is Murmur64, the default
+	HashMurmur HashAlgorithm = iota
+	// HashFNV is FNV64
+	HashFNV
+	// HashXXH64 is XXH64
+	HashXXH64
+	// HashXXH3 is XXH3
+	HashXXH3
+	// HashSipHash is SipHash-2-4 with a secret key, see
+	// Config.KeyedHash
+	HashSipHash
+	// HashCustom is recorded for quotient filters built with
+	// Config.HashFn, which must be set again to read them
+	HashCustom
+)
+
+var hashAlgorithmNames = []string{"murmur", "fnv", "xxh64", "xxh3", "siphash", "custom"}
+
+func (a HashAlgorithm) String() string {
+	if a <= HashCustom {
+		return hashAlgorithmNames[a]
+	}
+	return fmt.Sprintf("unknown hash algorithm %d", uint64(a))
+}
+
+// ParseHashAlgorithm returns the hash algorithm with the specified
+// name, as returned by HashAlgorithm.String
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	for a, n := range hashAlgorithmNames {
+		if n == name {
+			return HashAlgorithm(a), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %q, expected one of %s",
+		name, strings.Join(hashAlgorithmNames, ", "))
+}
+
+// keyHash hashes byte keys with a HashAlgorithm
+type keyHash struct {
+	alg HashAlgorithm
+	// the key of HashSipHash
+	k0, k1 uint64
+	// the function of HashCustom
+	custom HashFn
+}
+
+func newKeyHash(alg HashAlgorithm, key [16]byte, custom HashFn) keyHash {
+	return keyHash{alg, binary.LittleEndian.Uint64(key[:8]), binary.LittleEndian.Uint64(key[8:]), custom}
+}
+
+func (h *keyHash) sum(v []byte) uint64 {
+	if h.alg == HashCustom {
+		return h.custom(v)
+	}
+	return h.builtin(v)
+}
+
+// builtin hashes v with one of the hash functions of this package.
+// Unlike a HashFn these are known not to retain v, so keys assembled
+// on the stack needn't escape to the heap
+func (h *keyHash) builtin(v []byte) uint64 {
+	switch h.alg {
+	case HashMurmur:
+		return Murmur64(v)
+	case HashFNV:
+		return FNV64(v)
+	case HashXXH64:
+		return XXH64(v)
+	case HashXXH3:
+		return XXH3(v)
+	case HashSipHash:
+		return sipHash24(h.k0, h.k1, v)
+	}
+	panic(h.alg.String())
+}
+
+// IntegerMixer selects the hash used for integer keys, see
+// Filter.InsertUint64.  Every mixer is a bijection on 64 bit integers,
+// so the keys of a quotient filter holding only integer keys can be
+// recovered from their hashes
+type IntegerMixer uint64
+
+const (
+	// MixMurmur3 is the 64 bit finalizer of murmur3 (fmix64)
+	MixMurmur3 IntegerMixer = iota
+	// MixSplitMix64 is the output function of the splitmix64 generator
+	MixSplitMix64
+)
+
+func (m IntegerMixer) String() string {
+	switch m {
+	case MixMurmur3:
+		return "murmur3"
+	case MixSplitMix64:
+		return "splitmix64"
+	}
+	return fmt.Sprintf("unknown integer mixer %d", uint64(m))
+}
+
+func (m IntegerMixer) valid() bool {
+	return m == MixMurmur3 || m == MixSplitMix64
+}
+
+// mix hashes the integer key x
+func (m IntegerMixer) mix(x uint64) uint64 {
+	switch m {
+	case MixMurmur3:
+		x ^= x >> 33
+		x *= 0xff51afd7ed558ccd
+		x ^= x >> 33
+		x *= 0xc4ceb9fe1a85ec53
+		x ^= x >> 33
+	case MixSplitMix64:
+		x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+		x = (x ^ x>>27) * 0x94d049bb133111eb
+		x ^= x >> 31
+	default:
+		panic(m.String())
+	}
+	return x
+}
+
+// unmix inverts mix, the constants are the multiplicative inverses
+// (mod 2^64) of those used by mix
+func (m IntegerMixer) unmix(x uint64) uint64 {
+	switch m {
+	case MixMurmur3:
+		x ^= x >> 33
+		x *= 0x9cb4b2f8129337db
+		x ^= x >> 33
+		x *= 0x4f74430c22a54005
+		x ^= x >> 33
+	case MixSplitMix64:
+		x = unxorshift(x, 31) * 0x319642b2d24d8ec3
+		x = unxorshift(x, 27) * 0x96de1b173f119089
+		x = unxorshift(x, 30)
+	default:
+		panic(m.String())
+	}
+	return x
+}
+
+// unxorshift inverts x ^= x >> s
+func unxorshift(x uint64, s uint) uint64 {
+	for ; s < bitsPerWord; s *= 2 {
+		x ^= x >> s
+	}
+	return x
+}
+
+// integerKey returns the word which integer keys are combined with
+// before mixing, which is zero unless hashing is keyed.  Mixing remains
+// invertible but, unlike SipHash, the mixers are not pseudorandom
+// functions so this protects integer keys less well than byte keys
+func integerKey(keyed bool, key [16]byte) uint64 {
+	if !keyed {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(key[:8]) ^ binary.LittleEndian.Uint64(key[8:])
+}
+
+// sipHash24 is SipHash-2-4 keyed by k0 and k1, a pseudorandom function
+// whose collisions can't be predicted without the key, see https://www.aumasson.jp/siphash/siphash.pdf
+func sipHash24(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	b := uint64(len(p)) << 56
+	for ; len(p) >= 8; p = p[8:] {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+	for i, c := range p {
+		b |= uint64(c) << (8 * i)
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= b
+
+	v2 ^= 0xff
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
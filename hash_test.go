@@ -0,0 +1,217 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	murmur "github.com/aviddiviner/go-murmur"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSipHash(t *testing.T) {
+	// test vectors from the reference implementation, the key is
+	// 00 01 .. 0f and the message of length n is 00 01 .. n-1
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	msg := make([]byte, 64)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	h := newKeyHash(HashSipHash, key, nil)
+	assert.Equal(t, uint64(0x726fdb47dd0e0e31), h.sum(msg[:0]))
+	assert.Equal(t, uint64(0x74f839c593dc67fd), h.sum(msg[:1]))
+	assert.Equal(t, uint64(0x93f5f5799a932462), h.sum(msg[:8]))
+	assert.Equal(t, uint64(0xa129ca6149be45e5), h.sum(msg[:15]))
+}
+
+// craftCollisions finds n keys whose hashes share the quotient 0 of a
+// quotient filter with qBits quotient bits
+func craftCollisions(hash HashFn, qBits uint, n int) [][]byte {
+	var keys [][]byte
+	for i := 0; len(keys) < n; i++ {
+		k := []byte(fmt.Sprintf("evil%d", i))
+		if hash(k)>>(bitsPerWord-qBits) == 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func TestKeyedHashResistsCraftedKeys(t *testing.T) {
+	c := Config{ExpectedEntries: 1000}
+	keys := craftCollisions(Murmur64, c.QBits(), 300)
+
+	// the crafted keys form a single cluster with the default hash
+	qf := NewWithConfig(c)
+	for _, k := range keys {
+		qf.Insert(k)
+	}
+	assert.GreaterOrEqual(t, qf.Stats().MaxClusterLength, uint64(len(keys)))
+
+	// but not with a keyed hash
+	keyed := Config{ExpectedEntries: 1000, KeyedHash: true}
+	qf = NewWithConfig(keyed)
+	for _, k := range keys {
+		qf.Insert(k)
+	}
+	assert.Less(t, qf.Stats().MaxClusterLength, uint64(20))
+
+	// nor can keys crafted against one keyed filter attack another
+	other := NewWithConfig(keyed)
+	assert.NotEqual(t, qf.config.HashKey, other.config.HashKey)
+	for _, k := range craftCollisions(qf.hasher.sum, c.QBits(), 300) {
+		other.Insert(k)
+	}
+	assert.Less(t, other.Stats().MaxClusterLength, uint64(20))
+}
+
+func TestKeyedHashPersists(t *testing.T) {
+	c := Config{KeyedHash: true, BitsOfStoragePerEntry: 16}
+	qf := NewWithConfig(c)
+	for i := 0; i < 1000; i++ {
+		// doubles a few times, which must keep the key
+		qf.InsertStringWithValue(fmt.Sprintf("k%d", i), uint64(i))
+		qf.InsertUint64WithValue(uint64(i)<<32, uint64(i))
+	}
+	key := qf.config.HashKey
+	assert.NotEqual(t, [16]byte{}, key)
+	assert.NotEqual(t, Murmur64([]byte("k1")), qf.hasher.sum([]byte("k1")))
+
+	check := func(r Reader) {
+		for i := 0; i < 1000; i++ {
+			found, v := r.LookupString(fmt.Sprintf("k%d", i))
+			assert.True(t, found)
+			assert.Equal(t, uint64(i), v)
+			found, v = r.LookupUint64(uint64(i) << 32)
+			assert.True(t, found)
+			assert.Equal(t, uint64(i), v)
+		}
+	}
+	check(qf)
+	ids := 0
+	qf.EachUint64(func(id, value uint64) {
+		if id == value<<32 {
+			ids++
+		}
+	})
+	assert.Equal(t, 1000, ids)
+
+	var buf bytes.Buffer
+	_, err := qf.WriteTo(&buf)
+	assert.NoError(t, err)
+	var cpy Filter
+	_, err = cpy.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, key, cpy.config.HashKey)
+	check(&cpy)
+
+	name, err := writeQFToTempFile(qf)
+	assert.NoError(t, err)
+	defer os.Remove(name)
+	ext, err := OpenReadOnlyFromPath(name)
+	assert.NoError(t, err)
+	defer ext.Close()
+	check(ext)
+	h, err := ReadHeaderFromPath(name)
+	assert.NoError(t, err)
+	assert.True(t, h.KeyedHash)
+	assert.Equal(t, key, h.HashKey)
+
+	// an explicit key is used as is
+	c.HashKey = key
+	assert.Equal(t, key, NewWithConfig(c).config.HashKey)
+	assert.Panics(t, func() { NewWithConfig(Config{KeyedHash: true, HashFn: FNV64}) })
+}
+
+func TestHashFunctions(t *testing.T) {
+	assert.Equal(t, uint64(0xef46db3751d8e999), XXH64(nil))
+	assert.Equal(t, uint64(0xd24ec4f1a98c6e5b), XXH64([]byte("a")))
+	assert.Equal(t, uint64(0x44bc2cf5ad770999), XXH64([]byte("abc")))
+	assert.Equal(t, uint64(0x2d06800538d394c2), XXH3(nil))
+	r := rand.New(rand.NewSource(39)) //intentionally fixed seed
+	for n := 0; n < 300; n++ {
+		b := make([]byte, n)
+		r.Read(b)
+		assert.Equal(t, murmur.MurmurHash64A(b, 0), Murmur64(b), "%d bytes", n)
+		// every length class of XXH3 (up to 16, 128 and 240 bytes, and
+		// beyond) hashes every byte
+		if n > 0 {
+			h := XXH3(b)
+			b[r.Intn(n)]++
+			assert.NotEqual(t, h, XXH3(b), "%d bytes", n)
+		}
+	}
+	long := make([]byte, 3000)
+	h := XXH3(long)
+	long[1500] = 1
+	assert.NotEqual(t, h, XXH3(long))
+
+	for a := HashMurmur; a <= HashCustom; a++ {
+		parsed, err := ParseHashAlgorithm(a.String())
+		assert.NoError(t, err)
+		assert.Equal(t, a, parsed)
+	}
+	_, err := ParseHashAlgorithm("md5")
+	assert.Error(t, err)
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	for _, a := range []HashAlgorithm{HashMurmur, HashFNV, HashXXH64, HashXXH3, HashSipHash} {
+		qf := NewWithConfig(Config{HashAlgorithm: a})
+		assert.Equal(t, a == HashSipHash, qf.config.KeyedHash)
+		for i := 0; i < 500; i++ {
+			qf.InsertString(fmt.Sprintf("k%d", i))
+		}
+		if a != HashSipHash {
+			h := newKeyHash(a, [16]byte{}, nil)
+			assert.Equal(t, h.sum([]byte("k1")), qf.hasher.sum([]byte("k1")))
+		}
+
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		var cpy Filter
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, a, cpy.config.HashAlgorithm)
+
+		name, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		for i := 0; i < 500; i++ {
+			assert.True(t, cpy.ContainsString(fmt.Sprintf("k%d", i)), "%s", a)
+			assert.True(t, ext.ContainsString(fmt.Sprintf("k%d", i)), "%s", a)
+		}
+		ext.Close()
+		os.Remove(name)
+	}
+	assert.Panics(t, func() { NewWithConfig(Config{HashAlgorithm: HashCustom}) })
+	assert.Panics(t, func() { NewWithConfig(Config{HashAlgorithm: HashXXH3, KeyedHash: true}) })
+
+	// a custom hash function must be supplied again
+	qf := NewWithConfig(Config{HashFn: FNV64})
+	qf.InsertString("hi")
+	name, err := writeQFToTempFile(qf)
+	assert.NoError(t, err)
+	defer os.Remove(name)
+	_, err = OpenReadOnlyFromPath(name)
+	assert.Error(t, err)
+	var buf bytes.Buffer
+	_, err = qf.WriteTo(&buf)
+	assert.NoError(t, err)
+	var cpy Filter
+	_, err = cpy.ReadFrom(bytes.NewReader(buf.Bytes()))
+	assert.Error(t, err)
+	cpy = *NewWithConfig(Config{HashFn: FNV64})
+	_, err = cpy.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.True(t, cpy.ContainsString("hi"))
+}
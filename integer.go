@@ -0,0 +1,108 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+// InsertUint64WithValue stores the integer key id and an integer value
+// in the quotient filter, returning whether the key already existed.
+// Integer keys are hashed by the configured IntegerMixer rather than
+// the HashFn used for byte keys, so an integer key and its encoding as
+// bytes are distinct keys.  Like InsertWithValue, it panics with
+// ErrFilterFull if a quotient filter of fixed capacity is full
+func (qf *Filter) InsertUint64WithValue(id uint64, value uint64) (update bool) {
+	update, err := qf.TryInsertUint64WithValue(id, value)
+	if err != nil {
+		panic(err)
+	}
+	return update
+}
+
+// TryInsertUint64WithValue is like InsertUint64WithValue, see
+// TryInsertWithValue
+func (qf *Filter) TryInsertUint64WithValue(id uint64, value uint64) (update bool, err error) {
+	return qf.TryInsertRawHash(qf.config.IntegerMixer.mix(id^qf.config.integerKey()), value)
+}
+
+// InsertUint64 stores the integer key id in the quotient filter, it
+// returns whether it already existed
+func (qf *Filter) InsertUint64(id uint64) (update bool) {
+	return qf.InsertUint64WithValue(id, 0)
+}
+
+// ContainsUint64 returns whether the integer key id is contained within
+// the quotient filter
+func (qf *Filter) ContainsUint64(id uint64) bool {
+	found, _ := qf.LookupUint64(id)
+	return found
+}
+
+// LookupUint64 searches for the integer key id and returns whether it
+// exists, and the value stored with it (if any)
+func (qf *Filter) LookupUint64(id uint64) (bool, uint64) {
+	return qf.LookupRawHash(qf.config.IntegerMixer.mix(id ^ qf.config.integerKey()))
+}
+
+// EachUint64 calls cb with every key in the quotient filter, and its
+// value (if any), in hash order, skipping any which have expired.  Every key must have been inserted as
+// an integer key: as the full hash of each key is stored the mixer is
+// inverted to recover the key, keys inserted as bytes are returned as
+// meaningless integers.  It panics if the quotient filter stores
+// fingerprints, see Config.FingerprintBits
+func (qf *Filter) EachUint64(cb func(id, value uint64)) {
+	if qf.fpBits != 0 {
+		panic("keys can't be recovered from fingerprints")
+	}
+	mixer, key := qf.config.IntegerMixer, qf.config.integerKey()
+	e, now := qf.expiry, uint64(0)
+	if e != nil {
+		now = e.now()
+	}
+	qf.eachHashValue(func(hv, slot uint64) {
+		value := uint64(0)
+		if qf.storage != nil {
+			value = qf.storage.Get(slot)
+		}
+		if e != nil {
+			if !e.live(value, now) {
+				return
+			}
+			value = e.value(value)
+		}
+		cb(mixer.unmix(hv)^key, value)
+	})
+}
+
+// ContainsUint64 returns whether the integer key id is contained within
+// the quotient filter
+func (ext *Disk) ContainsUint64(id uint64) bool {
+	found, _ := ext.LookupUint64(id)
+	return found
+}
+
+// LookupUint64 searches for the integer key id and returns whether it
+// exists, and the value stored with it (if any)
+func (ext *Disk) LookupUint64(id uint64) (bool, uint64) {
+	return ext.LookupRawHash(ext.mixer.mix(id ^ ext.mixKey))
+}
+
+// EachUint64 is like Filter.EachUint64, it reads the quotient filter
+// from disk sequentially
+func (ext *Disk) EachUint64(cb func(id, value uint64)) {
+	v := ext.view(true)
+	e, now := ext.expiry, uint64(0)
+	if e != nil {
+		now = e.now()
+	}
+	v.eachHashValue(ext.rBits, func(hv, slot uint64) {
+		value := uint64(0)
+		if v.storage != nil {
+			value = v.storage(slot)
+		}
+		if e != nil {
+			if !e.live(value, now) {
+				return
+			}
+			value = e.value(value)
+		}
+		cb(ext.mixer.unmix(hv)^ext.mixKey, value)
+	})
+}
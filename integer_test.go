@@ -0,0 +1,116 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntegerMixers(t *testing.T) {
+	r := rand.New(rand.NewSource(36)) //intentionally fixed seed
+	for _, m := range []IntegerMixer{MixMurmur3, MixSplitMix64} {
+		for _, x := range []uint64{0, 1, 2, 1 << 63, ^uint64(0)} {
+			assert.Equal(t, x, m.unmix(m.mix(x)), "%s %x", m, x)
+		}
+		for i := 0; i < 10000; i++ {
+			x := r.Uint64()
+			assert.Equal(t, x, m.unmix(m.mix(x)), "%s %x", m, x)
+		}
+		// sequential ids must spread over the quotients
+		assert.NotEqual(t, m.mix(1)>>60, m.mix(2)>>60, "%s", m)
+	}
+	// the first output of splitmix64 seeded with zero
+	assert.Equal(t, uint64(0xe220a8397b1dcdaf), MixSplitMix64.mix(0x9e3779b97f4a7c15))
+}
+
+func TestUint64Keys(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		for _, m := range []IntegerMixer{MixMurmur3, MixSplitMix64} {
+			qf := NewWithConfig(Config{BitsOfStoragePerEntry: 32, Layout: layout, IntegerMixer: m})
+			ids := map[uint64]uint64{}
+			for id := uint64(0); id < 5000; id++ {
+				if id%3 == 0 {
+					continue
+				}
+				assert.False(t, qf.InsertUint64WithValue(id*7919, id))
+				ids[id*7919] = id
+			}
+			assert.True(t, qf.InsertUint64WithValue(7919, 42))
+			ids[7919] = 42
+			assert.Equal(t, uint64(len(ids)), qf.Len())
+			for id := uint64(0); id < 5000; id++ {
+				found, v := qf.LookupUint64(id * 7919)
+				assert.Equal(t, id%3 != 0, found, "%s %s %d", layout, m, id)
+				assert.Equal(t, ids[id*7919], v)
+			}
+			// integer keys and their encoding as bytes are distinct
+			assert.False(t, qf.ContainsString("\x01"))
+			assert.NoError(t, qf.Validate())
+
+			each := func(r interface{ EachUint64(func(id, value uint64)) }) map[uint64]uint64 {
+				got := map[uint64]uint64{}
+				r.EachUint64(func(id, value uint64) {
+					_, dup := got[id]
+					assert.False(t, dup, "%d enumerated twice", id)
+					got[id] = value
+				})
+				return got
+			}
+			assert.Equal(t, ids, each(qf))
+
+			// the mixer survives serialization
+			var buf bytes.Buffer
+			_, err := qf.WriteTo(&buf)
+			assert.NoError(t, err)
+			var cpy Filter
+			_, err = cpy.ReadFrom(&buf)
+			assert.NoError(t, err)
+			assert.True(t, cpy.ContainsUint64(2*7919))
+			assert.Equal(t, ids, each(&cpy))
+
+			name, err := writeQFToTempFile(qf)
+			assert.NoError(t, err)
+			ext, err := OpenReadOnlyFromPath(name)
+			assert.NoError(t, err)
+			for id := uint64(0); id < 5000; id += 7 {
+				found, v := ext.LookupUint64(id * 7919)
+				assert.Equal(t, id%3 != 0, found)
+				assert.Equal(t, ids[id*7919], v)
+			}
+			assert.False(t, ext.ContainsUint64(3*7919))
+			assert.Equal(t, ids, each(ext))
+			ext.Close()
+			os.Remove(name)
+		}
+	}
+}
+
+func TestUint64KeysWrap(t *testing.T) {
+	// find ids whose runs wrap around the end of a small table
+	qf := NewWithConfig(Config{ExpectedEntries: 8, FixedCapacity: true, LoadFactor: 0.9})
+	ids := map[uint64]uint64{}
+	for id := uint64(0); uint64(len(ids)) < qf.Capacity(); id++ {
+		if MixMurmur3.mix(id)>>qf.rBits == qf.size-1 {
+			qf.InsertUint64(id)
+			ids[id] = 0
+		}
+	}
+	got := map[uint64]uint64{}
+	qf.EachUint64(func(id, value uint64) { got[id] = value })
+	assert.Equal(t, ids, got)
+
+	name, err := writeQFToTempFile(qf)
+	assert.NoError(t, err)
+	defer os.Remove(name)
+	ext, err := OpenReadOnlyFromPath(name)
+	assert.NoError(t, err)
+	defer ext.Close()
+	got = map[uint64]uint64{}
+	ext.EachUint64(func(id, value uint64) { got[id] = value })
+	assert.Equal(t, ids, got)
+}
@@ -0,0 +1,310 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"io"
+	"unsafe"
+)
+
+// mergeSource opens a cursor over the hash values of one input of a
+// merge.  A merge streamed to disk reads its inputs once for each
+// vector it writes, rather than holding the merged level in memory
+type mergeSource func() *hashCursor
+
+// mergeCursor walks the union of the hash values of a newer and an
+// older input in hash order.  Where both hold a hash value the value
+// of the newer wins
+type mergeCursor struct {
+	newer, older *hashCursor
+	x, y         uint64
+	okx, oky     bool
+}
+
+// newMergeCursor opens a cursor over newer and older, which may be nil
+func newMergeCursor(newer, older mergeSource) *mergeCursor {
+	m := &mergeCursor{newer: newer()}
+	m.x, m.okx = m.newer.nextHash()
+	if older != nil {
+		m.older = older()
+		m.y, m.oky = m.older.nextHash()
+	}
+	return m
+}
+
+// next returns the next hash value and its value, or false once all
+// are read
+func (m *mergeCursor) next() (hv, value uint64, ok bool) {
+	switch {
+	case m.okx && (!m.oky || m.x <= m.y):
+		hv, value = m.x, m.newer.value()
+		if m.oky && m.x == m.y {
+			m.y, m.oky = m.older.nextHash()
+		}
+		m.x, m.okx = m.newer.nextHash()
+	case m.oky:
+		hv, value = m.y, m.older.value()
+		m.y, m.oky = m.older.nextHash()
+	default:
+		return 0, 0, false
+	}
+	return hv, value, true
+}
+
+// levelStream writes a merge in the format of Filter.WriteTo without
+// building it in memory.  Hash values arrive in order, so the slot of
+// each follows from the slot of the one before, and every vector is
+// written front to back in a pass over the inputs
+type levelStream struct {
+	// qf describes the merged filter, its vectors are not allocated
+	qf           *Filter
+	newer, older mergeSource
+	// the slots at the start of a classic table which hold runs
+	// wrapping around its end
+	wrap uint64
+}
+
+// newLevelStream prepares to write the merge of newer and older (which
+// may be nil) as a filter of configuration c, which must be sized for
+// every hash value of both.  It returns false if the vectors of c can't
+// be streamed
+func newLevelStream(c Config, newer, older mergeSource) (*levelStream, bool, error) {
+	qBits := c.QBits()
+	c.ExpectedEntries = 0
+	qf := NewWithConfig(c)
+	if segmentsOf(qf.allocfn, bitsPerWord, 0) == nil {
+		return nil, false, nil
+	}
+	qf.initForQuotientBits(qBits)
+	ls := &levelStream{qf: qf, newer: newer, older: older}
+
+	// count the entries, and find where the runs end
+	var n uint64
+	end := ls.place(0, func(uint64, uint64, uint64, bool) { n++ })
+	qf.entries = n
+	if ls.rankSelect() {
+		if end > rsBlocks(qf.size)*slotsPerBlock {
+			return nil, true, errOverflow
+		}
+		return ls, true, nil
+	}
+	// runs wrapping around the end of a classic table push along those
+	// at its start, so iterate until the wrap around is stable
+	for end > qf.size && end-qf.size != ls.wrap {
+		ls.wrap = end - qf.size
+		end = ls.place(ls.wrap, func(uint64, uint64, uint64, bool) {})
+	}
+	return ls, true, nil
+}
+
+func (ls *levelStream) rankSelect() bool {
+	return ls.qf.config.Layout == LayoutRankSelect
+}
+
+// place calls cb with the slot of each hash value in hash order, whether
+// it starts a run, and its value, when runs start no earlier than start.
+// Slots of the classic layout are not wrapped, so may exceed the size of
+// the table.  It returns the slot after the last hash value placed
+func (ls *levelStream) place(start uint64, cb func(slot, hv, value uint64, first bool)) uint64 {
+	m := newMergeCursor(ls.newer, ls.older)
+	slot, last, started := start, uint64(0), false
+	for hv, value, ok := m.next(); ok; hv, value, ok = m.next() {
+		q := hv >> ls.qf.rBits
+		first := !started || q != last
+		if first && q > slot {
+			slot = q
+		}
+		cb(slot, hv, value, first)
+		slot++
+		last, started = q, true
+	}
+	return slot
+}
+
+// eachSlot calls cb as place does, but in order of the slot each hash
+// value is stored in, so those wrapping around the end of a classic
+// table come first
+func (ls *levelStream) eachSlot(cb func(slot, hv, value uint64, first bool)) {
+	size := ls.qf.size
+	if ls.wrap > 0 {
+		ls.place(ls.wrap, func(slot, hv, value uint64, first bool) {
+			if slot >= size {
+				cb(slot, hv, value, first)
+			}
+		})
+	}
+	ls.place(ls.wrap, func(slot, hv, value uint64, first bool) {
+		if slot < size || ls.rankSelect() {
+			cb(slot, hv, value, first)
+		}
+	})
+}
+
+// index returns the element of the vectors holding slot
+func (ls *levelStream) index(slot uint64) uint64 {
+	if ls.rankSelect() {
+		return slot
+	}
+	return slot % ls.qf.size
+}
+
+// quotients returns a function reporting whether a quotient is
+// occupied, which must be called with ascending quotients
+func (ls *levelStream) quotients() func(q uint64) bool {
+	m := newMergeCursor(ls.newer, ls.older)
+	hv, _, ok := m.next()
+	return func(q uint64) bool {
+		for ok && hv>>ls.qf.rBits < q {
+			hv, _, ok = m.next()
+		}
+		return ok && hv>>ls.qf.rBits == q
+	}
+}
+
+// vector writes a vector of size elements to w, which fill sets
+func (ls *levelStream) vector(w io.Writer, bits uint, size uint64, fill func(set func(ix, val uint64))) (int64, error) {
+	s := newVectorStream(w, ls.qf.allocfn, bits, size)
+	fill(s.Set)
+	return s.Close()
+}
+
+// bitSetter returns functions setting bits of the words of a vector in
+// ascending order, and setting the last word once all are set
+func bitSetter(set func(ix, val uint64)) (setBit func(bit uint64), flush func()) {
+	var w, word uint64
+	flush = func() {
+		if word != 0 {
+			set(w, word)
+			word = 0
+		}
+	}
+	setBit = func(bit uint64) {
+		if bit/bitsPerWord != w {
+			flush()
+			w = bit / bitsPerWord
+		}
+		word |= 1 << (bit % bitsPerWord)
+	}
+	return
+}
+
+func (ls *levelStream) WriteTo(w io.Writer) (i int64, err error) {
+	qf := ls.qf
+	h := qf.header()
+	if err = binary.Write(w, binary.LittleEndian, h); err != nil {
+		return
+	}
+	i += int64(unsafe.Sizeof(h))
+
+	x, err := writeColumns(w, qf.config.Columns)
+	i += x
+	if err != nil {
+		return
+	}
+
+	slots := qf.size
+	if ls.rankSelect() {
+		slots = rsBlocks(qf.size) * slotsPerBlock
+		x, err = ls.vector(w, qf.rBits, slots, func(set func(ix, val uint64)) {
+			ls.eachSlot(func(slot, hv, _ uint64, _ bool) {
+				set(slot, hv&qf.rMask)
+			})
+		})
+	} else {
+		x, err = ls.vector(w, 3+qf.rBits, slots, func(set func(ix, val uint64)) {
+			occupied := ls.quotients()
+			ls.eachSlot(func(slot, hv, _ uint64, first bool) {
+				var sd slotData
+				sd.setShifted(slot != hv>>qf.rBits)
+				sd.setContinuation(!first)
+				sd.setR(hv & qf.rMask)
+				sd.setOccupied(occupied(ls.index(slot)))
+				set(ls.index(slot), uint64(sd))
+			})
+		})
+	}
+	i += x
+	if err != nil {
+		return
+	}
+
+	if bits := qf.config.BitsOfStoragePerEntry; bits > 0 {
+		x, err = ls.vector(w, bits, slots, func(set func(ix, val uint64)) {
+			ls.eachSlot(func(slot, _, value uint64, _ bool) {
+				set(ls.index(slot), value)
+			})
+		})
+		i += x
+		if err != nil {
+			return
+		}
+	}
+
+	// columns aren't merged, so are written cleared, though a cascade
+	// has none (see OpenCascade)
+	for _, col := range qf.config.Columns {
+		x, err = ls.vector(w, col.Bits, slots, func(func(ix, val uint64)) {})
+		i += x
+		if err != nil {
+			return
+		}
+	}
+
+	if !ls.rankSelect() {
+		return
+	}
+	blocks := rsBlocks(qf.size)
+	vectors := []func(set func(ix, val uint64)){
+		// the occupieds
+		func(set func(ix, val uint64)) {
+			setBit, flush := bitSetter(set)
+			ls.place(0, func(_, hv, _ uint64, first bool) {
+				if first {
+					setBit(hv >> qf.rBits)
+				}
+			})
+			flush()
+		},
+		// the runends
+		func(set func(ix, val uint64)) {
+			setBit, flush := bitSetter(set)
+			last, started := uint64(0), false
+			ls.place(0, func(slot, _, _ uint64, first bool) {
+				if first && started {
+					setBit(last)
+				}
+				last, started = slot, true
+			})
+			if started {
+				setBit(last)
+			}
+			flush()
+		},
+		// the offsets, the slots at the start of each block taken by
+		// the runs of quotients in earlier blocks
+		func(set func(ix, val uint64)) {
+			b, end := uint64(1), uint64(0)
+			offsets := func(to uint64) {
+				for ; b < to; b++ {
+					if base := b * slotsPerBlock; end > base {
+						set(b, end-base)
+					}
+				}
+			}
+			ls.place(0, func(slot, hv, _ uint64, _ bool) {
+				offsets(hv>>qf.rBits/slotsPerBlock + 1)
+				end = slot + 1
+			})
+			offsets(blocks)
+		},
+	}
+	for _, fill := range vectors {
+		x, err = ls.vector(w, bitsPerWord, blocks, fill)
+		i += x
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// filterSource returns a merge source reading qf
+func filterSource(qf *Filter) mergeSource {
+	return func() *hashCursor {
+		v := qf.view()
+		return newHashCursor(&v, qf.rBits)
+	}
+}
+
+func TestLevelStream(t *testing.T) {
+	configs := map[string]Config{
+		"classic":            {BitsOfStoragePerEntry: 10},
+		"packed":             {BitsOfStoragePerEntry: 10, BitPacked: true},
+		"blocked":            {BitsOfStoragePerEntry: 10, VectorAllocate: BlockedVectorAllocate},
+		"rank select":        {BitsOfStoragePerEntry: 10, Layout: LayoutRankSelect},
+		"packed rank select": {BitsOfStoragePerEntry: 10, BitPacked: true, Layout: LayoutRankSelect},
+		"no storage":         {},
+		"columns":            {BitsOfStoragePerEntry: 3, Columns: []Column{{Name: "hits", Bits: 5}}},
+	}
+	// hashes spread over the table, and crowded at its end so that
+	// runs of the classic layout wrap around
+	hashes := map[string]func(r *rand.Rand, i int) uint64{
+		"spread": func(r *rand.Rand, _ int) uint64 { return r.Uint64() },
+		"wrapped": func(r *rand.Rand, i int) uint64 {
+			if i%16 == 0 {
+				return ^uint64(0) - r.Uint64()>>8
+			}
+			return r.Uint64()
+		},
+	}
+	for name, c := range configs {
+		for hname, hash := range hashes {
+			for _, n := range []int{0, 1, 3000, 20000} {
+				t.Run(fmt.Sprintf("%s %s %d", name, hname, n), func(t *testing.T) {
+					r := rand.New(rand.NewSource(int64(n)))
+					c.ExpectedEntries = uint64(n)
+					newer, older := NewWithConfig(c), NewWithConfig(c)
+					mask := uint64(1)<<c.BitsOfStoragePerEntry - 1
+					for i := 0; i < n; i++ {
+						hv := hash(r, i)
+						newer.InsertRawHash(hv, uint64(i)&mask)
+						// some hash values are in both, with different values
+						if i%3 == 0 {
+							older.InsertRawHash(hv, uint64(i+1)&mask)
+						}
+						older.InsertRawHash(hash(r, i), uint64(i)&mask)
+					}
+					c.ExpectedEntries = newer.Len() + older.Len()
+
+					var want, got bytes.Buffer
+					built := buildMerge(c, filterSource(newer), filterSource(older))
+					wantN, err := built.WriteTo(&want)
+					assert.NoError(t, err)
+					ls, ok, err := newLevelStream(c, filterSource(newer), filterSource(older))
+					assert.NoError(t, err)
+					assert.True(t, ok)
+					if hname == "wrapped" && n > 1 && c.Layout == LayoutClassic {
+						assert.NotZero(t, ls.wrap)
+					}
+					x, err := ls.WriteTo(&got)
+					assert.NoError(t, err)
+					assert.Equal(t, wantN, x)
+					assert.Equal(t, built.Len(), ls.qf.entries)
+					assert.Equal(t, want.Len(), got.Len())
+					// the spare trailing word of a packed vector may differ
+					// as it is never read, so compare what is read back
+					var a, b Filter
+					_, err = a.ReadFrom(&want)
+					assert.NoError(t, err)
+					_, err = b.ReadFrom(&got)
+					assert.NoError(t, err)
+					assert.Equal(t, a.header(), b.header())
+					slots, blocks := a.size, uint64(0)
+					if a.rs != nil {
+						blocks = rsBlocks(a.size)
+						slots = blocks * slotsPerBlock
+						for i, v := range a.rs.vectors() {
+							assert.Equal(t, vectorValues(v, blocks), vectorValues(b.rs.vectors()[i], blocks))
+						}
+					}
+					av, bv := []Vector{a.filter}, []Vector{b.filter}
+					if a.storage != nil {
+						av, bv = append(av, a.storage), append(bv, b.storage)
+					}
+					av, bv = append(av, a.columns...), append(bv, b.columns...)
+					assert.Equal(t, len(av), len(bv))
+					for i := range av {
+						assert.Equal(t, vectorValues(av[i], slots), vectorValues(bv[i], slots), "vector %d", i)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestLevelStreamCustomVectors(t *testing.T) {
+	c := Config{VectorAllocate: func(bits uint, size uint64) Vector {
+		return &wrappedVector{UnpackedVectorAllocate(bits, size)}
+	}}
+	qf := NewWithConfig(c)
+	qf.InsertString("x")
+	_, ok, err := newLevelStream(c, filterSource(qf), nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// wrappedVector is a Vector of a custom allocator
+type wrappedVector struct {
+	Vector
+}
+
+// vectorValues returns the first n elements of v
+func vectorValues(v Vector, n uint64) []uint64 {
+	vals := make([]uint64, 0, n)
+	for ix := uint64(0); ix < n; ix++ {
+		vals = append(vals, v.Get(ix))
+	}
+	return vals
+}
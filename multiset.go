@@ -0,0 +1,230 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// defaultMaxValuesPerKey is the number of values each key of a multiset
+// may have when Config.MaxValuesPerKey is zero
+const defaultMaxValuesPerKey = 16
+
+// ErrTooManyValues is returned when inserting a new value for a key of
+// a multiset which already has Config.MaxValuesPerKey values
+var ErrTooManyValues = errors.New("key has too many values")
+
+// maxValuesPerKey returns the number of values each key of a multiset
+// may have
+func (c *Config) maxValuesPerKey() uint64 {
+	if c.MaxValuesPerKey == 0 {
+		return defaultMaxValuesPerKey
+	}
+	return uint64(c.MaxValuesPerKey)
+}
+
+// compareEntry orders the entry at slot, with remainder r, against
+// remainder dr with value.  Entries are ordered by remainder, and the
+// entries of a multiset which share a remainder by value
+func (qf *Filter) compareEntry(slot, r, dr, value uint64) int {
+	switch {
+	case r < dr:
+		return -1
+	case r > dr:
+		return 1
+	case !qf.config.Multiset:
+		return 0
+	}
+	switch stored := qf.storage.Get(slot); {
+	case stored < value:
+		return -1
+	case stored > value:
+		return 1
+	}
+	return 0
+}
+
+// eachMatch calls cb with each slot in the run of quotient dq which
+// holds remainder dr, in order.  There is at most one unless the
+// quotient filter is a multiset
+func (v *slotView) eachMatch(dq, dr uint64, cb func(slot uint64)) {
+	if v.rs != nil {
+		r := v.rs
+		if !r.isOccupied(dq) {
+			return
+		}
+		// runs are sorted, walk backwards from the end of the run to
+		// the matches, which are adjacent
+		var first, last uint64
+		found := false
+		for slot := r.runsEnd(dq) - 1; ; slot-- {
+			rem := r.remainders(slot)
+			if rem == dr {
+				if !found {
+					last, found = slot, true
+				}
+				first = slot
+			}
+			if rem < dr || slot == dq || r.isRunEnd(slot-1) {
+				break
+			}
+		}
+		for slot := first; found && slot <= last; slot++ {
+			cb(slot)
+		}
+		return
+	}
+	sd := slotData(v.filter(dq))
+	if !sd.occupied() {
+		return
+	}
+	slot := dq
+	if sd.shifted() {
+		slot = findStart(dq, v.size, v.filter)
+		sd = slotData(v.filter(slot))
+	}
+	for {
+		if r := sd.r(); r == dr {
+			cb(slot)
+		} else if r > dr {
+			return
+		}
+		right(&slot, v.size)
+		sd = slotData(v.filter(slot))
+		if !sd.continuation() {
+			return
+		}
+	}
+}
+
+// lookupAll returns the values stored with remainder dr in the run of
+// quotient dq, dropping those which have expired when e is set
+func (v *slotView) lookupAll(dq, dr uint64, e *expiry) (values []uint64) {
+	var now uint64
+	if e != nil {
+		now = e.now()
+	}
+	v.eachMatch(dq, dr, func(slot uint64) {
+		var value uint64
+		if v.storage != nil {
+			value = v.storage(slot)
+		}
+		if e != nil {
+			if !e.live(value, now) {
+				return
+			}
+			value = e.value(value)
+		}
+		values = append(values, value)
+	})
+	return
+}
+
+// tryInsertValue is TryInsertRawHash for a multiset, which adds value
+// to those of the key unless it is already present
+func (qf *Filter) tryInsertValue(hv, value uint64) (update bool, err error) {
+	value &= lowMask(uint64(qf.config.BitsOfStoragePerEntry))
+	v := qf.view()
+	n := uint64(0)
+	v.eachMatch(hv>>qf.rBits, hv&qf.rMask, func(slot uint64) {
+		n++
+		update = update || qf.storage.Get(slot) == value
+	})
+	switch {
+	case update:
+		return true, nil
+	case n >= qf.config.maxValuesPerKey():
+		return false, ErrTooManyValues
+	}
+	return qf.tryInsertStored(hv, value)
+}
+
+// LookupAll searches for key and returns every value stored with it,
+// in ascending order, or nil if it is absent.  A key has many values
+// only in a multiset, see Config.Multiset
+func (qf *Filter) LookupAll(key []byte) []uint64 {
+	hv := qf.hasher.sum(key)
+	v := qf.view()
+	return v.lookupAll(hv>>qf.rBits, hv&qf.rMask, qf.expiry)
+}
+
+// LookupAllString is like LookupAll, for a string key
+func (qf *Filter) LookupAllString(key string) []uint64 {
+	return qf.LookupAll(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// DeleteValue removes value from those stored with key, and returns
+// whether it was present.  The key is removed along with its last
+// value, so in a quotient filter which isn't a multiset DeleteValue
+// removes the key if value is its value
+func (qf *Filter) DeleteValue(key []byte, value uint64) bool {
+	return qf.deleteRawHash(qf.hasher.sum(key), value)
+}
+
+// DeleteValueString is like DeleteValue, for a string key
+func (qf *Filter) DeleteValueString(key string, value uint64) bool {
+	return qf.DeleteValue(unsafe.Slice(unsafe.StringData(key), len(key)), value)
+}
+
+// deleteRawHash is DeleteValue for a pre-calculated raw hash value
+func (qf *Filter) deleteRawHash(hv, value uint64) bool {
+	if qf.config.Multiset {
+		value &= lowMask(uint64(qf.config.BitsOfStoragePerEntry))
+	}
+	var now uint64
+	if qf.expiry != nil {
+		now = qf.expiry.now()
+	}
+	dq, dr := hv>>qf.rBits, hv&qf.rMask
+	v := qf.view()
+	var at uint64
+	found := false
+	v.eachMatch(dq, dr, func(slot uint64) {
+		var stored uint64
+		if qf.storage != nil {
+			stored = qf.storage.Get(slot)
+		}
+		if e := qf.expiry; e != nil {
+			if !e.live(stored, now) {
+				return
+			}
+			stored = e.value(stored)
+		}
+		if !found && stored == value {
+			at, found = slot, true
+		}
+	})
+	if !found {
+		return false
+	}
+	if qf.config.ValueArena {
+		_, n := arenaSpan(value)
+		qf.garbage += n
+	}
+	if qf.rs == nil {
+		qf.deleteByHash(dq, at)
+	} else {
+		qf.rsDelete(dq, at)
+	}
+	return true
+}
+
+// LookupAll searches for key and returns every value stored with it,
+// see Filter.LookupAll.  Entries which expire do so by the wall clock
+func (ext *Disk) LookupAll(key []byte) []uint64 {
+	hv := ext.hasher.sum(key)
+	v := ext.view(false)
+	return v.lookupAll(hv>>ext.rBits, hv&ext.rMask, ext.expiry)
+}
+
+// LookupAllString is like LookupAll, for a string key
+func (ext *Disk) LookupAllString(key string) []uint64 {
+	return ext.LookupAll(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// Multiset reports whether a key may have many values, see
+// Config.Multiset
+func (ext *Disk) Multiset() bool {
+	return ext.multiset
+}
@@ -0,0 +1,183 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// entries are deleted from crowded tables, whose runs are shifted and
+// wrap around (in the classic layout), and spill into the overflow
+// slots (in the rank and select layout)
+func TestDeleteValue(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		r := rand.New(rand.NewSource(7))
+		qf := NewWithConfig(Config{
+			BitsOfStoragePerEntry: 8, ExpectedEntries: 50, LoadFactor: 0.9,
+			FixedCapacity: true, Layout: layout,
+			Columns: []Column{{Name: "c", Bits: 16}},
+		})
+		hv := func() uint64 {
+			// few quotients, most of them at the end of the table
+			q := qf.size - 1 - uint64(r.Intn(12))
+			if r.Intn(4) == 0 {
+				q = uint64(r.Intn(int(qf.size)))
+			}
+			return q<<qf.rBits | uint64(r.Intn(8))
+		}
+		inserted := map[uint64]uint64{}
+		for i := 0; i < 5000; i++ {
+			x := hv()
+			if len(inserted) < 40 && r.Intn(2) == 0 {
+				_, err := qf.TryInsertRawHash(x, uint64(i%256))
+				assert.NoError(t, err)
+				inserted[x] = uint64(i % 256)
+				continue
+			}
+			value, present := inserted[x]
+			// the wrong value deletes nothing
+			assert.False(t, qf.deleteRawHash(x, value+1))
+			assert.Equal(t, present, qf.deleteRawHash(x, value), "%d: delete %x", i, x)
+			delete(inserted, x)
+			if !assert.NoError(t, qf.Validate(), "%d: delete %x", i, x) {
+				qf.DebugDump(true)
+				return
+			}
+		}
+		assert.Equal(t, uint64(len(inserted)), qf.Len())
+		for x, value := range inserted {
+			found, v := qf.LookupRawHash(x)
+			assert.True(t, found, "%x missing", x)
+			assert.Equal(t, value, v)
+		}
+	}
+
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8})
+	qf.InsertStringWithValue("key", 3)
+	assert.False(t, qf.DeleteValueString("key", 4))
+	assert.True(t, qf.DeleteValueString("key", 3))
+	assert.False(t, qf.ContainsString("key"))
+	assert.Zero(t, qf.Len())
+}
+
+// deleting keeps the columns of the entries which move with the entries
+func TestDeleteMovesColumns(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		qf := NewWithConfig(Config{Layout: layout, Columns: []Column{{Name: "c", Bits: 32}}})
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("k%d", i)
+			qf.InsertString(key)
+			qf.SetString(key, 0, uint64(i))
+		}
+		for i := 0; i < 1000; i += 3 {
+			assert.True(t, qf.DeleteValueString(fmt.Sprintf("k%d", i), 0))
+		}
+		assert.NoError(t, qf.Validate())
+		for i := 0; i < 1000; i++ {
+			found, v := qf.GetString(fmt.Sprintf("k%d", i), 0)
+			assert.Equal(t, i%3 != 0, found)
+			if found {
+				assert.Equal(t, uint64(i), v)
+			}
+		}
+	}
+}
+
+func TestMultiset(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8, Multiset: true, MaxValuesPerKey: 4, Layout: layout})
+		assert.False(t, qf.InsertStringWithValue("user", 7))
+		assert.False(t, qf.InsertStringWithValue("user", 3))
+		assert.True(t, qf.InsertStringWithValue("user", 7))
+		// values are truncated to the storage
+		assert.False(t, qf.InsertStringWithValue("user", 0x105))
+		assert.Equal(t, []uint64{3, 5, 7}, qf.LookupAllString("user"))
+		assert.Nil(t, qf.LookupAllString("nobody"))
+		assert.Equal(t, uint64(3), qf.Len())
+		found, v := qf.LookupString("user")
+		assert.True(t, found)
+		assert.Contains(t, []uint64{3, 5, 7}, v)
+
+		// the cap applies to new values, not those already present
+		assert.False(t, qf.InsertStringWithValue("user", 1))
+		_, err := qf.TryInsertWithValue([]byte("user"), 2)
+		assert.Equal(t, ErrTooManyValues, err)
+		assert.Panics(t, func() { qf.InsertStringWithValue("user", 2) })
+		assert.True(t, qf.InsertStringWithValue("user", 1))
+
+		assert.True(t, qf.DeleteValueString("user", 5))
+		assert.False(t, qf.DeleteValueString("user", 5))
+		assert.Equal(t, []uint64{1, 3, 7}, qf.LookupAllString("user"))
+
+		// many keys with many values, across doublings
+		want := map[string][]uint64{}
+		for i := 0; i < 3000; i++ {
+			key := fmt.Sprintf("k%d", i)
+			for j := 0; j < i%4; j++ {
+				qf.InsertStringWithValue(key, uint64(j*10+i%7))
+				want[key] = append(want[key], uint64(j*10+i%7))
+			}
+		}
+		for i := 0; i < 3000; i += 5 {
+			key := fmt.Sprintf("k%d", i)
+			if len(want[key]) > 0 {
+				assert.True(t, qf.DeleteValueString(key, want[key][0]))
+				want[key] = want[key][1:]
+			}
+		}
+		assert.NoError(t, qf.Validate())
+
+		type allReader interface {
+			LookupAllString(string) []uint64
+			Validate() error
+		}
+		check := func(r allReader) {
+			assert.NoError(t, r.Validate())
+			for key, values := range want {
+				if len(values) == 0 {
+					values = nil
+				}
+				assert.Equal(t, values, r.LookupAllString(key), key)
+			}
+			assert.Equal(t, []uint64{1, 3, 7}, r.LookupAllString("user"))
+		}
+		check(qf)
+
+		var buf bytes.Buffer
+		_, err = qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		var cpy Filter
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		check(&cpy)
+		// the cap is kept too
+		assert.False(t, cpy.InsertStringWithValue("user", 2))
+		_, err = cpy.TryInsertWithValue([]byte("user"), 4)
+		assert.Equal(t, ErrTooManyValues, err)
+
+		name, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		assert.True(t, ext.Multiset())
+		check(ext)
+		ext.Close()
+		os.Remove(name)
+	}
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("a"), []byte("a")}
+	qf, err := BuildParallel(Config{BitsOfStoragePerEntry: 8, Multiset: true}, keys, []uint64{1, 2, 3, 1}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 3}, qf.LookupAllString("a"))
+	assert.Equal(t, uint64(3), qf.Len())
+
+	assert.Panics(t, func() { NewWithConfig(Config{Multiset: true}) })
+	assert.Panics(t, func() { NewWithConfig(Config{Multiset: true, ValueArena: true}) })
+	assert.Panics(t, func() { NewWithConfig(Config{Multiset: true, BitsOfStoragePerEntry: 8, TTL: 1e9}) })
+}
@@ -0,0 +1,334 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"unsafe"
+)
+
+const (
+	// namespacedVersion is the version of the serialized Namespaced
+	// filter, which is followed by its tenant counts and quotient filter
+	namespacedVersion = uint64(0x0001)
+	// defaultTenantBits is the width of tenant IDs when
+	// NamespacedConfig.TenantBits is zero
+	defaultTenantBits = 16
+	// tenantColumn is the column holding the tenant of each entry
+	tenantColumn = "tenant"
+	// tenantSalt spreads tenant IDs over the word they are combined
+	// with hashes by, it is odd so distinct tenants have distinct salts
+	tenantSalt = 0x9e3779b97f4a7c15
+)
+
+// NamespacedConfig controls the behavior of a Namespaced filter
+type NamespacedConfig struct {
+	// Config configures the shared quotient filter.  It may not
+	// include Columns, TTL, ValueArena or Multiset
+	Config
+	// TenantBits is the width of tenant IDs, between 1 and 64, which
+	// is kept with every entry.  When zero 16 bits are used
+	TenantBits uint
+}
+
+// Namespaced hosts the keys of many tenants in one quotient filter,
+// rather than a quotient filter per tenant each of at least the
+// minimum size.  The tenant ID is mixed into the hash of each key, so
+// that the keys of different tenants are distinct entries, and is kept
+// with each entry in a column so that the entries of a tenant can be
+// iterated or dropped.  The number of entries of each tenant is kept in
+// a side table
+type Namespaced struct {
+	qf         *Filter
+	tenantBits uint
+	// counts holds the number of entries of each tenant with any
+	counts map[uint64]uint64
+}
+
+// NewNamespaced allocates a Namespaced filter, whose shared quotient
+// filter is allocated with c.Config and an extra column for tenants
+func NewNamespaced(c NamespacedConfig) *Namespaced {
+	if len(c.Columns) != 0 || c.TTL != 0 || c.ValueArena || c.Multiset || c.FingerprintBits != 0 {
+		panic("a namespaced filter can't be combined with Columns, TTL, ValueArena, Multiset or FingerprintBits")
+	}
+	bits := c.TenantBits
+	if bits == 0 {
+		bits = defaultTenantBits
+	}
+	if bits > bitsPerWord {
+		panic(fmt.Sprintf("%d tenant bits is out of range, must be between 1 and %d", bits, bitsPerWord))
+	}
+	c.Columns = []Column{{Name: tenantColumn, Bits: bits}}
+	return &Namespaced{
+		qf:         NewWithConfig(c.Config),
+		tenantBits: bits,
+		counts:     map[uint64]uint64{},
+	}
+}
+
+// tenantHash returns the hash of the entry of key hash hv of tenant,
+// it panics if tenant doesn't fit in the tenant bits.  Tenants are
+// combined with hashes before mixing, as combining mixed words would
+// let the hashes of one tenant's keys cancel another's
+func (ns *Namespaced) tenantHash(tenant, hv uint64) uint64 {
+	if tenant > lowMask(uint64(ns.tenantBits)) {
+		panic(fmt.Sprintf("tenant %d doesn't fit in %d tenant bits", tenant, ns.tenantBits))
+	}
+	return ns.qf.config.IntegerMixer.mix(hv ^ tenant*tenantSalt)
+}
+
+// keyHash returns the hash of key hv from the hash of the entry of a
+// tenant, see tenantHash
+func (ns *Namespaced) keyHash(tenant, hv uint64) uint64 {
+	return ns.qf.config.IntegerMixer.unmix(hv) ^ tenant*tenantSalt
+}
+
+// Len returns the number of entries of every tenant
+func (ns *Namespaced) Len() uint64 {
+	return ns.qf.Len()
+}
+
+// TenantLen returns the number of entries of tenant
+func (ns *Namespaced) TenantLen(tenant uint64) uint64 {
+	return ns.counts[tenant]
+}
+
+// Tenants returns the IDs of the tenants with any entries, in
+// ascending order
+func (ns *Namespaced) Tenants() []uint64 {
+	ids := make([]uint64, 0, len(ns.counts))
+	for id := range ns.counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// TryInsertRawHash stores the pre-calculated hash of a key of tenant,
+// see Filter.InsertRawHash, and an integer value.  It returns whether
+// the key already existed, or ErrFilterFull if a shared quotient
+// filter of fixed capacity is full
+func (ns *Namespaced) TryInsertRawHash(tenant, hv, value uint64) (update bool, err error) {
+	th := ns.tenantHash(tenant, hv)
+	if update, err = ns.qf.TryInsertRawHash(th, value); err != nil || update {
+		return
+	}
+	_, slot := ns.qf.entrySlot(th)
+	ns.qf.columns[0].Set(slot, tenant)
+	ns.counts[tenant]++
+	return false, nil
+}
+
+// InsertRawHash is like TryInsertRawHash, but panics with
+// ErrFilterFull if a shared quotient filter of fixed capacity is full
+func (ns *Namespaced) InsertRawHash(tenant, hv, value uint64) (update bool) {
+	update, err := ns.TryInsertRawHash(tenant, hv, value)
+	if err != nil {
+		panic(err)
+	}
+	return update
+}
+
+// InsertWithValue stores the key (byte slice) of tenant and an integer
+// value, and returns whether the key already existed.  Like
+// Filter.InsertWithValue, it panics with ErrFilterFull if a shared
+// quotient filter of fixed capacity is full
+func (ns *Namespaced) InsertWithValue(tenant uint64, key []byte, value uint64) (update bool) {
+	return ns.InsertRawHash(tenant, ns.qf.hasher.sum(key), value)
+}
+
+// Insert stores the key (byte slice) of tenant, see InsertWithValue
+func (ns *Namespaced) Insert(tenant uint64, key []byte) (update bool) {
+	return ns.InsertWithValue(tenant, key, 0)
+}
+
+// InsertStringWithValue is like InsertWithValue, for a string key
+func (ns *Namespaced) InsertStringWithValue(tenant uint64, key string, value uint64) (update bool) {
+	return ns.InsertWithValue(tenant, unsafe.Slice(unsafe.StringData(key), len(key)), value)
+}
+
+// InsertString is like Insert, for a string key
+func (ns *Namespaced) InsertString(tenant uint64, key string) (update bool) {
+	return ns.InsertStringWithValue(tenant, key, 0)
+}
+
+// InsertUint64WithValue stores the integer key id of tenant and an
+// integer value, see Filter.InsertUint64WithValue
+func (ns *Namespaced) InsertUint64WithValue(tenant, id, value uint64) (update bool) {
+	c := &ns.qf.config
+	return ns.InsertRawHash(tenant, c.IntegerMixer.mix(id^c.integerKey()), value)
+}
+
+// LookupRawHash searches for the pre-calculated hash of a key of
+// tenant, and returns whether it exists and the value stored with it
+// (if any)
+func (ns *Namespaced) LookupRawHash(tenant, hv uint64) (bool, uint64) {
+	return ns.qf.LookupRawHash(ns.tenantHash(tenant, hv))
+}
+
+// Lookup searches for the key (byte slice) of tenant, and returns
+// whether it exists and the value stored with it (if any)
+func (ns *Namespaced) Lookup(tenant uint64, key []byte) (bool, uint64) {
+	return ns.LookupRawHash(tenant, ns.qf.hasher.sum(key))
+}
+
+// LookupString is like Lookup, for a string key
+func (ns *Namespaced) LookupString(tenant uint64, key string) (bool, uint64) {
+	return ns.Lookup(tenant, unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// LookupUint64 is like Lookup, for an integer key
+func (ns *Namespaced) LookupUint64(tenant, id uint64) (bool, uint64) {
+	c := &ns.qf.config
+	return ns.LookupRawHash(tenant, c.IntegerMixer.mix(id^c.integerKey()))
+}
+
+// Contains returns whether the key (byte slice) of tenant is present
+func (ns *Namespaced) Contains(tenant uint64, key []byte) bool {
+	found, _ := ns.Lookup(tenant, key)
+	return found
+}
+
+// ContainsString returns whether the string key of tenant is present
+func (ns *Namespaced) ContainsString(tenant uint64, key string) bool {
+	found, _ := ns.LookupString(tenant, key)
+	return found
+}
+
+// ContainsUint64 returns whether the integer key id of tenant is
+// present
+func (ns *Namespaced) ContainsUint64(tenant, id uint64) bool {
+	found, _ := ns.LookupUint64(tenant, id)
+	return found
+}
+
+// EachRawHash calls cb with the hash of every key of tenant, as passed
+// to InsertRawHash, and its value (if any), in no particular order
+func (ns *Namespaced) EachRawHash(tenant uint64, cb func(hv, value uint64)) {
+	qf := ns.qf
+	if ns.counts[tenant] == 0 {
+		return
+	}
+	qf.eachHashValue(func(hv, slot uint64) {
+		if qf.columns[0].Get(slot) != tenant {
+			return
+		}
+		value := uint64(0)
+		if qf.storage != nil {
+			value = qf.storage.Get(slot)
+		}
+		cb(ns.keyHash(tenant, hv), value)
+	})
+}
+
+// EachUint64 calls cb with every key of tenant, and its value (if any),
+// in no particular order.  As with Filter.EachUint64 every key of the
+// tenant must have been inserted as an integer key
+func (ns *Namespaced) EachUint64(tenant uint64, cb func(id, value uint64)) {
+	c := &ns.qf.config
+	mixer, key := c.IntegerMixer, c.integerKey()
+	ns.EachRawHash(tenant, func(hv, value uint64) {
+		cb(mixer.unmix(hv)^key, value)
+	})
+}
+
+// DropTenant removes every entry of tenant in one pass over the shared
+// quotient filter, returning the number removed
+func (ns *Namespaced) DropTenant(tenant uint64) (removed uint64) {
+	qf := ns.qf
+	if ns.counts[tenant] == 0 {
+		return 0
+	}
+	before := qf.entries
+	tenants := qf.columns[0]
+	qf.rebuild(qf.qBits, 0, func(slot uint64) bool {
+		return tenants.Get(slot) == tenant
+	})
+	delete(ns.counts, tenant)
+	return before - qf.entries
+}
+
+// namespacedHeader describes a serialized Namespaced filter
+type namespacedHeader struct {
+	Version    uint64
+	TenantBits uint64
+	// the number of tenants, the ID and entry count of each follows
+	// the header, and then the shared quotient filter
+	Tenants uint64
+}
+
+// WriteTo writes the Namespaced filter, including its shared quotient
+// filter, to a stream, see Filter.WriteTo
+func (ns *Namespaced) WriteTo(stream io.Writer) (i int64, err error) {
+	h := namespacedHeader{
+		Version:    namespacedVersion,
+		TenantBits: uint64(ns.tenantBits),
+		Tenants:    uint64(len(ns.counts)),
+	}
+	if err = binary.Write(stream, binary.LittleEndian, h); err != nil {
+		return
+	}
+	i += int64(unsafe.Sizeof(h))
+	for _, id := range ns.Tenants() {
+		if err = binary.Write(stream, binary.LittleEndian, [2]uint64{id, ns.counts[id]}); err != nil {
+			return
+		}
+		i += 2 * bytesPerWord
+	}
+	x, err := ns.qf.WriteTo(stream)
+	i += x
+	return
+}
+
+// ReadFrom reads a Namespaced filter written by WriteTo from a stream.
+// As with Filter.ReadFrom, the HashFn of a Namespaced filter from
+// NewNamespaced is kept
+func (ns *Namespaced) ReadFrom(stream io.Reader) (i int64, err error) {
+	var h namespacedHeader
+	if err = binary.Read(stream, binary.LittleEndian, &h); err != nil {
+		return
+	}
+	i += int64(unsafe.Sizeof(h))
+	if h.Version != namespacedVersion {
+		return i, fmt.Errorf("incompatible namespaced filter format: version is %d, expected %d",
+			h.Version, namespacedVersion)
+	}
+	if h.TenantBits == 0 || h.TenantBits > bitsPerWord {
+		return i, fmt.Errorf("invalid namespaced filter format, %d tenant bits", h.TenantBits)
+	}
+	cpy := Namespaced{
+		qf:         &Filter{},
+		tenantBits: uint(h.TenantBits),
+		counts:     map[uint64]uint64{},
+	}
+	if ns.qf != nil {
+		cpy.qf.config.HashFn = ns.qf.config.HashFn
+	}
+	var total uint64
+	for j := uint64(0); j < h.Tenants; j++ {
+		var w [2]uint64
+		if err = binary.Read(stream, binary.LittleEndian, &w); err != nil {
+			return
+		}
+		i += 2 * bytesPerWord
+		cpy.counts[w[0]] = w[1]
+		total += w[1]
+	}
+	x, err := cpy.qf.ReadFrom(stream)
+	i += x
+	if err != nil {
+		return
+	}
+	if cols := cpy.qf.config.Columns; len(cols) != 1 || cols[0] != (Column{Name: tenantColumn, Bits: cpy.tenantBits}) {
+		return i, fmt.Errorf("invalid namespaced filter format, quotient filter has columns %v", cols)
+	}
+	if total != cpy.qf.Len() {
+		return i, fmt.Errorf("invalid namespaced filter format, tenants have %d entries but quotient filter has %d",
+			total, cpy.qf.Len())
+	}
+	*ns = cpy
+	return
+}
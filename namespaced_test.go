@@ -0,0 +1,117 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaced(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		ns := NewNamespaced(NamespacedConfig{Config: Config{BitsOfStoragePerEntry: 16, Layout: layout}, TenantBits: 8})
+		// the same keys in every tenant, with values of their own, so
+		// that the shared quotient filter doubles a few times
+		for tenant := uint64(0); tenant < 20; tenant++ {
+			for i := uint64(0); i < 100+tenant*10; i++ {
+				assert.False(t, ns.InsertStringWithValue(tenant, fmt.Sprintf("k%d", i), tenant<<8|i%256))
+			}
+		}
+		assert.True(t, ns.InsertStringWithValue(3, "k1", 3<<8|1))
+		assert.Equal(t, uint64(20*100+10*190), ns.Len())
+		assert.Equal(t, uint64(130), ns.TenantLen(3))
+		assert.Zero(t, ns.TenantLen(99))
+		assert.Len(t, ns.Tenants(), 20)
+
+		check := func(ns *Namespaced, dropped map[uint64]bool) {
+			assert.NoError(t, ns.qf.Validate())
+			total := uint64(0)
+			for tenant := uint64(0); tenant < 20; tenant++ {
+				n := 100 + tenant*10
+				if dropped[tenant] {
+					n = 0
+				}
+				assert.Equal(t, n, ns.TenantLen(tenant))
+				total += n
+				for i := uint64(0); i < 100+tenant*10; i++ {
+					found, v := ns.LookupString(tenant, fmt.Sprintf("k%d", i))
+					assert.Equal(t, !dropped[tenant], found, "tenant %d key %d", tenant, i)
+					if found {
+						assert.Equal(t, tenant<<8|i%256, v)
+					}
+				}
+				// the keys of larger tenants are absent from smaller
+				assert.False(t, ns.ContainsString(tenant, fmt.Sprintf("k%d", 100+tenant*10)))
+
+				var hashes []uint64
+				ns.EachRawHash(tenant, func(hv, value uint64) {
+					assert.Equal(t, tenant, value>>8)
+					hashes = append(hashes, hv)
+				})
+				assert.Len(t, hashes, int(n))
+				for _, hv := range hashes {
+					found, _ := ns.LookupRawHash(tenant, hv)
+					assert.True(t, found)
+				}
+			}
+			assert.Equal(t, total, ns.Len())
+		}
+		check(ns, nil)
+
+		assert.Equal(t, uint64(130), ns.DropTenant(3))
+		assert.Zero(t, ns.DropTenant(3))
+		assert.Equal(t, uint64(100), ns.DropTenant(0))
+		dropped := map[uint64]bool{0: true, 3: true}
+		check(ns, dropped)
+		assert.NotContains(t, ns.Tenants(), uint64(3))
+
+		var buf bytes.Buffer
+		_, err := ns.WriteTo(&buf)
+		assert.NoError(t, err)
+		var cpy Namespaced
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		check(&cpy, dropped)
+		assert.Equal(t, ns.Tenants(), cpy.Tenants())
+		// tenants can be repopulated
+		assert.False(t, cpy.InsertString(3, "k1"))
+		assert.Equal(t, uint64(1), cpy.TenantLen(3))
+	}
+
+	assert.Panics(t, func() { NewNamespaced(NamespacedConfig{Config: Config{Columns: []Column{{Name: "c", Bits: 1}}}}) })
+	assert.Panics(t, func() { NewNamespaced(NamespacedConfig{Config: Config{FingerprintBits: 8}}) })
+	assert.Panics(t, func() { NewNamespaced(NamespacedConfig{TenantBits: 65}) })
+	assert.Panics(t, func() { NewNamespaced(NamespacedConfig{TenantBits: 4}).InsertString(16, "k") })
+}
+
+func TestNamespacedUint64(t *testing.T) {
+	ns := NewNamespaced(NamespacedConfig{Config: Config{BitsOfStoragePerEntry: 8, KeyedHash: true}})
+	// tenant a's key b and tenant b's key a are distinct
+	for a := uint64(0); a < 50; a++ {
+		for b := uint64(0); b < 50; b++ {
+			ns.InsertUint64WithValue(a, b, a)
+		}
+	}
+	assert.Equal(t, uint64(2500), ns.Len())
+	assert.True(t, ns.ContainsUint64(7, 49))
+	assert.False(t, ns.ContainsUint64(7, 50))
+	found, v := ns.LookupUint64(9, 4)
+	assert.True(t, found)
+	assert.Equal(t, uint64(9), v)
+
+	var ids []uint64
+	ns.EachUint64(12, func(id, value uint64) {
+		assert.Equal(t, uint64(12), value)
+		ids = append(ids, id)
+	})
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	want := make([]uint64, 50)
+	for i := range want {
+		want[i] = uint64(i)
+	}
+	assert.Equal(t, want, ids)
+}
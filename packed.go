@@ -58,6 +58,46 @@ func genForbiddenMask(bits uint) uint64 {
 	return ^((uint64(1) << bits) - 1)
 }
 
+func (p *packed) ShiftRight(from, to uint64) {
+	b := uint64(p.bits)
+	shiftBitsUp(p.space, from*b, to*b, p.bits)
+}
+
+// shiftBitsUp moves bits [lo, hi) of space up by k <= 64 positions, to
+// [lo+k, hi+k), a word at a time.  Bits [lo, lo+k) are left unchanged
+func shiftBitsUp(space []uint64, lo, hi uint64, k uint) {
+	if hi <= lo {
+		return
+	}
+	dlo, dhi := lo+uint64(k), hi+uint64(k)
+	// work down from the top so that every source word is read before
+	// it is overwritten
+	for w := (dhi - 1) / bitsPerWord; ; w-- {
+		var src uint64
+		if k == bitsPerWord {
+			src = space[w-1]
+		} else {
+			src = space[w] << k
+			if w > 0 {
+				src |= space[w-1] >> (bitsPerWord - k)
+			}
+		}
+		base := w * bitsPerWord
+		first, last := uint64(0), uint64(bitsPerWord)
+		if dlo > base {
+			first = dlo - base
+		}
+		if dhi < base+bitsPerWord {
+			last = dhi - base
+		}
+		mask := lowMask(last) &^ lowMask(first)
+		space[w] = space[w]&^mask | src&mask
+		if w == dlo/bitsPerWord {
+			break
+		}
+	}
+}
+
 // Swap in val at ix and return old value
 func (p *packed) Swap(ix uint64, val uint64) (oldval uint64) {
 	// XXX this could be more efficient
@@ -196,6 +236,15 @@ func initPackedDiskReader(stream *os.File) (*packedDiskReader, error) {
 	return &packedDiskReader{stream, uint64(cur), uint64(h.Size), uint(h.Bits)}, err
 }
 
+func (r packedDiskReader) withReaderAt(ra io.ReaderAt) extReader {
+	r.r = ra
+	return r
+}
+
+func (r packedDiskReader) bytes() uint64 {
+	return wordsRequired(r.bits, r.size) * bytesPerWord
+}
+
 func (r packedDiskReader) Read(ix uint64) (val uint64, err error) {
 	return getValFromPackedIx(ix, r.bits, func(off uint64, cnt uint64) ([]uint64, error) {
 		space := make([]uint64, cnt)
@@ -31,3 +31,43 @@ func TestBitPacking(t *testing.T) {
 		}
 	}
 }
+
+// plainVector hides the Shifter implementation of the vector it wraps
+type plainVector struct {
+	Vector
+}
+
+func TestShiftRight(t *testing.T) {
+	r := rand.New(rand.NewSource(77))
+	allocators := map[string]VectorAllocateFn{
+		"unpacked": UnpackedVectorAllocate,
+		"packed":   BitPackedVectorAllocate,
+		"blocked":  BlockedVectorAllocate,
+		"fallback": func(bits uint, size uint64) Vector {
+			return plainVector{BitPackedVectorAllocate(bits, size)}
+		},
+	}
+	for name, alloc := range allocators {
+		for _, bits := range []uint{1, 3, 8, 13, 32, 47, 61, 64} {
+			n := uint64(200)
+			v := alloc(bits, n)
+			expect := make([]uint64, n)
+			for i := range expect {
+				expect[i] = r.Uint64() >> (64 - bits)
+				v.Set(uint64(i), expect[i])
+			}
+			for j := 0; j < 50; j++ {
+				from := uint64(r.Int63n(int64(n)))
+				to := from + uint64(r.Int63n(int64(n-from)))
+				shiftRight(v, from, to)
+				copy(expect[from+1:to+1], expect[from:to])
+				for i := uint64(0); i < n; i++ {
+					if !assert.Equal(t, expect[i], v.Get(i), "%s %d bits, shift [%d, %d) slot %d",
+						name, bits, from, to, i) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
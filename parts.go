@@ -0,0 +1,131 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import "encoding/binary"
+
+// partsBufSize is the size of the buffer on the stack in which the
+// parts of a key are framed, keys which don't fit are framed on the heap
+const partsBufSize = 256
+
+// Hasher hashes a key made up of parts, exactly as InsertParts and
+// LookupParts do, for use with InsertRawHash and LookupRawHash.  A
+// Hasher may be reused after Reset, hashing keys without allocating
+type Hasher interface {
+	// Write appends p to the key as its next part, it never fails
+	Write(p []byte) (int, error)
+	// Sum64 returns the hash of the parts written since Reset
+	Sum64() uint64
+	// Reset discards the parts written
+	Reset()
+}
+
+// appendPart frames part with its length, as a uvarint, so that
+// distinct sequences of parts never share an encoding
+func appendPart(dst, part []byte) []byte {
+	return append(binary.AppendUvarint(dst, uint64(len(part))), part...)
+}
+
+// parts hashes the key made up of parts
+func (h *keyHash) parts(parts [][]byte) uint64 {
+	var buf [partsBufSize]byte
+	key := buf[:0]
+	for _, p := range parts {
+		key = appendPart(key, p)
+	}
+	if h.alg == HashCustom {
+		// a HashFn may retain its argument
+		return h.custom(append([]byte(nil), key...))
+	}
+	return h.builtin(key)
+}
+
+type partsHasher struct {
+	h   keyHash
+	key []byte
+	buf [partsBufSize]byte
+}
+
+func newPartsHasher(h keyHash) *partsHasher {
+	ph := &partsHasher{h: h}
+	ph.key = ph.buf[:0]
+	return ph
+}
+
+func (ph *partsHasher) Write(p []byte) (int, error) {
+	ph.key = appendPart(ph.key, p)
+	return len(p), nil
+}
+
+func (ph *partsHasher) Sum64() uint64 {
+	return ph.h.sum(ph.key)
+}
+
+func (ph *partsHasher) Reset() {
+	ph.key = ph.key[:0]
+}
+
+// NewHasher returns a Hasher which hashes keys made up of parts as the
+// quotient filter does
+func (qf *Filter) NewHasher() Hasher {
+	return newPartsHasher(qf.hasher)
+}
+
+// InsertPartsWithValue stores the key made up of parts, and an integer
+// value, in the quotient filter.  It returns whether a value already
+// existed.  Each part is framed with its length, so keys made up of
+// different parts never collide however their bytes line up, and no
+// memory is allocated unless the framed key exceeds 256 bytes.  Like
+// InsertWithValue, it panics with ErrFilterFull if a quotient filter of
+// fixed capacity is full
+func (qf *Filter) InsertPartsWithValue(value uint64, parts ...[]byte) (update bool) {
+	update, err := qf.TryInsertPartsWithValue(value, parts...)
+	if err != nil {
+		panic(err)
+	}
+	return update
+}
+
+// TryInsertPartsWithValue is like InsertPartsWithValue, see
+// TryInsertWithValue
+func (qf *Filter) TryInsertPartsWithValue(value uint64, parts ...[]byte) (update bool, err error) {
+	return qf.TryInsertRawHash(qf.hasher.parts(parts), value)
+}
+
+// InsertParts stores the key made up of parts in the quotient filter,
+// it returns whether it already existed.  See InsertPartsWithValue
+func (qf *Filter) InsertParts(parts ...[]byte) (update bool) {
+	return qf.InsertPartsWithValue(0, parts...)
+}
+
+// ContainsParts returns whether the key made up of parts is contained
+// within the quotient filter
+func (qf *Filter) ContainsParts(parts ...[]byte) bool {
+	found, _ := qf.LookupParts(parts...)
+	return found
+}
+
+// LookupParts searches for the key made up of parts and returns
+// whether it exists, and the value stored with it (if any)
+func (qf *Filter) LookupParts(parts ...[]byte) (bool, uint64) {
+	return qf.LookupRawHash(qf.hasher.parts(parts))
+}
+
+// NewHasher returns a Hasher which hashes keys made up of parts as the
+// quotient filter does
+func (ext *Disk) NewHasher() Hasher {
+	return newPartsHasher(ext.hasher)
+}
+
+// ContainsParts returns whether the key made up of parts is contained
+// within the quotient filter
+func (ext *Disk) ContainsParts(parts ...[]byte) bool {
+	found, _ := ext.LookupParts(parts...)
+	return found
+}
+
+// LookupParts searches for the key made up of parts and returns
+// whether it exists, and the value stored with it (if any)
+func (ext *Disk) LookupParts(parts ...[]byte) (bool, uint64) {
+	return ext.LookupRawHash(ext.hasher.parts(parts))
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParts(t *testing.T) {
+	for _, c := range []Config{
+		{BitsOfStoragePerEntry: 16},
+		{BitsOfStoragePerEntry: 16, Layout: LayoutRankSelect, HashAlgorithm: HashXXH3},
+		{BitsOfStoragePerEntry: 16, KeyedHash: true},
+	} {
+		qf := NewWithConfig(c)
+		for i := 0; i < 1000; i++ {
+			tenant := []byte(fmt.Sprintf("t%d", i%7))
+			assert.False(t, qf.InsertPartsWithValue(uint64(i), tenant, []byte("doc"), []byte(fmt.Sprint(i))))
+		}
+		found, v := qf.LookupParts([]byte("t3"), []byte("doc"), []byte("10"))
+		assert.True(t, found)
+		assert.Equal(t, uint64(10), v)
+		assert.False(t, qf.ContainsParts([]byte("t3"), []byte("doc"), []byte("11")))
+
+		// parts are framed, so their boundaries matter
+		assert.False(t, qf.InsertParts([]byte("ab"), []byte("c")))
+		assert.False(t, qf.ContainsParts([]byte("a"), []byte("bc")))
+		assert.False(t, qf.ContainsParts([]byte("abc")))
+		assert.False(t, qf.ContainsParts([]byte("ab"), []byte("c"), nil))
+		assert.False(t, qf.Contains([]byte("abc")))
+		assert.True(t, qf.ContainsParts([]byte("ab"), []byte("c")))
+		// as is an empty key
+		assert.False(t, qf.ContainsParts())
+		qf.InsertParts()
+		assert.True(t, qf.ContainsParts())
+		assert.False(t, qf.ContainsParts(nil))
+
+		// a Hasher hashes the same parts identically
+		h := qf.NewHasher()
+		for i := 0; i < 3; i++ {
+			h.Reset()
+			h.Write([]byte("t3"))
+			h.Write([]byte("doc"))
+			h.Write([]byte("10"))
+			found, v = qf.LookupRawHash(h.Sum64())
+			assert.True(t, found)
+			assert.Equal(t, uint64(10), v)
+		}
+
+		// keys longer than the stack buffer
+		long := make([]byte, 1000)
+		qf.InsertPartsWithValue(99, long, long)
+		found, v = qf.LookupParts(long, long)
+		assert.True(t, found)
+		assert.Equal(t, uint64(99), v)
+
+		name, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		found, v = ext.LookupParts([]byte("t3"), []byte("doc"), []byte("10"))
+		assert.True(t, found)
+		assert.Equal(t, uint64(10), v)
+		assert.False(t, ext.ContainsParts([]byte("a"), []byte("bc")))
+		h = ext.NewHasher()
+		h.Write(long)
+		h.Write(long)
+		found, v = ext.LookupRawHash(h.Sum64())
+		assert.True(t, found)
+		assert.Equal(t, uint64(99), v)
+		ext.Close()
+		os.Remove(name)
+	}
+
+	// custom hash functions may retain their argument, so are given a
+	// copy of the key
+	var retained [][]byte
+	qf := NewWithConfig(Config{HashFn: func(v []byte) uint64 {
+		retained = append(retained, v)
+		return Murmur64(v)
+	}})
+	qf.InsertParts([]byte("x"), []byte("y"))
+	qf.InsertParts([]byte("z"))
+	assert.True(t, qf.ContainsParts([]byte("x"), []byte("y")))
+	assert.Equal(t, []byte("\x01x\x01y"), retained[0])
+}
+
+func TestPartsDontAllocate(t *testing.T) {
+	for _, a := range []HashAlgorithm{HashMurmur, HashXXH3, HashSipHash} {
+		qf := NewWithConfig(Config{ExpectedEntries: 1000, HashAlgorithm: a})
+		tenant, typ, name := []byte("tenant"), []byte("type"), []byte("name")
+		qf.InsertParts(tenant, typ, name)
+		allocs := testing.AllocsPerRun(100, func() {
+			qf.LookupParts(tenant, typ, name)
+			qf.InsertParts(tenant, typ, name)
+		})
+		assert.Zero(t, allocs, "%s", a)
+		h := qf.NewHasher()
+		allocs = testing.AllocsPerRun(100, func() {
+			h.Reset()
+			h.Write(tenant)
+			h.Write(typ)
+			h.Write(name)
+			qf.LookupRawHash(h.Sum64())
+		})
+		assert.Zero(t, allocs, "%s", a)
+	}
+}
+
+func BenchmarkLookupParts(b *testing.B) {
+	qf := NewWithConfig(Config{ExpectedEntries: uint64(len(testStrings))})
+	tenant, typ := []byte("tenant42"), []byte("document")
+	names := make([][]byte, len(testStrings))
+	for i, s := range testStrings {
+		names[i] = []byte(s)
+		qf.InsertParts(tenant, typ, names[i])
+	}
+	b.Run("parts", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			qf.LookupParts(tenant, typ, names[n%len(names)])
+		}
+	})
+	b.Run("concatenated", func(b *testing.B) {
+		// the temporary key built for each probe without LookupParts
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			name := names[n%len(names)]
+			key := make([]byte, 0, len(tenant)+len(typ)+len(name)+3)
+			key = appendPart(key, tenant)
+			key = appendPart(key, typ)
+			key = appendPart(key, name)
+			qf.Lookup(key)
+		}
+	})
+}
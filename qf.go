@@ -9,28 +9,48 @@
 package qf
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"unsafe"
 )
 
 // MaxLoadingFactor specifies the boundary at which we will double
 // the quotient filter hash table and also is used to initially size
-// the table.
+// the table, unless Config.LoadFactor says otherwise.
 const MaxLoadingFactor = 0.65
 
+// ErrFilterFull is returned when inserting a new key into a quotient
+// filter configured with a fixed capacity which is already full
+var ErrFilterFull = errors.New("quotient filter is full")
+
 // Filter is a quotient filter representation
 type Filter struct {
 	entries      uint64
 	size         uint64
 	filter       Vector
 	storage      Vector
+	rs           *rankSelect
 	rBits, qBits uint
 	rMask        uint64
 	maxEntries   uint64
 	config       Config
-	hashfn       HashFn
+	hasher       keyHash
 	allocfn      VectorAllocateFn
+	// expiry is set when entries expire, see Config.TTL
+	expiry *expiry
+	// arena holds the values of a quotient filter with a value arena,
+	// garbage counts the bytes of it which were overwritten
+	arena   []byte
+	garbage uint64
+	// columns holds the values of each of Config.Columns
+	columns []Vector
+	// fpBits is the width of the fingerprints of new entries, when
+	// fingerprints are stored in place of remainders, see
+	// Config.FingerprintBits
+	fpBits uint
 }
 
 // Len returns the number of entries in the quotient filter
@@ -38,13 +58,53 @@ func (qf *Filter) Len() uint64 {
 	return qf.entries
 }
 
+// Capacity returns the number of entries the quotient filter can hold
+// before it must double (or, with a fixed capacity, before it is full)
+func (qf *Filter) Capacity() uint64 {
+	return qf.maxEntries
+}
+
+// view returns a slotView reading the quotient filter
+func (qf *Filter) view() slotView {
+	v := slotView{filter: qf.filter.Get, size: qf.size}
+	if qf.storage != nil {
+		v.storage = qf.storage.Get
+	}
+	if qf.rs != nil {
+		v.rs = &qf.rs.rsReader
+	}
+	return v
+}
+
+// filterVectors returns the vectors making up the filter (but not the
+// external storage) in their serialization order
+func (qf *Filter) filterVectors() []Vector {
+	if qf.rs != nil {
+		return append([]Vector{qf.filter}, qf.rs.vectors()...)
+	}
+	return []Vector{qf.filter}
+}
+
 // DebugDump prints a textual representation of the quotient filter
 // to stdout
 func (qf *Filter) DebugDump(full bool) {
 	fmt.Printf("\nquotient filter is %d large (%d q bits) with %d entries (loaded %0.3f)\n",
 		qf.size, qf.qBits, qf.entries, float64(qf.entries)/float64(qf.size))
 
-	if full {
+	if full && qf.rs != nil {
+		fmt.Printf("  quotient  slots  remainder->\n")
+		qf.rs.eachRun(qf.size, func(q, start, end uint64) {
+			fmt.Printf("%10d  %d-%d", q, start, end)
+			for i := start; i <= end; i++ {
+				v := uint64(0)
+				if qf.storage != nil {
+					v = qf.storage.Get(i)
+				}
+				fmt.Printf(" %x (%d)", qf.filter.Get(i), v)
+			}
+			fmt.Printf("\n")
+		})
+	} else if full {
 		fmt.Printf("  bucket  O C S remainder->\n")
 		skipped := 0
 		for i := uint64(0); i < uint64(qf.size); i++ {
@@ -82,6 +142,10 @@ func (qf *Filter) DebugDump(full bool) {
 
 // iterate the qf and call the callback once for each hash value present
 func (qf *Filter) eachHashValue(cb func(uint64, uint64)) {
+	if qf.rs != nil {
+		qf.rs.eachHashValue(qf.size, qf.rBits, cb)
+		return
+	}
 	// a stack of q values
 	stack := []uint64{}
 	// let's start from an unshifted value
@@ -109,6 +173,45 @@ func (qf *Filter) eachHashValue(cb func(uint64, uint64)) {
 	}
 }
 
+// iterate the slots of a classic quotient filter and call the
+// callback once for each run
+func eachRun(size uint64, read readFn, cb func(q, start, end uint64)) {
+	// a queue of quotients for which runs are pending
+	queue := []uint64{}
+	// let's start from an unshifted value
+	start := uint64(0)
+	for slotData(read(start)).shifted() {
+		right(&start, size)
+	}
+	inRun := false
+	var runStart, last uint64
+	i := start
+	// visit start a second time to close a run which ends at the
+	// end of the cycle
+	for n := uint64(0); n <= size; n++ {
+		sd := slotData(read(i))
+		if !sd.continuation() && len(queue) > 0 {
+			if inRun {
+				cb(queue[0], runStart, last)
+				inRun = false
+			}
+			queue = queue[1:]
+		}
+		if n == size {
+			break
+		}
+		if sd.occupied() {
+			queue = append(queue, i)
+		}
+		if !sd.empty() && !inRun && len(queue) > 0 {
+			inRun = true
+			runStart = i
+		}
+		last = i
+		right(&i, size)
+	}
+}
+
 // New allocates a new quotient filter with default initial
 // sizing and no external storage configured.
 func New() *Filter {
@@ -118,24 +221,84 @@ func New() *Filter {
 // NewWithConfig allocates a new quotient filter based on the
 // supplied configuration
 func NewWithConfig(c Config) *Filter {
+	if c.LoadFactor < 0 || c.LoadFactor >= 1 {
+		panic(fmt.Sprintf("load factor %f is out of range, must be between 0 and 1", c.LoadFactor))
+	}
+	if !c.IntegerMixer.valid() {
+		panic(c.IntegerMixer.String())
+	}
 	var qf Filter
-	if c.BitPacked {
+	switch {
+	case c.VectorAllocate != nil:
+		qf.allocfn = c.VectorAllocate
+	case c.BitPacked:
 		qf.allocfn = BitPackedVectorAllocate
-	} else {
+	default:
 		qf.allocfn = UnpackedVectorAllocate
 	}
-	if c.HashFn == nil {
-		c.HashFn = murmurhash64
+	switch {
+	case c.KeyedHash || c.HashAlgorithm == HashSipHash:
+		if c.HashFn != nil || (c.HashAlgorithm != HashMurmur && c.HashAlgorithm != HashSipHash) {
+			panic("a keyed hash can't be combined with another hash function")
+		}
+		c.KeyedHash, c.HashAlgorithm = true, HashSipHash
+		if c.HashKey == ([16]byte{}) {
+			if _, err := rand.Read(c.HashKey[:]); err != nil {
+				panic(fmt.Sprintf("can't generate hash key: %s", err))
+			}
+		}
+		qf.hasher = newKeyHash(HashSipHash, c.HashKey, nil)
+	case c.HashFn != nil:
+		c.HashAlgorithm = HashCustom
+		qf.hasher = newKeyHash(HashCustom, c.HashKey, c.HashFn)
+	default:
+		if c.HashAlgorithm >= HashSipHash {
+			panic(fmt.Sprintf("%s hash requires Config.HashFn", c.HashAlgorithm))
+		}
+		qf.hasher = newKeyHash(c.HashAlgorithm, c.HashKey, nil)
 	}
-	qf.hashfn = c.HashFn
+
+	if c.ValueArena {
+		if c.BitsOfStoragePerEntry != 0 {
+			panic("a value arena sizes the storage itself, BitsOfStoragePerEntry must be zero")
+		}
+		c.BitsOfStoragePerEntry = arenaRefBits
+	}
+	if c.Multiset {
+		switch {
+		case c.BitsOfStoragePerEntry == 0:
+			panic("a multiset requires BitsOfStoragePerEntry to hold its values")
+		case c.ValueArena || c.TTL != 0 || len(c.Columns) != 0:
+			panic("a multiset can't be combined with ValueArena, TTL or Columns")
+		}
+	}
+	e, err := newExpiry(c.TTL, c.EpochBits, c.BitsOfStoragePerEntry, c.Clock)
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := validColumns(c.Columns); err != nil {
+		panic(err.Error())
+	}
+	if c.FingerprintBits != 0 {
+		switch {
+		case c.FingerprintBits > maxFingerprintBits:
+			panic(fmt.Sprintf("%d fingerprint bits is out of range, must be between 1 and %d", c.FingerprintBits, maxFingerprintBits))
+		case c.Multiset || c.TTL != 0 || c.ValueArena || len(c.Columns) != 0:
+			panic("fingerprints can't be combined with Multiset, TTL, ValueArena or Columns")
+		}
+	}
+	c.Columns = append([]Column(nil), c.Columns...)
+	qf.expiry = e
+	qf.config = c
 
 	qbits := c.QBits()
+	if c.FingerprintBits != 0 {
+		qf.fpBits = fingerprintWidth(c.FingerprintBits-1, qbits)
+	}
 
 	qf.initForQuotientBits(uint(qbits))
 
-	qf.config = c
-
-	qf.allocStorage()
+	qf.allocStorage(qf.tableAlloc())
 
 	if qf.maxEntries > qf.size {
 		panic("internal inconsistency")
@@ -149,22 +312,58 @@ func (qf *Filter) BitsOfStoragePerEntry() uint {
 	return qf.config.BitsOfStoragePerEntry
 }
 
-func (qf *Filter) allocStorage() {
-	qf.filter = qf.allocfn(3+bitsPerWord-qf.qBits, qf.size)
-	if qf.config.BitsOfStoragePerEntry > 0 {
-		qf.storage = qf.allocfn(qf.config.BitsOfStoragePerEntry, qf.size)
+// allocStorage allocates the filter and storage vectors with alloc, and
+// the block metadata of the rank and select layout with qf.allocfn
+func (qf *Filter) allocStorage(alloc VectorAllocateFn) {
+	slots := qf.size
+	if qf.config.Layout == LayoutRankSelect {
+		qf.rs = newRankSelect(qf.allocfn, qf.size)
+		slots = rsBlocks(qf.size) * slotsPerBlock
+		qf.filter = alloc(qf.rBits, slots)
+		qf.rs.bind(qf.filter, qf.size)
+	} else {
+		qf.filter = alloc(3+qf.rBits, qf.size)
+	}
+	if bits := qf.config.BitsOfStoragePerEntry + qf.config.epochBits(); bits > 0 {
+		qf.storage = alloc(bits, slots)
+	}
+	qf.columns = make([]Vector, len(qf.config.Columns))
+	for i, col := range qf.config.Columns {
+		qf.columns[i] = alloc(col.Bits, slots)
+	}
+}
+
+// allocSegmented allocates a segmented vector where qf.allocfn's vectors
+// can be segmented, see segmented
+func (qf *Filter) allocSegmented(bits uint, size uint64) Vector {
+	if v := newSegmented(qf.allocfn, bits, size); v != nil {
+		return v
 	}
+	return qf.allocfn(bits, size)
+}
+
+// tableAlloc returns the allocator of the vectors of a new table, see
+// Config.SegmentedGrowth
+func (qf *Filter) tableAlloc() VectorAllocateFn {
+	if qf.config.SegmentedGrowth {
+		return qf.allocSegmented
+	}
+	return qf.allocfn
 }
 
 func (qf *Filter) initForQuotientBits(qBits uint) {
 	qf.qBits = qBits
 	qf.rBits, qf.rMask, qf.size = initForQuotientBits(qBits)
 	qf.rBits = (bitsPerWord - qBits)
+	if qf.fpBits != 0 {
+		// a marker bit follows the fingerprint
+		qf.rBits = qf.fpBits + 1
+	}
 	qf.rMask = 0
 	for i := uint(0); i < qf.rBits; i++ {
 		qf.rMask |= 1 << i
 	}
-	qf.maxEntries = uint64(math.Ceil(float64(qf.size) * MaxLoadingFactor))
+	qf.maxEntries = uint64(math.Ceil(float64(qf.size) * qf.config.loadFactor()))
 }
 
 func initForQuotientBits(qBits uint) (rBits uint, rMask, size uint64) {
@@ -245,15 +444,6 @@ func (qf *Filter) swap(slot uint64, sd slotData) slotData {
 	return slotData(qf.filter.Swap(slot, uint64(sd)))
 }
 
-func (qf *Filter) countEntries() (count uint64) {
-	for i := uint64(0); i < qf.size; i++ {
-		if !qf.read(i).empty() {
-			count++
-		}
-	}
-	return
-}
-
 // InsertStringWithValue stores the string key and an associated
 // integer value in the quotient filter it returns whether the
 // key was already present in the quotient filter.
@@ -267,34 +457,190 @@ func (qf *Filter) InsertString(s string) bool {
 	return qf.InsertStringWithValue(s, 0)
 }
 
+// TryInsertStringWithValue is like InsertStringWithValue, see
+// TryInsertWithValue
+func (qf *Filter) TryInsertStringWithValue(s string, value uint64) (bool, error) {
+	return qf.TryInsertWithValue(unsafe.Slice(unsafe.StringData(s), len(s)), value)
+}
+
+// TryInsertString is like InsertString, see TryInsertWithValue
+func (qf *Filter) TryInsertString(s string) (bool, error) {
+	return qf.TryInsertStringWithValue(s, 0)
+}
+
 // InsertRawHash inserts a pre-calculated raw hash value with associated
 // external data into the quotient filter.  The hash calculation algorithm
 // must be the very same used internally by the quotient filter, otherwise
 // lookups will fail.  This is a very low level insertion, use with care
 func (qf *Filter) InsertRawHash(hv uint64, value uint64) (update bool) {
-	if qf.maxEntries <= qf.entries {
+	update, err := qf.TryInsertRawHash(hv, value)
+	if err != nil {
+		panic(err)
+	}
+	return update
+}
+
+// errArenaInteger is the panic raised when an integer value is inserted
+// into a quotient filter with a value arena, whose storage holds
+// references into the arena
+const errArenaInteger = "quotient filter has a value arena, insert byte string values with InsertWithBytes"
+
+// TryInsertRawHash is like InsertRawHash, see TryInsertWithValue.  It
+// panics if the quotient filter has a value arena and value isn't zero,
+// see Config.ValueArena
+func (qf *Filter) TryInsertRawHash(hv uint64, value uint64) (update bool, err error) {
+	if qf.config.ValueArena {
+		if value != 0 {
+			panic(errArenaInteger)
+		}
+		return qf.tryInsertBytes(hv, nil)
+	}
+	return qf.tryInsertRawHash(hv, value)
+}
+
+// tryInsertRawHash is TryInsertRawHash, where value may be a reference
+// into the value arena
+func (qf *Filter) tryInsertRawHash(hv uint64, value uint64) (update bool, err error) {
+	if qf.config.Multiset {
+		return qf.tryInsertValue(hv, value)
+	}
+	if qf.expiry == nil {
+		return qf.tryInsertStored(hv, value)
+	}
+	now := qf.expiry.now()
+	if qf.expiry.due(now) {
+		qf.expire(now)
+	}
+	// an expired entry is replaced as though it were absent
+	found, stored := qf.lookupStored(hv)
+	if _, err = qf.tryInsertStored(hv, qf.expiry.stamp(value, now)); err != nil {
+		return false, err
+	}
+	return found && qf.expiry.live(stored, now), nil
+}
+
+// tryInsertStored is TryInsertRawHash, where value is the storage of
+// the entry including its epoch (if any)
+func (qf *Filter) tryInsertStored(hv uint64, value uint64) (update bool, err error) {
+	// note, reserve may double the filter and change the split of hv
+	err = qf.reserve(1)
+	dq, dr := qf.split(hv)
+	if err != nil {
+		// a full quotient filter can still update existing entries,
+		// though every value of a multiset is an entry of its own
+		v := qf.view()
+		if found, _ := v.lookup(dq, dr); !found || qf.config.Multiset {
+			return false, err
+		}
+	}
+	if qf.rs == nil {
+		update, _ = qf.insertByHash(dq, dr, value)
+		return update, nil
+	}
+	for {
+		update, _, err = qf.rsInsert(dq, dr, value)
+		if err != errOverflow {
+			return
+		}
+		// the overflow slots are exhausted, which is only likely at
+		// very high loading, make more room
+		if qf.config.FixedCapacity {
+			return false, ErrFilterFull
+		}
 		qf.double()
+		dq, dr = qf.split(hv)
 	}
-	dq := hv >> qf.rBits
-	dr := hv & qf.rMask
-	return qf.insertByHash(dq, dr, value)
 }
 
+// reserve ensures there is room for n more entries, doubling the
+// quotient filter as many times as required and permitted.  A batch may
+// hold more keys than a single doubling makes room for, and inserting
+// it in hash order into a table which is still too small piles the
+// keys up into one long cluster which is shifted on every insertion
+func (qf *Filter) reserve(n uint64) error {
+	for qf.entries+n > qf.maxEntries {
+		if qf.config.FixedCapacity {
+			return ErrFilterFull
+		}
+		qf.double()
+	}
+	return nil
+}
+
+// testHookDoubling, when set, is called as double makes progress and
+// just before the old table is dropped
+var testHookDoubling func()
+
+// double grows the quotient filter to twice its size, dropping any
+// expired entries.  Each remainder gives up its top bit to the quotient,
+// so the full hash of every entry is kept and the false positive rate
+// doesn't change as the quotient filter grows.  Fingerprints are
+// expanded instead, see expand
 func (qf *Filter) double() {
+	if qf.fpBits != 0 {
+		qf.expand()
+		return
+	}
+	var now uint64
+	if qf.expiry != nil {
+		now = qf.expiry.now()
+	}
+	qf.rebuild(qf.qBits+1, now, nil)
+}
+
+// rebuild copies the quotient filter into a table of qBits quotient
+// bits, dropping the entries which have expired by epoch now (if
+// entries expire) and those in slots for which drop (if set) returns
+// true.  With Config.SegmentedGrowth the new table is segmented,
+// allocated as it is filled, and the old table released as it is read,
+// so peak memory stays close to the size of the new table
+func (qf *Filter) rebuild(qBits uint, now uint64, drop func(slot uint64) bool) {
 	// start with a shallow coppy
 	cpy := *qf
 	cpy.entries = 0
-	cpy.initForQuotientBits(cpy.qBits + 1)
-	cpy.allocStorage()
+	cpy.initForQuotientBits(qBits)
+	cpy.allocStorage(cpy.tableAlloc())
+	release := segmentReleaser(append([]Vector{qf.filter, qf.storage}, qf.columns...)...)
 	qf.eachHashValue(func(hv uint64, slot uint64) {
-		dq := hv >> cpy.rBits
-		dr := hv & cpy.rMask
 		var v uint64
 		if qf.storage != nil {
 			v = qf.storage.Get(slot)
 		}
-		cpy.insertByHash(dq, dr, v)
+		if (qf.expiry != nil && !qf.expiry.live(v, now)) || (drop != nil && drop(slot)) {
+			if qf.config.ValueArena {
+				if qf.expiry != nil {
+					v = qf.expiry.value(v)
+				}
+				_, n := arenaSpan(v)
+				cpy.garbage += n
+			}
+			release(slot)
+			return
+		}
+		dq := hv >> cpy.rBits
+		dr := hv & cpy.rMask
+		var to uint64
+		if cpy.rs == nil {
+			_, to = cpy.insertByHash(dq, dr, v)
+		} else {
+			var err error
+			if _, to, err = cpy.rsInsert(dq, dr, v); err != nil {
+				panic(fmt.Sprintf("internal inconsistency: %s while rebuilding", err))
+			}
+		}
+		for i, col := range qf.columns {
+			cpy.columns[i].Set(to, col.Get(slot))
+		}
+		if release(slot) && testHookDoubling != nil {
+			testHookDoubling()
+		}
 	})
+	if testHookDoubling != nil {
+		testHookDoubling()
+	}
+	if qf.expiry != nil {
+		qf.expiry.swept(now)
+	}
 
 	// shallow copy back over self
 	*qf = cpy
@@ -302,12 +648,23 @@ func (qf *Filter) double() {
 
 // InsertWithValue stores the key (byte slice) and an integer value in
 // the quotient filter.  It returns whether a value already existed.
+// If the quotient filter has a fixed capacity and is full, it panics
+// with ErrFilterFull.  It panics if the quotient filter has a value
+// arena and value isn't zero, see InsertWithBytes
 func (qf *Filter) InsertWithValue(v []byte, value uint64) (update bool) {
-	if qf.maxEntries <= qf.entries {
-		qf.double()
+	update, err := qf.TryInsertWithValue(v, value)
+	if err != nil {
+		panic(err)
 	}
-	dq, dr := hash(qf.hashfn, v, qf.rBits, qf.rMask)
-	return qf.insertByHash(uint64(dq), uint64(dr), value)
+	return update
+}
+
+// TryInsertWithValue is like InsertWithValue but returns ErrFilterFull
+// rather than panicking when a quotient filter of fixed capacity is
+// full.  Updating the value of a key which is already present succeeds
+// even when the quotient filter is full
+func (qf *Filter) TryInsertWithValue(v []byte, value uint64) (update bool, err error) {
+	return qf.TryInsertRawHash(qf.hasher.sum(v), value)
 }
 
 // Insert stores the key (byte slice) in the quotient filter it
@@ -316,7 +673,52 @@ func (qf *Filter) Insert(v []byte) (update bool) {
 	return qf.InsertWithValue(v, 0)
 }
 
-func (qf *Filter) insertByHash(dq, dr, value uint64) bool {
+// TryInsert is like Insert, see TryInsertWithValue
+func (qf *Filter) TryInsert(v []byte) (update bool, err error) {
+	return qf.TryInsertWithValue(v, 0)
+}
+
+// InsertBatch stores every key in keys along with values[i] (values
+// may be nil when no external storage is needed) and reports for each
+// key whether it already existed.  The quotient filter is sized once up
+// front and keys are inserted in hash order, so that neighboring keys
+// share the cost of walking and shifting runs.  Like InsertWithValue,
+// it panics with ErrFilterFull if a quotient filter of fixed capacity
+// fills up
+func (qf *Filter) InsertBatch(keys [][]byte, values []uint64) (updated []bool) {
+	if values != nil && len(values) < len(keys) {
+		panic(fmt.Sprintf("batch of %d keys has only %d values", len(keys), len(values)))
+	}
+	if !qf.config.FixedCapacity {
+		qf.reserve(uint64(len(keys)))
+	}
+	type pending struct {
+		hv uint64
+		ix int
+	}
+	hashes := make([]pending, len(keys))
+	for i, key := range keys {
+		hashes[i] = pending{qf.hasher.sum(key), i}
+	}
+	// stable, so that repeated keys are applied in arrival order
+	sort.SliceStable(hashes, func(i, j int) bool {
+		return hashes[i].hv < hashes[j].hv
+	})
+	updated = make([]bool, len(keys))
+	for _, p := range hashes {
+		var value uint64
+		if values != nil {
+			value = values[p.ix]
+		}
+		updated[p.ix] = qf.InsertRawHash(p.hv, value)
+	}
+	return
+}
+
+// insertByHash inserts remainder dr with value into the run of quotient
+// dq, returning whether it updated an existing entry and the slot of
+// the entry
+func (qf *Filter) insertByHash(dq, dr, value uint64) (update bool, slot uint64) {
 	sd := qf.read(dq)
 
 	// case 1, the slot is empty
@@ -328,7 +730,8 @@ func (qf *Filter) insertByHash(dq, dr, value uint64) bool {
 		if qf.storage != nil {
 			qf.storage.Set(dq, value)
 		}
-		return false
+		qf.clearColumns(dq)
+		return false, dq
 	}
 
 	// if the occupied bit is set for this dq, then we are
@@ -347,11 +750,13 @@ func (qf *Filter) insertByHash(dq, dr, value uint64) bool {
 		runStart = findStart(dq, qf.size, qf.filter.Get)
 	}
 	// now let's find the spot within the run
-	slot := runStart
+	slot = runStart
+	found := false
 	if extendingRun {
 		sd = qf.read(slot)
 		for {
-			if sd.empty() || sd.r() >= dr {
+			if c := qf.compareEntry(slot, sd.r(), dr, value); c >= 0 {
+				found = c == 0
 				break
 			}
 			right(&slot, qf.size)
@@ -363,45 +768,120 @@ func (qf *Filter) insertByHash(dq, dr, value uint64) bool {
 	}
 
 	// case 2, the value is already in the filter
-	if dr == sd.r() {
+	if found {
 		// update value
 		if qf.storage != nil {
 			qf.storage.Set(slot, value)
 		}
-		return true
+		return true, slot
 	}
 	qf.entries++
 
 	// case 3: we have to insert into an existing run
-	// we are writing remainder <dr> into <slot>
-	shifted := (slot != uint64(dq))
-	continuation := slot != runStart
+	// we are writing remainder <dr> into <slot>, everything from
+	// <slot> up to the next empty slot moves right by one.  Occupied
+	// bits belong to the slot rather than the entry, so before moving
+	// anything give each entry the occupied bit of its destination,
+	// and mark it shifted
+	old := qf.read(slot)
+	empty := slot
+	for sd = old; !sd.empty(); {
+		next := empty
+		right(&next, qf.size)
+		nsd := qf.read(next)
+		fixed := sd
+		fixed.setOccupied(nsd.occupied())
+		fixed.setShifted(true)
+		if empty == slot && slot == runStart && extendingRun {
+			// the entry displaced from the start of the run now
+			// continues it
+			fixed.setContinuation(true)
+		}
+		if fixed != sd {
+			qf.write(empty, fixed)
+		}
+		empty, sd = next, nsd
+	}
+	if empty != slot {
+		shiftRightWrap(qf.filter, slot, empty, qf.size)
+		if qf.storage != nil {
+			shiftRightWrap(qf.storage, slot, empty, qf.size)
+		}
+		for _, col := range qf.columns {
+			shiftRightWrap(col, slot, empty, qf.size)
+		}
+	}
+
+	var new slotData
+	new.setShifted(slot != dq)
+	new.setContinuation(slot != runStart)
+	new.setOccupied(old.occupied())
+	new.setR(dr)
+	qf.write(slot, new)
+	if qf.storage != nil {
+		qf.storage.Set(slot, value)
+	}
+	qf.clearColumns(slot)
+	return false, slot
+}
+
+// deleteByHash removes the entry at slot s from the run of quotient
+// dq, moving the rest of its cluster down by one slot
+func (qf *Filter) deleteByHash(dq, s uint64) {
+	runStart := dq
+	if qf.read(dq).shifted() {
+		runStart = findStart(dq, qf.size, qf.filter.Get)
+	}
+	next := s
+	right(&next, qf.size)
+	if s == runStart && !qf.read(next).continuation() {
+		// the run is now empty
+		sd := qf.read(dq)
+		sd.setOccupied(false)
+		qf.write(dq, sd)
+	}
 
+	// every entry up to the next empty or unshifted slot moves down
+	// by one, and as on insertion each takes the occupied bit of its
+	// destination.  q tracks the quotient of the run being moved
+	q := dq
+	hole := s
 	for {
-		// dr -> the remainder to write here
-		if qf.storage != nil {
-			value = qf.storage.Swap(slot, value)
-		}
-		var new slotData
-		new.setShifted(shifted)
-		new.setContinuation(continuation)
-		old := qf.read(slot)
-		new.setOccupied(old.occupied())
-		new.setR(dr)
-		qf.write(slot, new)
-		if old.empty() {
+		from := hole
+		right(&from, qf.size)
+		sd := qf.read(from)
+		if sd.empty() || !sd.shifted() {
 			break
 		}
-		if ((slot == runStart) && extendingRun) || old.continuation() {
-			continuation = true
-		} else {
-			continuation = false
+		moved := sd
+		if !sd.continuation() {
+			for right(&q, qf.size); !qf.read(q).occupied(); right(&q, qf.size) {
+			}
+			moved.setShifted(hole != q)
+		} else if hole == s && s == runStart {
+			// the entry following the deleted start of the run now
+			// starts it
+			moved.setContinuation(false)
+			moved.setShifted(hole != dq)
+		}
+		moved.setOccupied(qf.read(hole).occupied())
+		qf.write(hole, moved)
+		if qf.storage != nil {
+			qf.storage.Set(hole, qf.storage.Get(from))
 		}
-		dr = old.r()
-		right(&slot, qf.size)
-		shifted = true
+		for _, col := range qf.columns {
+			col.Set(hole, col.Get(from))
+		}
+		hole = from
+	}
+	var empty slotData
+	empty.setOccupied(qf.read(hole).occupied())
+	qf.write(hole, empty)
+	if qf.storage != nil {
+		qf.storage.Set(hole, 0)
 	}
-	return false
+	qf.clearColumns(hole)
+	qf.entries--
 }
 
 func right(i *uint64, size uint64) {
@@ -461,7 +941,31 @@ func (qf *Filter) ContainsString(s string) bool {
 // Lookup searches for key and returns whether it
 // exists, and the value stored with it (if any)
 func (qf *Filter) Lookup(key []byte) (bool, uint64) {
-	dq, dr := hash(qf.hashfn, key, qf.rBits, qf.rMask)
+	return qf.LookupRawHash(qf.hasher.sum(key))
+}
+
+// LookupRawHash searches for a pre-calculated raw hash value, see
+// InsertRawHash, and returns whether it exists and the value stored
+// with it (if any)
+func (qf *Filter) LookupRawHash(hv uint64) (bool, uint64) {
+	if qf.expiry != nil {
+		return qf.expiry.visible(qf.lookupStored(hv))
+	}
+	return qf.lookupStored(hv)
+}
+
+// lookupStored is LookupRawHash, returning the storage of the entry
+// including its epoch (if any)
+func (qf *Filter) lookupStored(hv uint64) (bool, uint64) {
+	dq, dr := qf.split(hv)
+	if qf.fpBits != 0 {
+		v := qf.view()
+		return v.lookupFingerprint(dq, dr, qf.fpBits)
+	}
+	if qf.rs != nil {
+		v := qf.view()
+		return v.lookup(dq, dr)
+	}
 	var storageFn readFn
 	if qf.storage != nil {
 		storageFn = qf.storage.Get
@@ -470,7 +974,12 @@ func (qf *Filter) Lookup(key []byte) (bool, uint64) {
 }
 
 func lookupByHash(dq, dr, size uint64, read, storage readFn) (bool, uint64) {
-	sd := slotData(read(dq))
+	return lookupByHashFrom(slotData(read(dq)), dq, dr, size, read, storage)
+}
+
+// lookupByHashFrom is lookupByHash where the caller has already read
+// the slot data, sd, at the home bucket dq
+func lookupByHashFrom(sd slotData, dq, dr, size uint64, read, storage readFn) (bool, uint64) {
 	if !sd.occupied() {
 		return false, 0
 	}
@@ -505,8 +1014,8 @@ func (qf *Filter) LookupString(key string) (bool, uint64) {
 	return qf.Lookup(unsafe.Slice(unsafe.StringData(key), len(key)))
 }
 
-func hash(fn HashFn, v []byte, rBits uint, rMask uint64) (q, r uint64) {
-	hv := fn(v)
+func hash(h *keyHash, v []byte, rBits uint, rMask uint64) (q, r uint64) {
+	hv := h.sum(v)
 	dq := hv >> rBits
 	dr := hv & rMask
 	return uint64(dq), uint64(dr)
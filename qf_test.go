@@ -7,54 +7,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/bits"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"testing"
+	"testing/iotest"
 
 	murmur "github.com/aviddiviner/go-murmur"
 	"github.com/bits-and-blooms/bloom/v3"
 	"github.com/stretchr/testify/assert"
 )
 
-// testing specific consistency checking
-func (qf *Filter) checkConsistency() error {
-	if qf.countEntries() != qf.entries {
-		return fmt.Errorf("%d items added, only %d found", qf.entries, qf.countEntries())
-	}
-
-	// now let's ensure that for every set occupied bit there is a
-	// non-zero length run
-	usage := map[uint64]uint64{}
-
-	for i := uint64(0); i < qf.size; i++ {
-		md := qf.read(i)
-		if !md.occupied() {
-			continue
-		}
-		dq := i
-		runStart := findStart(dq, qf.size, qf.filter.Get)
-		// ok, for bucket dq we've got a run starting at runStart
-		for {
-			who, used := usage[runStart]
-			if used {
-				return fmt.Errorf("slot %d used by both dq %d and %d", runStart, dq, who)
-			}
-			usage[runStart] = dq
-			right(&runStart, qf.size)
-			md := qf.read(runStart)
-			if !md.continuation() {
-				break
-			}
-		}
-	}
-	if uint64(len(usage)) != qf.entries {
-		return fmt.Errorf("records show %d entries in qf, found %d via scanning",
-			qf.entries, len(usage))
-	}
-
-	return nil
-}
-
 var testStrings = []string{
 	" stores",
 	"!) can",
@@ -354,7 +318,7 @@ func TestDoubling(t *testing.T) {
 	qf := New()
 	for _, s := range testStrings {
 		qf.InsertString(s)
-		qf.checkConsistency()
+		assert.NoError(t, qf.Validate())
 		if !assert.True(t, qf.ContainsString(s), "%q missing after insertion", s) {
 			qf.DebugDump(true)
 			return
@@ -365,6 +329,163 @@ func TestDoubling(t *testing.T) {
 	}
 }
 
+// growth keeps the full hash of every entry, so it never introduces
+// false positives
+func TestDoublingKeepsHashes(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		r := rand.New(rand.NewSource(45)) //intentionally fixed seed
+		qf := NewWithConfig(Config{Layout: layout})
+		inserted := map[uint64]bool{}
+		var want []uint64
+		for i := 0; i < 5000; i++ {
+			hv := r.Uint64()
+			qf.InsertRawHash(hv, 0)
+			inserted[hv] = true
+			want = append(want, hv)
+		}
+		assert.Greater(t, qf.qBits, uint(10))
+		sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+		assert.Equal(t, want, sortedHashes(qf))
+		for i := 0; i < 100000; i++ {
+			hv := r.Uint64()
+			found, _ := qf.LookupRawHash(hv)
+			assert.Equal(t, inserted[hv], found)
+		}
+	}
+}
+
+// runs which reach the end of the table wrap around to the start
+func TestInsertWraps(t *testing.T) {
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8, ExpectedEntries: 35, FixedCapacity: true})
+	hv := func(q, r uint64) uint64 {
+		return q<<qf.rBits | r
+	}
+	var inserted []uint64
+	for _, q := range []uint64{qf.size - 2, qf.size - 1, 0, qf.size - 1, qf.size - 2, 1, qf.size - 1} {
+		for j := 0; j < 5; j++ {
+			// distinct remainders in no particular order
+			x := hv(q, uint64(len(inserted)*37%101+1))
+			qf.InsertRawHash(x, uint64(len(inserted)))
+			inserted = append(inserted, x)
+			if !assert.NoError(t, qf.Validate()) {
+				qf.DebugDump(true)
+				return
+			}
+		}
+	}
+	for i, x := range inserted {
+		view := qf.view()
+		found, v := view.lookup(x>>qf.rBits, x&qf.rMask)
+		assert.True(t, found, "%x missing", x)
+		assert.Equal(t, uint64(i), v)
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 16})
+	seq := NewWithConfig(Config{BitsOfStoragePerEntry: 16})
+	keys := make([][]byte, len(testStrings))
+	values := make([]uint64, len(testStrings))
+	for i, s := range testStrings {
+		keys[i] = []byte(s)
+		values[i] = uint64(i)
+	}
+
+	updated := qf.InsertBatch(keys, values)
+	assert.NoError(t, qf.Validate())
+	for i, k := range keys {
+		assert.Equal(t, seq.InsertWithValue(k, values[i]), updated[i], "%q update", k)
+	}
+	assert.Equal(t, seq.Len(), qf.Len())
+	for _, k := range keys {
+		found, v := qf.Lookup(k)
+		assert.True(t, found, "%q missing after batch insertion", k)
+		_, expected := seq.Lookup(k)
+		assert.Equal(t, expected, v, "%q value", k)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8})
+	for i := 0; i < 10; i++ {
+		qf.InsertStringWithValue(strconv.Itoa(i), uint64(i))
+	}
+	// many doublings' worth of room, made before anything is inserted
+	assert.NoError(t, qf.reserve(5000))
+	assert.GreaterOrEqual(t, qf.Capacity(), uint64(5010))
+	assert.Less(t, qf.Capacity()/2, uint64(5010))
+	assert.NoError(t, qf.Validate())
+	for i := 0; i < 10; i++ {
+		found, v := qf.LookupString(strconv.Itoa(i))
+		assert.True(t, found)
+		assert.Equal(t, uint64(i), v)
+	}
+
+	fixed := NewWithConfig(Config{ExpectedEntries: 100, FixedCapacity: true})
+	size := fixed.size
+	assert.ErrorIs(t, fixed.reserve(5000), ErrFilterFull)
+	assert.Equal(t, size, fixed.size)
+}
+
+func TestFixedCapacity(t *testing.T) {
+	qf := NewWithConfig(Config{
+		ExpectedEntries:       100,
+		LoadFactor:            0.9,
+		FixedCapacity:         true,
+		BitsOfStoragePerEntry: 8,
+	})
+	size := qf.size
+	assert.Equal(t, uint64(128), size)
+	assert.Equal(t, uint64(116), qf.Capacity())
+
+	var inserted []string
+	var err error
+	for i := 0; err == nil; i++ {
+		s := strconv.Itoa(i)
+		if _, err = qf.TryInsertStringWithValue(s, 1); err == nil {
+			inserted = append(inserted, s)
+		}
+	}
+	assert.ErrorIs(t, err, ErrFilterFull)
+	assert.Equal(t, size, qf.size)
+	assert.Equal(t, qf.Capacity(), qf.Len())
+	assert.NoError(t, qf.Validate())
+
+	// updates to existing keys succeed when full
+	update, err := qf.TryInsertStringWithValue(inserted[0], 2)
+	assert.NoError(t, err)
+	assert.True(t, update)
+	_, v := qf.LookupString(inserted[0])
+	assert.Equal(t, uint64(2), v)
+
+	assert.PanicsWithValue(t, ErrFilterFull, func() {
+		qf.InsertString("one too many")
+	})
+	for _, s := range inserted {
+		assert.True(t, qf.ContainsString(s), "%q missing", s)
+	}
+
+	// the capacity survives serialization
+	var buf bytes.Buffer
+	_, err = qf.WriteTo(&buf)
+	assert.NoError(t, err)
+	var cpy Filter
+	_, err = cpy.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, qf.Capacity(), cpy.Capacity())
+	_, err = cpy.TryInsertString("one too many")
+	assert.ErrorIs(t, err, ErrFilterFull)
+	assert.Equal(t, size, cpy.size)
+
+	name, err := writeQFToTempFile(qf)
+	assert.NoError(t, err)
+	defer os.Remove(name)
+	h, err := ReadHeaderFromPath(name)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, h.LoadFactor)
+	assert.True(t, h.FixedCapacity)
+}
+
 func TestSerialization(t *testing.T) {
 	for _, packed := range []bool{false, true} {
 		qf := NewWithConfig(Config{
@@ -393,6 +514,27 @@ func TestSerialization(t *testing.T) {
 	}
 }
 
+// readers such as pipes and network connections return less than was
+// asked for, which isn't the end of the stream
+func TestSerializationShortReads(t *testing.T) {
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8})
+	for i, s := range testStrings {
+		qf.InsertStringWithValue(s, uint64(i))
+	}
+	var buf bytes.Buffer
+	wt, err := qf.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	var cpy Filter
+	rd, err := cpy.ReadFrom(iotest.HalfReader(&buf))
+	assert.NoError(t, err)
+	assert.Equal(t, wt, rd)
+	assert.NoError(t, cpy.Validate())
+	for _, s := range testStrings {
+		assert.True(t, cpy.ContainsString(s), "%q missing after construction", s)
+	}
+}
+
 func TestSerializationExternal(t *testing.T) {
 	qf := NewWithConfig(Config{
 		BitsOfStoragePerEntry: uint(64 - bits.LeadingZeros64(uint64(len(testStrings)))),
@@ -433,6 +575,8 @@ func TestSerializationExternal(t *testing.T) {
 func TestExpectedLoading(t *testing.T) {
 	c := Config{ExpectedEntries: 128}
 	assert.Equal(t, 50., c.ExpectedLoading())
+	c = Config{ExpectedEntries: 112, LoadFactor: 0.9}
+	assert.Equal(t, 87.5, c.ExpectedLoading())
 }
 
 func TestSizeEstimate(t *testing.T) {
@@ -446,11 +590,11 @@ func TestCheckHashes(t *testing.T) {
 	expected := map[uint64]struct{}{}
 	for _, s := range testStrings {
 		qf.InsertString(s)
-		assert.NoError(t, qf.checkConsistency())
+		assert.NoError(t, qf.Validate())
 		hv := murmur.MurmurHash64A([]byte(s), 0)
 		expected[hv] = struct{}{}
 	}
-	assert.NoError(t, qf.checkConsistency())
+	assert.NoError(t, qf.Validate())
 	got := map[uint64]struct{}{}
 	qf.eachHashValue(func(hv uint64, _ uint64) {
 		got[hv] = struct{}{}
@@ -469,6 +613,111 @@ func TestCheckHashes(t *testing.T) {
 	assert.Equal(t, len(expected), int(qf.Len()))
 }
 
+func TestStats(t *testing.T) {
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 4})
+	for _, s := range testStrings {
+		qf.InsertString(s)
+	}
+	st := qf.Stats()
+	assert.Equal(t, qf.Len(), st.Entries)
+	assert.Equal(t, qf.size, st.Size)
+	occupied := uint64(0)
+	for i := uint64(0); i < qf.size; i++ {
+		if qf.read(i).occupied() {
+			occupied++
+		}
+	}
+	assert.Equal(t, occupied, st.Runs)
+	inRuns := uint64(0)
+	for length, count := range st.RunLengthHistogram {
+		inRuns += uint64(length) * count
+	}
+	assert.Equal(t, st.Entries, inRuns)
+	assert.True(t, st.Clusters > 0 && st.Clusters <= st.Runs)
+	assert.True(t, st.P99ClusterLength <= st.MaxClusterLength)
+	assert.True(t, st.AverageProbeLength >= 1)
+	assert.True(t, st.FilterBytes > 0 && st.StorageBytes > 0)
+
+	name, err := writeQFToTempFile(qf)
+	defer os.Remove(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	qfr, err := OpenReadOnlyFromPath(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer qfr.Close()
+	assert.Equal(t, st, qfr.Stats())
+}
+
+func TestValidateDetectsCorruption(t *testing.T) {
+	build := func() *Filter {
+		qf := NewWithConfig(Config{ExpectedEntries: uint64(len(testStrings))})
+		for _, s := range testStrings {
+			qf.InsertString(s)
+		}
+		assert.NoError(t, qf.Validate())
+		return qf
+	}
+	// find the start of a run of at least two entries
+	qf := build()
+	runStart := uint64(0)
+	for ; runStart < qf.size-1; runStart++ {
+		if !qf.read(runStart).empty() && !qf.read(runStart).continuation() &&
+			qf.read(runStart+1).continuation() {
+			break
+		}
+	}
+	if !assert.True(t, runStart < qf.size-1, "no run of length two") {
+		return
+	}
+
+	var cerr *CorruptionError
+
+	// out of order remainders within a run
+	a, b := qf.read(runStart), qf.read(runStart+1)
+	ra := a.r()
+	a.setR(b.r())
+	b.setR(ra)
+	qf.write(runStart, a)
+	qf.write(runStart+1, b)
+	if assert.ErrorAs(t, qf.Validate(), &cerr) {
+		assert.Equal(t, runStart+1, cerr.Slot)
+	}
+
+	// a continuation bit in an unshifted slot
+	qf = build()
+	for i := uint64(0); i < qf.size; i++ {
+		sd := qf.read(i)
+		if !sd.empty() && !sd.shifted() {
+			sd.setContinuation(true)
+			qf.write(i, sd)
+			if assert.ErrorAs(t, qf.Validate(), &cerr) {
+				assert.Equal(t, i, cerr.Slot)
+			}
+			break
+		}
+	}
+
+	// an occupied quotient without a run
+	qf = build()
+	for i := uint64(0); i < qf.size; i++ {
+		sd := qf.read(i)
+		if sd.shifted() && !sd.occupied() {
+			sd.setOccupied(true)
+			qf.write(i, sd)
+			assert.ErrorAs(t, qf.Validate(), &cerr)
+			break
+		}
+	}
+
+	// the entry count does not match
+	qf = build()
+	qf.entries++
+	assert.Error(t, qf.Validate())
+}
+
 func TestExternalStorage(t *testing.T) {
 	qf := NewWithConfig(Config{
 		BitsOfStoragePerEntry: uint(64 - bits.LeadingZeros64(uint64(len(testStrings)))),
@@ -565,6 +814,164 @@ func TestReadOnlyFromDisk(t *testing.T) {
 	}
 }
 
+func TestRankSelect(t *testing.T) {
+	for _, packed := range []bool{false, true} {
+		qf := NewWithConfig(Config{
+			BitsOfStoragePerEntry: 32,
+			BitPacked:             packed,
+			Layout:                LayoutRankSelect,
+		})
+		classic := NewWithConfig(Config{BitsOfStoragePerEntry: 32})
+		for i, s := range testStrings {
+			qf.InsertStringWithValue(s, uint64(i))
+			classic.InsertStringWithValue(s, uint64(i))
+		}
+		if !assert.NoError(t, qf.Validate()) {
+			qf.DebugDump(true)
+			return
+		}
+		assert.Equal(t, classic.Len(), qf.Len())
+		for _, s := range testStrings {
+			found, val := qf.LookupString(s)
+			expFound, expVal := classic.LookupString(s)
+			assert.True(t, found, "%q missing", s)
+			assert.Equal(t, expVal, val, "%q has wrong value", s)
+			assert.Equal(t, expFound, found)
+		}
+		for i := 0; i < 1000; i++ {
+			s := fmt.Sprintf("absent %d", i)
+			assert.Equal(t, classic.ContainsString(s), qf.ContainsString(s), "%q", s)
+		}
+		keys := make([][]byte, len(testStrings))
+		for i, s := range testStrings {
+			keys[i] = []byte(s)
+		}
+		found := make([]bool, len(keys))
+		values := make([]uint64, len(keys))
+		qf.LookupBatch(keys, found, values)
+		for i, s := range testStrings {
+			_, val := classic.LookupString(s)
+			assert.True(t, found[i])
+			assert.Equal(t, val, values[i])
+		}
+		stats := qf.Stats()
+		assert.Equal(t, qf.Len(), stats.Entries)
+		assert.Equal(t, classic.Stats().Runs, stats.Runs)
+
+		// round trip through serialization, both in ram and on disk
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		cpy := NewWithConfig(Config{BitPacked: packed})
+		_, err = cpy.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.NoError(t, cpy.Validate())
+
+		name, err := writeQFToTempFile(qf)
+		defer os.Remove(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		ext, err := OpenReadOnlyFromPath(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ext.Close()
+		assert.NoError(t, ext.Validate())
+		assert.Equal(t, stats, ext.Stats())
+		ext.LookupBatch(keys, found, values)
+		for i, s := range testStrings {
+			_, val := classic.LookupString(s)
+			f, v := cpy.LookupString(s)
+			assert.True(t, f)
+			assert.Equal(t, val, v)
+			f, v = ext.LookupString(s)
+			assert.True(t, f)
+			assert.Equal(t, val, v)
+			assert.True(t, found[i])
+			assert.Equal(t, val, values[i])
+		}
+	}
+}
+
+// at high load runs pile up at the end of the table and spill into
+// the overflow blocks
+func TestRankSelectFull(t *testing.T) {
+	qf := NewWithConfig(Config{
+		ExpectedEntries:       1000,
+		LoadFactor:            0.95,
+		FixedCapacity:         true,
+		BitsOfStoragePerEntry: 32,
+		Layout:                LayoutRankSelect,
+	})
+	inserted := map[string]uint64{}
+	for i := 0; ; i++ {
+		s := fmt.Sprintf("key %d", i)
+		if _, err := qf.TryInsertStringWithValue(s, uint64(i)); err != nil {
+			assert.Equal(t, ErrFilterFull, err)
+			break
+		}
+		inserted[s] = uint64(i)
+	}
+	assert.NoError(t, qf.Validate())
+	assert.Equal(t, uint64(len(inserted)), qf.Len())
+	for s, v := range inserted {
+		found, val := qf.LookupString(s)
+		assert.True(t, found, "%q missing", s)
+		assert.Equal(t, v, val)
+	}
+}
+
+func TestShiftBitsRight(t *testing.T) {
+	v := UnpackedVectorAllocate(bitsPerWord, 3)
+	v.Set(0, 1<<63|1)
+	v.Set(1, 1<<63|1<<5)
+	shiftBitsRight(v, 63, 130)
+	// bit 63 moves to 64, 69 to 70 and 127 to 128, bit 63 itself is
+	// left for the caller to set
+	assert.Equal(t, uint64(1<<63|1), v.Get(0))
+	assert.Equal(t, uint64(1|1<<6), v.Get(1))
+	assert.Equal(t, uint64(1), v.Get(2))
+}
+
+func TestLookupBatch(t *testing.T) {
+	for _, packed := range []bool{false, true} {
+		qf := NewWithConfig(Config{
+			BitsOfStoragePerEntry: uint(64 - bits.LeadingZeros64(uint64(len(testStrings)))),
+			BitPacked:             packed,
+		})
+		keys := [][]byte{}
+		for i, s := range testStrings {
+			qf.InsertStringWithValue(s, uint64(i))
+			keys = append(keys, []byte(s), []byte(s+" missing"))
+		}
+		name, err := writeQFToTempFile(qf)
+		defer os.Remove(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		qfr, err := OpenReadOnlyFromPath(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer qfr.Close()
+
+		for _, r := range []Reader{qf, qfr} {
+			found := make([]bool, len(keys))
+			values := make([]uint64, len(keys))
+			r.LookupBatch(keys, found, values)
+			for i, k := range keys {
+				f, v := r.Lookup(k)
+				assert.Equal(t, f, found[i], "%q presence", k)
+				assert.Equal(t, v, values[i], "%q value", k)
+			}
+			contains := make([]bool, len(keys))
+			r.ContainsBatch(keys, contains)
+			assert.Equal(t, found, contains)
+		}
+	}
+}
+
 func BenchmarkBloomFilter(b *testing.B) {
 	bf := bloom.NewWithEstimates(uint(len(testStrings)), 0.0001)
 	for _, s := range testStrings {
@@ -607,6 +1014,113 @@ func BenchmarkUnpackedFilterLookup(b *testing.B) {
 	}
 }
 
+func BenchmarkUnpackedFilterLookupBatch(b *testing.B) {
+	c := Config{BitPacked: false, ExpectedEntries: uint64(len(testStrings))}
+	qf := NewWithConfig(c)
+	keys := make([][]byte, len(testStrings))
+	for i, s := range testStrings {
+		qf.InsertString(s)
+		keys[i] = []byte(s)
+	}
+	found := make([]bool, len(keys))
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n += len(keys) {
+		qf.ContainsBatch(keys, found)
+	}
+}
+
+// large filters are where batching pays off, the table no longer fits
+// in cache so every probe is a cache miss
+const largeBenchEntries = 1 << 22
+
+func newLargeQFForLookupBench() (*Filter, [][]byte) {
+	qf := NewWithConfig(Config{ExpectedEntries: largeBenchEntries})
+	keys := make([][]byte, 10000)
+	for n := 0; n < largeBenchEntries; n++ {
+		x := strconv.AppendInt(nil, int64(n*7919), 10)
+		qf.Insert(x)
+		if n < len(keys) {
+			keys[n] = x
+		}
+	}
+	return qf, keys
+}
+
+func BenchmarkUnpackedLargeFilterLookup(b *testing.B) {
+	qf, keys := newLargeQFForLookupBench()
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		qf.Contains(keys[n%len(keys)])
+	}
+}
+
+func BenchmarkUnpackedLargeFilterLookupBatch(b *testing.B) {
+	qf, keys := newLargeQFForLookupBench()
+	found := make([]bool, len(keys))
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n += len(keys) {
+		qf.ContainsBatch(keys, found)
+	}
+}
+
+// near the maximum load clusters grow long, which is where the rank
+// and select layout pays off
+func benchmarkHighLoadLookup(b *testing.B, layout Layout) {
+	qf := NewWithConfig(Config{
+		ExpectedEntries: 1 << 16,
+		LoadFactor:      0.95,
+		FixedCapacity:   true,
+		Layout:          layout,
+	})
+	var keys [][]byte
+	for n := 0; ; n++ {
+		x := strconv.AppendInt(nil, int64(n), 10)
+		if _, err := qf.TryInsert(x); err != nil {
+			break
+		}
+		keys = append(keys, x)
+	}
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		qf.Contains(keys[n%len(keys)])
+	}
+}
+
+// inserting into a nearly full filter shifts long clusters
+func BenchmarkPackedHighLoadInsert(b *testing.B) {
+	c := Config{
+		BitPacked:             true,
+		BitsOfStoragePerEntry: 20,
+		ExpectedEntries:       1 << 14,
+		LoadFactor:            0.95,
+		FixedCapacity:         true,
+	}
+	qf := NewWithConfig(c)
+	for n := 0; n < b.N; n++ {
+		if _, err := qf.TryInsertRawHash(uint64(n)*0x9e3779b97f4a7c15, 0); err != nil {
+			b.StopTimer()
+			qf = NewWithConfig(c)
+			b.StartTimer()
+		}
+	}
+}
+
+func BenchmarkClassicHighLoadLookup(b *testing.B) {
+	benchmarkHighLoadLookup(b, LayoutClassic)
+}
+
+func BenchmarkRankSelectHighLoadLookup(b *testing.B) {
+	benchmarkHighLoadLookup(b, LayoutRankSelect)
+}
+
 func createQFFilterOnDiskForBenchmarking(packed bool) (string, *Disk, error) {
 	c := Config{BitPacked: false, ExpectedEntries: uint64(len(testStrings))}
 	qf := NewWithConfig(c)
@@ -639,6 +1153,26 @@ func BenchmarkUnpackedDiskFilterLookup(b *testing.B) {
 	}
 }
 
+func BenchmarkUnpackedDiskFilterLookupBatch(b *testing.B) {
+	name, ext, err := createQFFilterOnDiskForBenchmarking(false)
+	defer func() {
+		os.Remove(name)
+	}()
+	if !assert.NoError(b, err) {
+		return
+	}
+	keys := make([][]byte, len(testStrings))
+	for i, s := range testStrings {
+		keys[i] = []byte(s)
+	}
+	found := make([]bool, len(keys))
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n += len(keys) {
+		ext.ContainsBatch(keys, found)
+	}
+}
+
 func BenchmarkPackedDiskFilterLookup(b *testing.B) {
 	name, ext, err := createQFFilterOnDiskForBenchmarking(true)
 	defer func() {
@@ -655,7 +1189,7 @@ func BenchmarkPackedDiskFilterLookup(b *testing.B) {
 }
 
 func BenchmarkUnpackedFilterLookupWithFNV(b *testing.B) {
-	c := Config{BitPacked: false, ExpectedEntries: uint64(len(testStrings)), HashFn: fnvhash}
+	c := Config{BitPacked: false, ExpectedEntries: uint64(len(testStrings)), HashFn: FNV64}
 	qf := NewWithConfig(c)
 
 	for _, s := range testStrings {
@@ -671,6 +1205,40 @@ func BenchmarkUnpackedFilterLookupWithFNV(b *testing.B) {
 	}
 }
 
+// BenchmarkUnpackedFilterLookupWithHash compares the hash algorithms
+// with BenchmarkUnpackedFilterLookupWithFNV
+func BenchmarkUnpackedFilterLookupWithHash(b *testing.B) {
+	for _, a := range []HashAlgorithm{HashMurmur, HashFNV, HashXXH64, HashXXH3, HashSipHash} {
+		b.Run(a.String(), func(b *testing.B) {
+			c := Config{ExpectedEntries: uint64(len(testStrings)), HashAlgorithm: a}
+			qf := NewWithConfig(c)
+			for _, s := range testStrings {
+				qf.InsertString(s)
+			}
+			numStrings := len(testStrings)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				qf.ContainsString(testStrings[n%numStrings])
+			}
+		})
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	for _, a := range []HashAlgorithm{HashMurmur, HashFNV, HashXXH64, HashXXH3, HashSipHash} {
+		h := newKeyHash(a, [16]byte{1}, nil)
+		for _, n := range []int{8, 32, 256, 4096} {
+			b.Run(fmt.Sprintf("%s/%d", a, n), func(b *testing.B) {
+				v := make([]byte, n)
+				b.SetBytes(int64(n))
+				for i := 0; i < b.N; i++ {
+					h.sum(v)
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkPackedFilterLookup(b *testing.B) {
 	c := Config{BitPacked: true, ExpectedEntries: uint64(len(testStrings))}
 	qf := NewWithConfig(c)
@@ -772,7 +1340,7 @@ func BenchmarkPackedDeserialize(b *testing.B) {
 }
 
 func BenchmarkPackedFilterLookupWithFNV(b *testing.B) {
-	c := Config{BitPacked: true, ExpectedEntries: uint64(len(testStrings)), HashFn: fnvhash}
+	c := Config{BitPacked: true, ExpectedEntries: uint64(len(testStrings)), HashFn: FNV64}
 	qf := NewWithConfig(c)
 
 	for _, s := range testStrings {
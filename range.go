@@ -0,0 +1,305 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultBlockBits  = 8
+	defaultFanoutBits = 4
+	defaultMaxProbes  = 1 << 16
+	// maxRangeLevels is the number of levels which can be told apart
+	// by the tag in the top bits of a prefix, see rangeHash
+	maxRangeLevels = 16
+)
+
+// RangeConfig controls the behavior of a RangeFilter.  Keys are grouped
+// into blocks of 1<<BlockBits consecutive keys, and the blocks into a
+// hierarchy of levels, each of which groups 1<<FanoutBits blocks of the
+// level below.  A key is inserted once for each level, though keys
+// sharing a block of a level share its entry.
+//
+// Every entry takes a slot, of 64-QBits bits of remainder and 3 bits
+// of metadata (2.125 in the rank and select layout), so a key costs up
+// to Levels slots.  By default there is a single level, and as in a
+// Memento filter each key takes one slot
+type RangeConfig struct {
+	// Config configures the quotient filter
+	Config
+	// BlockBits is the number of low bits of each key stored in order
+	// within the run of its block, between 4 and 16, 8 when zero.  The
+	// false positive rate of a probe grows with 1<<BlockBits, and the
+	// number of probes falls
+	BlockBits uint
+	// FanoutBits is the number of bits of prefix each level adds,
+	// between 1 and 8, 4 when zero.  A range query probes up to
+	// 1<<FanoutBits blocks at each end of each level
+	FanoutBits uint
+	// Levels is the number of levels, including that of the blocks,
+	// between 1 and 16, 1 when zero.  Each level costs up to a slot per
+	// key, and lets ranges 1<<FanoutBits times wider be probed
+	Levels int
+	// MaxProbes is the number of blocks of the top level which a range
+	// query probes, 1<<16 when zero.  A range covering more of them is
+	// reported present without probing, see FalsePositiveBound
+	MaxProbes uint64
+}
+
+// RangeReader answers whether any key within a range of integer keys
+// is contained in a quotient filter populated by a RangeFilter, along
+// the lines of the Memento filter.  Each key is hashed by the prefix
+// of its block, and its low bits, the memento, replace the low bits of
+// the hash.  The keys of a block so share a quotient and lie in order
+// within its run, and a range within a block is a scan of that run.
+// Wider ranges are decomposed into the blocks at either end and the
+// aligned groups of blocks between them, each of which is a point
+// lookup of the entry of its prefix at a higher level
+type RangeReader struct {
+	// view reads the quotient filter, and reports its remainder bits
+	view  func() (slotView, uint)
+	len   func() uint64
+	mixer IntegerMixer
+	key   uint64
+
+	blockBits, fanoutBits uint
+	levels                int
+	maxProbes             uint64
+}
+
+// newRangeReader validates c, and sets up the range reader for it
+func newRangeReader(c *RangeConfig) RangeReader {
+	rr := RangeReader{blockBits: c.BlockBits, fanoutBits: c.FanoutBits, levels: c.Levels, maxProbes: c.MaxProbes}
+	if rr.blockBits == 0 {
+		rr.blockBits = defaultBlockBits
+	}
+	if rr.fanoutBits == 0 {
+		rr.fanoutBits = defaultFanoutBits
+	}
+	if rr.blockBits < 4 || rr.blockBits > 16 || rr.fanoutBits > 8 {
+		panic(fmt.Sprintf("%d block bits and %d fanout bits are out of range", rr.blockBits, rr.fanoutBits))
+	}
+	if rr.levels == 0 {
+		rr.levels = 1
+	}
+	if rr.maxProbes == 0 {
+		rr.maxProbes = defaultMaxProbes
+	}
+	if rr.levels < 1 || rr.levels > maxRangeLevels {
+		panic(fmt.Sprintf("%d levels is out of range, must be between 1 and %d", rr.levels, maxRangeLevels))
+	}
+	return rr
+}
+
+// NewRangeReader wraps r, a Filter or Disk which must have been
+// populated through a RangeFilter with the same RangeConfig
+func NewRangeReader(r Reader, c RangeConfig) *RangeReader {
+	rr := newRangeReader(&c)
+	switch f := r.(type) {
+	case *Filter:
+		if f.fpBits != 0 {
+			panic("can't query ranges of fingerprints, which don't keep the order of keys")
+		}
+		rr.view = func() (slotView, uint) { return f.view(), f.rBits }
+		rr.mixer, rr.key = f.config.IntegerMixer, f.config.integerKey()
+	case *Disk:
+		rr.view = func() (slotView, uint) { return f.view(true), f.rBits }
+		rr.mixer, rr.key = f.mixer, f.mixKey
+	default:
+		panic(fmt.Sprintf("can't query ranges of a %T", r))
+	}
+	rr.len = r.Len
+	return &rr
+}
+
+// shift returns the number of low bits of a key below its prefix at
+// level j
+func (rr *RangeReader) shift(j int) uint {
+	return rr.blockBits + uint(j)*rr.fanoutBits
+}
+
+// rangeHash returns the hash of the block, at level j, of a key whose
+// prefix at that level is p.  The level is tagged in the top bits,
+// which are clear in a prefix of a key as blocks hold at least 16 keys,
+// so that the hash of every level and prefix is distinct
+func (rr *RangeReader) rangeHash(j int, p uint64) uint64 {
+	return rr.mixer.mix((p | uint64(j)<<(bitsPerWord-4)) ^ rr.key)
+}
+
+// hashes calls cb with the hash of k at every level
+func (rr *RangeReader) hashes(k uint64, cb func(hv uint64)) {
+	m := lowMask(uint64(rr.blockBits))
+	cb(rr.rangeHash(0, k>>rr.blockBits)&^m | k&m)
+	for j := 1; j < rr.levels; j++ {
+		sh := rr.shift(j)
+		var p uint64
+		if sh < bitsPerWord {
+			p = k >> sh
+		}
+		cb(rr.rangeHash(j, p))
+	}
+}
+
+// eachProbe calls probe with each of the lookups answering whether
+// any key is within [lo, hi], stopping at the first which returns true.
+// A probe of level zero asks whether block holds a key whose memento is
+// within [mlo, mhi], the others whether the block of their level is
+// occupied.  A probe of level -1 gives up, asking for a range too wide
+// for the top level to probe.  It returns whether any probe returned true
+func (rr *RangeReader) eachProbe(lo, hi uint64, probe func(level int, block, mlo, mhi uint64) bool) bool {
+	if lo > hi {
+		return false
+	}
+	m := lowMask(uint64(rr.blockBits))
+	bl, bh := lo>>rr.blockBits, hi>>rr.blockBits
+	if bl == bh {
+		return probe(0, bl, lo&m, hi&m)
+	}
+	// the blocks at either end which are partly within the range
+	if lo&m != 0 {
+		if probe(0, bl, lo&m, m) {
+			return true
+		}
+		bl++
+	}
+	if hi&m != m {
+		if probe(0, bh, 0, hi&m) {
+			return true
+		}
+		bh--
+	}
+
+	// then each level of blocks wholly within the range, where blocks
+	// at either end don't fill the block of the level above
+	f := lowMask(uint64(rr.fanoutBits))
+	each := func(j int, from, to uint64) bool {
+		for b := from; ; b++ {
+			if probe(j, b, 0, m) {
+				return true
+			}
+			if b == to {
+				return false
+			}
+		}
+	}
+	for j := 0; bl <= bh; j++ {
+		if j == rr.levels-1 {
+			if bh-bl >= rr.maxProbes {
+				return probe(-1, 0, 0, 0)
+			}
+			return each(j, bl, bh)
+		}
+		pl, ph := bl>>rr.fanoutBits, bh>>rr.fanoutBits
+		if pl == ph {
+			return each(j, bl, bh)
+		}
+		if bl&f != 0 {
+			if each(j, bl, pl<<rr.fanoutBits|f) {
+				return true
+			}
+			pl++
+		}
+		if bh&f != f {
+			if each(j, ph<<rr.fanoutBits, bh) {
+				return true
+			}
+			ph--
+		}
+		bl, bh = pl, ph
+	}
+	return false
+}
+
+// ContainsRange returns whether any key within [lo, hi] is contained
+// within the quotient filter.  Like Contains, it may report a false
+// positive, see FalsePositiveBound, but never a false negative
+func (rr *RangeReader) ContainsRange(lo, hi uint64) bool {
+	v, rBits := rr.view()
+	if rr.blockBits >= rBits {
+		panic(fmt.Sprintf("%d block bits don't fit in %d bit remainders", rr.blockBits, rBits))
+	}
+	rMask := lowMask(uint64(rBits))
+	return rr.eachProbe(lo, hi, func(j int, b, mlo, mhi uint64) bool {
+		switch {
+		case j < 0:
+			return true
+		case j == 0:
+			hv := rr.rangeHash(0, b) &^ lowMask(uint64(rr.blockBits))
+			dq, base := hv>>rBits, hv&rMask
+			return v.containsRemainderIn(dq, base|mlo, base|mhi)
+		}
+		hv := rr.rangeHash(j, b)
+		found, _ := v.lookup(hv>>rBits, hv&rMask)
+		return found
+	})
+}
+
+// Contains returns whether the key k is contained within the quotient
+// filter
+func (rr *RangeReader) Contains(k uint64) bool {
+	return rr.ContainsRange(k, k)
+}
+
+// FalsePositiveBound returns an upper bound on the probability that
+// ContainsRange(lo, hi) reports a key when there is none.  The quotient
+// filter keeps the full 64 bit hash of every entry, so a probe of a
+// block matches an unrelated entry with probability 1<<BlockBits in
+// 1<<64, and of a higher level 1 in 1<<64, and the bound is negligible
+// for any range which is probed.  It is 1 for a range which covers more
+// than MaxProbes blocks of the top level, and so is reported present
+// without probing, and it is Levels, FanoutBits and MaxProbes, rather
+// than the size of the hashes, which decide how wide those ranges are
+func (rr *RangeReader) FalsePositiveBound(lo, hi uint64) float64 {
+	n := float64(rr.len())
+	var bound float64
+	rr.eachProbe(lo, hi, func(j int, _, _, _ uint64) bool {
+		switch {
+		case j < 0:
+			bound = 1
+			return true
+		case j == 0:
+			bound += n * math.Ldexp(1, int(rr.blockBits)-bitsPerWord)
+		default:
+			bound += n * math.Ldexp(1, -bitsPerWord)
+		}
+		return false
+	})
+	return math.Min(bound, 1)
+}
+
+// RangeFilter is a quotient filter of integer keys which answers
+// whether any key within a range is present, see RangeReader
+type RangeFilter struct {
+	RangeReader
+	qf *Filter
+}
+
+// NewRangeFilter allocates a RangeFilter.  Each key takes up to
+// c.Levels entries, one with the default single level, and
+// c.ExpectedEntries counts entries rather than keys
+func NewRangeFilter(c RangeConfig) *RangeFilter {
+	qf := NewWithConfig(c.Config)
+	return &RangeFilter{RangeReader: *NewRangeReader(qf, c), qf: qf}
+}
+
+// Filter returns the underlying quotient filter, which may be written
+// to disk and read with NewRangeReader
+func (rf *RangeFilter) Filter() *Filter {
+	return rf.qf
+}
+
+// Insert stores the integer key k in the quotient filter, it returns
+// whether it already existed.  Like Filter.Insert, it panics with
+// ErrFilterFull if a quotient filter of fixed capacity is full
+func (rf *RangeFilter) Insert(k uint64) (update bool) {
+	first := true
+	rf.hashes(k, func(hv uint64) {
+		u := rf.qf.InsertRawHash(hv, 0)
+		if first {
+			update, first = u, false
+		}
+	})
+	return update
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// anyWithin returns whether any of the sorted keys is within [lo, hi]
+func anyWithin(keys []uint64, lo, hi uint64) bool {
+	i := sort.Search(len(keys), func(i int) bool { return keys[i] >= lo })
+	return i < len(keys) && keys[i] <= hi
+}
+
+func TestRangeFilter(t *testing.T) {
+	r := rand.New(rand.NewSource(46)) //intentionally fixed seed
+	// timestamps clustered in bursts, and keys spread over every bit
+	var keys []uint64
+	base := uint64(1700000000) * 1e9
+	for i := 0; i < 200; i++ {
+		burst := base + uint64(r.Int63n(1e12))
+		for j := 0; j < 10; j++ {
+			keys = append(keys, burst+uint64(r.Int63n(1e6)))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, r.Uint64())
+	}
+	keys = append(keys, 0, math.MaxUint64)
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, c := range []RangeConfig{
+		{},
+		{Config: Config{Layout: LayoutRankSelect}, BlockBits: 12, FanoutBits: 2},
+		{BlockBits: 4, FanoutBits: 8, Levels: 3},
+		{Levels: 15, MaxProbes: 16},
+	} {
+		rf := NewRangeFilter(c)
+		for _, k := range keys {
+			rf.Insert(k)
+		}
+		assert.True(t, rf.Insert(keys[5]))
+		assert.NoError(t, rf.Filter().Validate())
+
+		name, err := writeQFToTempFile(rf.Filter())
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+
+		check := func(rr *RangeReader, lo, hi uint64) {
+			want := anyWithin(keys, lo, hi)
+			got := rr.ContainsRange(lo, hi)
+			if want {
+				assert.True(t, got, "[%d, %d]", lo, hi)
+			} else if got {
+				// only where a false positive is possible at all
+				assert.Equal(t, 1.0, rr.FalsePositiveBound(lo, hi), "[%d, %d]", lo, hi)
+			}
+		}
+		for _, rr := range []*RangeReader{&rf.RangeReader, NewRangeReader(ext, c)} {
+			for _, k := range keys {
+				assert.True(t, rr.Contains(k))
+				check(rr, k+1, k+1)
+				check(rr, k-1000, k-1)
+			}
+			for i := 0; i < 500; i++ {
+				lo := r.Uint64()
+				var width uint64
+				switch i % 4 {
+				case 0:
+					width = uint64(r.Intn(100))
+				case 1:
+					width = uint64(r.Int63n(1e9))
+				case 2:
+					lo = base + uint64(r.Int63n(1e12))
+					width = uint64(r.Int63n(1e7))
+				default:
+					width = r.Uint64() >> uint(r.Intn(64))
+				}
+				hi := lo + width
+				if hi < lo {
+					hi = math.MaxUint64
+				}
+				check(rr, lo, hi)
+			}
+			check(rr, 0, math.MaxUint64)
+			check(rr, 1, math.MaxUint64-1)
+			assert.False(t, rr.ContainsRange(10, 9))
+		}
+		ext.Close()
+		os.Remove(name)
+	}
+
+	// by default each key takes one entry, and ranges covering more
+	// than MaxProbes blocks are reported present
+	rf := NewRangeFilter(RangeConfig{})
+	for _, k := range keys {
+		rf.Insert(k)
+	}
+	assert.Equal(t, uint64(len(keys)), rf.Filter().Len())
+	assert.Less(t, rf.FalsePositiveBound(1<<40, 1<<40+1<<24-1), 1e-6)
+	assert.Equal(t, 1.0, rf.FalsePositiveBound(1<<40, 1<<40+1<<25))
+	rf = NewRangeFilter(RangeConfig{MaxProbes: 1 << 20})
+	assert.Less(t, rf.FalsePositiveBound(1<<40, 1<<40+1<<25), 1e-6)
+
+	// with every level, the bound is tiny for any range
+	rf = NewRangeFilter(RangeConfig{Levels: 15})
+	for _, k := range keys {
+		rf.Insert(k)
+	}
+	assert.Less(t, rf.FalsePositiveBound(0, math.MaxUint64), 1e-9)
+	assert.Panics(t, func() { NewRangeFilter(RangeConfig{BlockBits: 2}) })
+	assert.Panics(t, func() { NewRangeFilter(RangeConfig{Levels: 17}) })
+}
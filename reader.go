@@ -11,7 +11,234 @@ type Reader interface {
 	ContainsString(string) bool
 	Lookup([]byte) (bool, uint64)
 	LookupString(string) (bool, uint64)
+	ContainsBatch([][]byte, []bool)
+	LookupBatch([][]byte, []bool, []uint64)
+	ContainsUint64(uint64) bool
+	LookupUint64(uint64) (bool, uint64)
+	ContainsParts(...[]byte) bool
+	LookupParts(...[]byte) (bool, uint64)
+	LookupRawHash(uint64) (bool, uint64)
+	NewHasher() Hasher
 }
 
 var _ Reader = (*Disk)(nil)
 var _ Reader = (*Filter)(nil)
+
+// slotView gathers the functions used to read the slots of a quotient
+// filter, whether in ram or on disk, so that the read paths are
+// implemented once for both
+type slotView struct {
+	filter, storage readFn
+	// rs is non-nil for the rank and select layout, in which case
+	// filter reads remainders
+	rs   *rsReader
+	size uint64
+}
+
+// lookup searches for remainder dr in the run of quotient dq
+func (v *slotView) lookup(dq, dr uint64) (bool, uint64) {
+	if v.rs == nil {
+		return lookupByHash(dq, dr, v.size, v.filter, v.storage)
+	}
+	found, slot := v.rs.lookup(dq, dr)
+	if found && v.storage != nil {
+		return true, v.storage(slot)
+	}
+	return found, 0
+}
+
+// lookupSlot searches for remainder dr in the run of quotient dq, and
+// returns the slot holding it
+func (v *slotView) lookupSlot(dq, dr uint64) (bool, uint64) {
+	if v.rs != nil {
+		return v.rs.lookup(dq, dr)
+	}
+	// read the slot in place of the storage
+	return lookupByHash(dq, dr, v.size, v.filter, func(slot uint64) uint64 { return slot })
+}
+
+// eachRun calls cb with the quotient and the first and last slot of
+// every run.  In the classic layout a run may wrap around the end of
+// the table, in which case end < start
+func (v *slotView) eachRun(cb func(q, start, end uint64)) {
+	if v.rs != nil {
+		v.rs.eachRun(v.size, cb)
+		return
+	}
+	eachRun(v.size, v.filter, cb)
+}
+
+// eachHashValue calls cb with every hash value and the slot it is
+// stored in, in order of quotient
+func (v *slotView) eachHashValue(rBits uint, cb func(hv, slot uint64)) {
+	if v.rs != nil {
+		v.rs.eachHashValue(v.size, rBits, cb)
+		return
+	}
+	v.eachRun(func(q, start, end uint64) {
+		for slot := start; ; right(&slot, v.size) {
+			cb(q<<rBits|slotData(v.filter(slot)).r(), slot)
+			if slot == end {
+				break
+			}
+		}
+	})
+}
+
+// hashCursor reads the hash values of a quotient filter one at a time
+// in ascending order.  Unlike eachHashValue, which starts from a cluster
+// in the classic layout, it starts from quotient zero even when a
+// cluster wraps around the end of the table
+type hashCursor struct {
+	v     *slotView
+	rBits uint
+	// the quotient of the current run, and the next and last slots of
+	// the run.  Slots of the classic layout are not wrapped, so may
+	// exceed the size of the table
+	q, slot, end uint64
+	inRun        bool
+	// the slot following the last run read, zero before any is read
+	next    uint64
+	started bool
+	// the slot of the last hash value returned
+	last uint64
+}
+
+func newHashCursor(v *slotView, rBits uint) *hashCursor {
+	return &hashCursor{v: v, rBits: rBits}
+}
+
+// nextHash returns the next hash value, or false once all are read
+func (c *hashCursor) nextHash() (uint64, bool) {
+	v := c.v
+	for !c.inRun {
+		q := c.q + 1
+		if !c.started {
+			q = 0
+		}
+		for q < v.size && !v.occupied(q) {
+			q++
+		}
+		if q >= v.size {
+			c.q = v.size
+			return 0, false
+		}
+		start := q
+		switch {
+		case c.started:
+			if c.next > start {
+				start = c.next
+			}
+		case v.rs != nil:
+			if q > 0 {
+				if end := v.rs.runsEnd(q - 1); end > start {
+					start = end
+				}
+			}
+		case slotData(v.filter(q)).shifted():
+			// pushed along by runs wrapping around the end of the table
+			if start = findStart(q, v.size, v.filter); start < q {
+				start += v.size
+			}
+		}
+		c.q, c.slot, c.end = q, start, v.runEnd(q, start)
+		c.inRun, c.started = true, true
+	}
+	hv := c.q<<c.rBits | v.remainder(c.slot)
+	c.last = c.slot
+	if v.rs == nil {
+		c.last %= v.size
+	}
+	if c.slot == c.end {
+		c.inRun = false
+		c.next = c.end + 1
+	} else {
+		c.slot++
+	}
+	return hv, true
+}
+
+// value returns the value stored with the last hash value returned, if
+// any
+func (c *hashCursor) value() uint64 {
+	if c.v.storage == nil {
+		return 0
+	}
+	return c.v.storage(c.last)
+}
+
+// occupied reports whether quotient q has a run
+func (v *slotView) occupied(q uint64) bool {
+	if v.rs != nil {
+		return v.rs.isOccupied(q)
+	}
+	return slotData(v.filter(q)).occupied()
+}
+
+// remainder returns the remainder stored in slot, which in the classic
+// layout may be past the end of the table
+func (v *slotView) remainder(slot uint64) uint64 {
+	if v.rs != nil {
+		return v.filter(slot)
+	}
+	return slotData(v.filter(slot % v.size)).r()
+}
+
+// runEnd returns the last slot of the run of quotient q, which starts
+// at start.  In the classic layout it may be past the end of the table
+func (v *slotView) runEnd(q, start uint64) uint64 {
+	if v.rs != nil {
+		return v.rs.runEndFrom(q, start)
+	}
+	end := start
+	for slotData(v.filter((end + 1) % v.size)).continuation() {
+		end++
+	}
+	return end
+}
+
+// containsRemainderIn reports whether the run of quotient dq holds a
+// remainder within [rlo, rhi]
+func (v *slotView) containsRemainderIn(dq, rlo, rhi uint64) bool {
+	if v.rs != nil {
+		r := v.rs
+		if !r.isOccupied(dq) {
+			return false
+		}
+		// runs are sorted, walk backwards from the end of the run
+		for slot := r.runsEnd(dq) - 1; ; slot-- {
+			rem := r.remainders(slot)
+			if rem < rlo {
+				return false
+			}
+			if rem <= rhi {
+				return true
+			}
+			if slot == dq || r.isRunEnd(slot-1) {
+				return false
+			}
+		}
+	}
+	sd := slotData(v.filter(dq))
+	if !sd.occupied() {
+		return false
+	}
+	slot := dq
+	if sd.shifted() {
+		slot = findStart(dq, v.size, v.filter)
+		sd = slotData(v.filter(slot))
+	}
+	for {
+		if sd.r() > rhi {
+			return false
+		}
+		if sd.r() >= rlo {
+			return true
+		}
+		right(&slot, v.size)
+		sd = slotData(v.filter(slot))
+		if !sd.continuation() {
+			return false
+		}
+	}
+}
@@ -0,0 +1,482 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Layout selects how slots and their metadata are arranged in a
+// quotient filter
+type Layout uint64
+
+const (
+	// LayoutClassic stores three bits of metadata (occupied,
+	// continuation and shifted) alongside each remainder.  Finding a
+	// run requires scanning slot by slot to the start of its cluster
+	LayoutClassic Layout = iota
+	// LayoutRankSelect groups slots into blocks of 64 with occupieds
+	// and runends bitvectors and a per block offset, as in the rank
+	// and select quotient filter (RSQF).  Runs are located with
+	// popcount based rank and select, so lookups stay fast as clusters
+	// grow long at high loading
+	LayoutRankSelect
+)
+
+func (l Layout) String() string {
+	switch l {
+	case LayoutClassic:
+		return "classic"
+	case LayoutRankSelect:
+		return "rank-select"
+	}
+	return fmt.Sprintf("unknown layout %d", uint64(l))
+}
+
+// slotsPerBlock is the number of slots covered by each word of the
+// occupieds and runends bitvectors of the rank and select layout
+const slotsPerBlock = bitsPerWord
+
+// errOverflow is returned when an insertion into a rank and select
+// quotient filter would shift entries past its last overflow slot
+var errOverflow = errors.New("rank and select quotient filter overflowed")
+
+// rsBlocks reports the number of blocks required for a rank and select
+// quotient filter with size quotients.  A rank and select quotient
+// filter does not wrap around, instead runs which spill past the last
+// quotient are held in overflow blocks
+func rsBlocks(size uint64) uint64 {
+	overflow := uint64(10 * math.Sqrt(float64(size)))
+	return (size+overflow+slotsPerBlock-1)/slotsPerBlock + 1
+}
+
+// rankSelect holds the block metadata of a rank and select quotient
+// filter.  The remainders are kept in Filter.filter
+type rankSelect struct {
+	// one word per block
+	occupiedVec, runendVec, offsetVec Vector
+	rsReader
+}
+
+func newRankSelect(allocfn VectorAllocateFn, size uint64) *rankSelect {
+	blocks := rsBlocks(size)
+	return &rankSelect{
+		occupiedVec: allocfn(bitsPerWord, blocks),
+		runendVec:   allocfn(bitsPerWord, blocks),
+		offsetVec:   allocfn(bitsPerWord, blocks),
+	}
+}
+
+// bind (re)initializes the readers of rs, which must be called whenever
+// the vectors are replaced
+func (rs *rankSelect) bind(remainders Vector, size uint64) {
+	rs.rsReader = rsReader{
+		occupieds:  rs.occupiedVec.Get,
+		runends:    rs.runendVec.Get,
+		offsets:    rs.offsetVec.Get,
+		remainders: remainders.Get,
+		blocks:     rsBlocks(size),
+	}
+}
+
+// vectors returns the metadata vectors in their serialization order
+func (rs *rankSelect) vectors() []Vector {
+	return []Vector{rs.occupiedVec, rs.runendVec, rs.offsetVec}
+}
+
+// rsReader reads a rank and select quotient filter, whether in ram or
+// on disk
+type rsReader struct {
+	occupieds, runends, offsets, remainders readFn
+	blocks                                  uint64
+}
+
+func (r *rsReader) slots() uint64 {
+	return r.blocks * slotsPerBlock
+}
+
+// lowMask returns a mask of the low n bits
+func lowMask(n uint64) uint64 {
+	if n >= bitsPerWord {
+		return math.MaxUint64
+	}
+	return (1 << n) - 1
+}
+
+// select64 returns the position of the k'th (zero based) set bit in w
+func select64(w uint64, k int) uint64 {
+	for ; k > 0; k-- {
+		w &= w - 1
+	}
+	return uint64(bits.TrailingZeros64(w))
+}
+
+func (r *rsReader) isOccupied(q uint64) bool {
+	return r.occupieds(q/slotsPerBlock)&(1<<(q%slotsPerBlock)) != 0
+}
+
+func (r *rsReader) isRunEnd(slot uint64) bool {
+	return r.runends(slot/slotsPerBlock)&(1<<(slot%slotsPerBlock)) != 0
+}
+
+// runsEnd returns the first slot following the runs of every quotient
+// less than or equal to x.  When none of those runs extend into the
+// block containing x, the start of that block is returned
+func (r *rsReader) runsEnd(x uint64) uint64 {
+	b := x / slotsPerBlock
+	base := b * slotsPerBlock
+	pos := base + r.offsets(b)
+	// the number of runs in the block up to and including x
+	d := bits.OnesCount64(r.occupieds(b) & lowMask(x%slotsPerBlock+1))
+	if d == 0 {
+		return pos
+	}
+	// every runend from pos onwards belongs to a quotient in this
+	// block or later, we want the d'th of them
+	w := pos / slotsPerBlock
+	word := r.runends(w) &^ lowMask(pos%slotsPerBlock)
+	for {
+		if c := bits.OnesCount64(word); c >= d {
+			return w*slotsPerBlock + select64(word, d-1) + 1
+		}
+		d -= bits.OnesCount64(word)
+		w++
+		if w >= r.blocks {
+			panic(fmt.Sprintf("corrupt rank and select quotient filter, run for quotient %d has no end", x))
+		}
+		word = r.runends(w)
+	}
+}
+
+// offset computes the offset of block b, the number of slots at its
+// start which are used by the runs of quotients in earlier blocks
+func (r *rsReader) offset(b uint64) uint64 {
+	if b == 0 {
+		return 0
+	}
+	base := b * slotsPerBlock
+	if end := r.runsEnd(base - 1); end > base {
+		return end - base
+	}
+	return 0
+}
+
+// lookup searches for remainder dr in the run of quotient dq
+func (r *rsReader) lookup(dq, dr uint64) (found bool, slot uint64) {
+	if !r.isOccupied(dq) {
+		return false, 0
+	}
+	// runs are sorted, walk backwards from the end of the run
+	for slot = r.runsEnd(dq) - 1; ; slot-- {
+		rem := r.remainders(slot)
+		if rem == dr {
+			return true, slot
+		}
+		if rem < dr || slot == dq || r.isRunEnd(slot-1) {
+			return false, 0
+		}
+	}
+}
+
+// eachRun calls cb with the quotient and the first and last slot of
+// every run, in order
+func (r *rsReader) eachRun(size uint64, cb func(q, start, end uint64)) {
+	next := uint64(0)
+	for b := uint64(0); b*slotsPerBlock < size; b++ {
+		occ := r.occupieds(b)
+		for occ != 0 {
+			q := b*slotsPerBlock + uint64(bits.TrailingZeros64(occ))
+			occ &= occ - 1
+			start := q
+			if next > start {
+				start = next
+			}
+			end := r.runEndFrom(q, start)
+			cb(q, start, end)
+			next = end + 1
+		}
+	}
+}
+
+// runEndFrom returns the first runend at or after start, the end of the
+// run of quotient q which starts there
+func (r *rsReader) runEndFrom(q, start uint64) uint64 {
+	w := start / slotsPerBlock
+	word := r.runends(w) &^ lowMask(start%slotsPerBlock)
+	for word == 0 {
+		w++
+		if w >= r.blocks {
+			panic(fmt.Sprintf("corrupt rank and select quotient filter, run for quotient %d has no end", q))
+		}
+		word = r.runends(w)
+	}
+	return w*slotsPerBlock + uint64(bits.TrailingZeros64(word))
+}
+
+// eachHashValue calls cb with every hash value and the slot it is
+// stored in, in order
+func (r *rsReader) eachHashValue(size uint64, rBits uint, cb func(hv, slot uint64)) {
+	r.eachRun(size, func(q, start, end uint64) {
+		for slot := start; slot <= end; slot++ {
+			cb(q<<rBits|r.remainders(slot), slot)
+		}
+	})
+}
+
+// rsInsert inserts remainder dr with value into the run of quotient dq,
+// returning whether it updated an existing entry and the slot of the
+// entry
+func (qf *Filter) rsInsert(dq, dr, value uint64) (update bool, at uint64, err error) {
+	rs := qf.rs
+	occupied := rs.isOccupied(dq)
+
+	// find where dr belongs
+	var pos, end uint64
+	if occupied {
+		end = rs.runsEnd(dq) - 1
+		pos = end + 1
+		for slot := end; ; slot-- {
+			c := qf.compareEntry(slot, qf.filter.Get(slot), dr, value)
+			if c == 0 {
+				if qf.storage != nil {
+					qf.storage.Set(slot, value)
+				}
+				return true, slot, nil
+			}
+			if c < 0 {
+				break
+			}
+			pos = slot
+			if slot == dq || rs.isRunEnd(slot-1) {
+				break
+			}
+		}
+	} else {
+		pos = rs.runsEnd(dq)
+		if pos < dq {
+			pos = dq
+		}
+	}
+
+	// find the first empty slot at or after pos, hopping a whole
+	// cluster of runs at a time
+	empty := pos
+	for {
+		if empty >= rs.slots() {
+			return false, 0, errOverflow
+		}
+		next := rs.runsEnd(empty)
+		if next <= empty {
+			break
+		}
+		empty = next
+	}
+	if empty >= rs.slots() {
+		return false, 0, errOverflow
+	}
+
+	// make room at pos
+	shiftRight(qf.filter, pos, empty)
+	if qf.storage != nil {
+		shiftRight(qf.storage, pos, empty)
+	}
+	for _, col := range qf.columns {
+		shiftRight(col, pos, empty)
+	}
+	shiftBitsRight(rs.runendVec, pos, empty)
+	qf.filter.Set(pos, dr)
+	if qf.storage != nil {
+		qf.storage.Set(pos, value)
+	}
+	qf.clearColumns(pos)
+	switch {
+	case !occupied:
+		setBit(rs.occupiedVec, dq, true)
+		setBit(rs.runendVec, pos, true)
+	case pos == end+1:
+		setBit(rs.runendVec, end, false)
+		setBit(rs.runendVec, pos, true)
+	default:
+		setBit(rs.runendVec, pos, false)
+	}
+
+	// only blocks after dq, up to the end of the shifted slots, may
+	// have had their offsets changed
+	for b := dq/slotsPerBlock + 1; b*slotsPerBlock <= empty; b++ {
+		rs.offsetVec.Set(b, rs.offset(b))
+	}
+	qf.entries++
+	return false, pos, nil
+}
+
+// rsDelete removes the entry at slot pos from the run of quotient dq,
+// moving the rest of the run, and each following run which is shifted
+// from its quotient, down by one slot
+func (qf *Filter) rsDelete(dq, pos uint64) {
+	rs := qf.rs
+	end := rs.runEndFrom(dq, pos)
+	isStart := pos == dq || rs.isRunEnd(pos-1)
+	stop := end + 1
+	for q := dq + 1; ; q++ {
+		if q = rs.nextOccupied(q, stop); q == stop {
+			break
+		}
+		stop = rs.runEndFrom(q, stop) + 1
+	}
+
+	last := stop - 1
+	shiftLeft(qf.filter, pos, last)
+	qf.filter.Set(last, 0)
+	if qf.storage != nil {
+		shiftLeft(qf.storage, pos, last)
+		qf.storage.Set(last, 0)
+	}
+	for _, col := range qf.columns {
+		shiftLeft(col, pos, last)
+	}
+	qf.clearColumns(last)
+	shiftBitsLeft(rs.runendVec, pos, last)
+	switch {
+	case isStart && pos == end:
+		setBit(rs.occupiedVec, dq, false)
+	case pos == end:
+		setBit(rs.runendVec, pos-1, true)
+	}
+
+	for b := dq/slotsPerBlock + 1; b*slotsPerBlock <= stop; b++ {
+		rs.offsetVec.Set(b, rs.offset(b))
+	}
+	qf.entries--
+}
+
+// nextOccupied returns the first occupied quotient at or after q and
+// before limit, or limit if there is none
+func (r *rsReader) nextOccupied(q, limit uint64) uint64 {
+	for q < limit {
+		b := q / slotsPerBlock
+		if w := r.occupieds(b) &^ lowMask(q%slotsPerBlock); w != 0 {
+			if n := b*slotsPerBlock + uint64(bits.TrailingZeros64(w)); n < limit {
+				return n
+			}
+			return limit
+		}
+		q = (b + 1) * slotsPerBlock
+	}
+	return limit
+}
+
+func setBit(v Vector, bit uint64, on bool) {
+	w := bit / bitsPerWord
+	mask := uint64(1) << (bit % bitsPerWord)
+	if on {
+		v.Set(w, v.Get(w)|mask)
+	} else {
+		v.Set(w, v.Get(w)&^mask)
+	}
+}
+
+// shiftBitsRight moves bits [from, to) of a bitvector stored as words
+// in v up by one position, to [from+1, to]
+func shiftBitsRight(v Vector, from, to uint64) {
+	if to <= from {
+		return
+	}
+	lo, hi := from+1, to
+	for w := hi / bitsPerWord; ; w-- {
+		cur := v.Get(w)
+		shifted := cur << 1
+		if w > 0 {
+			shifted |= v.Get(w-1) >> (bitsPerWord - 1)
+		}
+		base := w * bitsPerWord
+		first, last := uint64(0), uint64(bitsPerWord-1)
+		if lo > base {
+			first = lo - base
+		}
+		if hi < base+bitsPerWord-1 {
+			last = hi - base
+		}
+		mask := lowMask(last+1) &^ lowMask(first)
+		v.Set(w, cur&^mask|shifted&mask)
+		if w == lo/bitsPerWord {
+			break
+		}
+	}
+}
+
+// shiftBitsLeft moves bits (from, to] of a bitvector stored as words
+// in v down by one position, to [from, to), and clears bit to
+func shiftBitsLeft(v Vector, from, to uint64) {
+	for w := from / bitsPerWord; w <= to/bitsPerWord; w++ {
+		cur := v.Get(w)
+		shifted := cur >> 1
+		if (w+1)*bitsPerWord <= to {
+			shifted |= v.Get(w+1) << (bitsPerWord - 1)
+		}
+		base := w * bitsPerWord
+		first, last := uint64(0), uint64(bitsPerWord-1)
+		if from > base {
+			first = from - base
+		}
+		if to < base+bitsPerWord-1 {
+			last = to - base
+		}
+		mask := lowMask(last+1) &^ lowMask(first)
+		v.Set(w, cur&^mask|shifted&mask)
+	}
+	setBit(v, to, false)
+}
+
+// rsValidate verifies the invariants of a rank and select quotient
+// filter, see validate
+func rsValidate(r *rsReader, size, entries uint64, values readFn) (err error) {
+	// runsEnd and eachRun panic when a run has no end
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	// no quotient beyond size may be occupied
+	for b := size / slotsPerBlock; b < r.blocks; b++ {
+		occ := r.occupieds(b)
+		if b*slotsPerBlock < size {
+			occ &^= lowMask(size % slotsPerBlock)
+		}
+		if occ != 0 {
+			return corrupt(b*slotsPerBlock+uint64(bits.TrailingZeros64(occ)), "overflow slot is marked occupied")
+		}
+	}
+	occupied, runends := 0, 0
+	for b := uint64(0); b < r.blocks; b++ {
+		occupied += bits.OnesCount64(r.occupieds(b))
+		runends += bits.OnesCount64(r.runends(b))
+	}
+	if occupied != runends {
+		return fmt.Errorf("corrupt quotient filter: %d occupied quotients but %d runs", occupied, runends)
+	}
+	for b := uint64(0); b < r.blocks; b++ {
+		if off, expected := r.offsets(b), r.offset(b); off != expected {
+			return corrupt(b*slotsPerBlock, "block offset is %d, expected %d", off, expected)
+		}
+	}
+	count := uint64(0)
+	r.eachRun(size, func(q, start, end uint64) {
+		count += end - start + 1
+		for slot := start + 1; slot <= end && err == nil; slot++ {
+			if !inOrder(r.remainders(slot), r.remainders(slot-1), slot, slot-1, values) {
+				err = corrupt(slot, "remainder %x is not greater than its predecessor %x in the run",
+					r.remainders(slot), r.remainders(slot-1))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if count != entries {
+		return fmt.Errorf("corrupt quotient filter: %d entries recorded, %d found", entries, count)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"unsafe"
+)
+
+// segmentSlots is the number of slots in each segment of a segmented
+// vector, rounded down to whole blocks for blocked vectors
+const segmentSlots = 1 << 16
+
+type segmentKind int
+
+const (
+	segUnpacked segmentKind = iota
+	segPacked
+	segBlocked
+)
+
+// segmented is a Vector made up of separately allocated segments.  It
+// lets a quotient filter double without holding both the old and the
+// new table in full: segments of the new table are allocated when they
+// are first written, and those of the old table released once they
+// have been read.  It serializes exactly as the vector it segments
+type segmented struct {
+	alloc VectorAllocateFn
+	kind  segmentKind
+	bits  uint
+	size  uint64
+	segOf divisor
+	segs  []Vector
+}
+
+var _ Vector = (*segmented)(nil)
+var _ Shifter = (*segmented)(nil)
+
+// newSegmented returns an empty segmented vector of size elements
+// whose segments are allocated by alloc, or nil if alloc's vectors
+// can't be segmented or size fits within a single segment
+func newSegmented(alloc VectorAllocateFn, bits uint, size uint64) *segmented {
+	if v := segmentsOf(alloc, bits, size); v != nil && size > v.segOf.d {
+		return v
+	}
+	return nil
+}
+
+// segmentsOf returns an empty segmented vector of size elements, however
+// few, or nil if alloc's vectors can't be segmented
+func segmentsOf(alloc VectorAllocateFn, bits uint, size uint64) *segmented {
+	per := uint64(segmentSlots)
+	var kind segmentKind
+	switch x := alloc(bits, 0).(type) {
+	case *unpacked:
+		kind = segUnpacked
+	case *packed:
+		kind = segPacked
+	case *blocked:
+		kind = segBlocked
+		per -= per % x.perBlock
+	default:
+		return nil
+	}
+	segs := make([]Vector, (size+per-1)/per)
+	return &segmented{alloc, kind, bits, size, newDivisor(per), segs}
+}
+
+// segLen returns the number of elements in segment k
+func (v *segmented) segLen(k uint64) uint64 {
+	if rem := v.size - k*v.segOf.d; rem < v.segOf.d {
+		return rem
+	}
+	return v.segOf.d
+}
+
+// segment returns segment k, allocating it if need be
+func (v *segmented) segment(k uint64) Vector {
+	if v.segs[k] == nil {
+		v.segs[k] = v.alloc(v.bits, v.segLen(k))
+	}
+	return v.segs[k]
+}
+
+// release frees segment k, which must not be accessed again
+func (v *segmented) release(k uint64) {
+	v.segs[k] = nil
+}
+
+func (v *segmented) Get(ix uint64) uint64 {
+	k := v.segOf.div(ix)
+	s := v.segs[k]
+	if s == nil {
+		return 0
+	}
+	return s.Get(ix - k*v.segOf.d)
+}
+
+func (v *segmented) Set(ix uint64, val uint64) {
+	k := v.segOf.div(ix)
+	if v.segs[k] == nil && val == 0 {
+		return
+	}
+	v.segment(k).Set(ix-k*v.segOf.d, val)
+}
+
+func (v *segmented) Swap(ix uint64, val uint64) (oldval uint64) {
+	oldval = v.Get(ix)
+	v.Set(ix, val)
+	return
+}
+
+func (v *segmented) ShiftRight(from, to uint64) {
+	// shift within each segment from the top down, carrying the last
+	// element of the preceding segment into the first of the next
+	for to > from {
+		k := v.segOf.div(to)
+		base := k * v.segOf.d
+		lo := base
+		if lo < from {
+			lo = from
+		}
+		if s := v.segs[k]; s != nil {
+			shiftRight(s, lo-base, to-base)
+		}
+		if lo == from {
+			return
+		}
+		v.Set(lo, v.Get(lo-1))
+		to = lo - 1
+	}
+}
+
+// segWords reports the number of words of the serialized vector which
+// are held by segment k
+func (v *segmented) segWords(k uint64) uint64 {
+	n := v.segLen(k)
+	switch v.kind {
+	case segPacked:
+		// every segment but the last is a whole number of words
+		return (n*uint64(v.bits) + bitsPerWord - 1) / bitsPerWord
+	case segBlocked:
+		l := newBlockedLayout(v.bits)
+		return l.words(n)
+	}
+	return n
+}
+
+// words reports the number of words of the serialized vector
+func (v *segmented) words() uint64 {
+	switch v.kind {
+	case segPacked:
+		return wordsRequired(v.bits, v.size)
+	case segBlocked:
+		l := newBlockedLayout(v.bits)
+		return l.words(v.size)
+	}
+	return v.size
+}
+
+func (v *segmented) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = v.writeHeader(w); err != nil {
+		return
+	}
+	var m int64
+	for k := range v.segs {
+		m, err = v.writeSegment(w, uint64(k))
+		n += m
+		if err != nil {
+			return
+		}
+	}
+	m, err = v.writeTrailer(w)
+	n += m
+	return
+}
+
+// writeHeader writes the header and length of the vector being
+// segmented
+func (v *segmented) writeHeader(w io.Writer) (n int64, err error) {
+	switch v.kind {
+	case segPacked:
+		h := packedHeader{Version: qfBitPackedVectorVersion, Bits: uint64(v.bits), Size: v.size}
+		if err = binary.Write(w, binary.LittleEndian, h); err != nil {
+			return
+		}
+		n += int64(unsafe.Sizeof(h))
+	case segBlocked:
+		h := blockedHeader{Version: qfBlockedVectorVersion, Bits: uint64(v.bits), Size: v.size}
+		if err = binary.Write(w, binary.LittleEndian, h); err != nil {
+			return
+		}
+		n += int64(unsafe.Sizeof(h))
+	}
+	if err = binary.Write(w, binary.LittleEndian, v.words()); err != nil {
+		return
+	}
+	n += 8
+	return
+}
+
+// writeSegment writes the words of segment k
+func (v *segmented) writeSegment(w io.Writer, k uint64) (int64, error) {
+	sw := v.segWords(k)
+	var data []uint64
+	switch x := v.segs[k].(type) {
+	case nil:
+		data = make([]uint64, sw)
+	case *unpacked:
+		data = *x
+	case *packed:
+		data = x.space
+	case *blocked:
+		data = x.space
+	}
+	return writeUintWords(w, data[:sw])
+}
+
+// writeTrailer writes the words following the last segment, a packed
+// vector has a spare trailing word
+func (v *segmented) writeTrailer(w io.Writer) (int64, error) {
+	words := v.words()
+	for k := range v.segs {
+		words -= v.segWords(uint64(k))
+	}
+	return writeUintWords(w, make([]uint64, words))
+}
+
+func (v *segmented) ReadFrom(r io.Reader) (int64, error) {
+	return 0, errors.New("segmented vectors can't be read, read into the vector being segmented")
+}
+
+// segmentReleaser returns a function to be called with each slot of a
+// sequential (possibly wrapping) scan of vs, which releases the segments
+// of any segmented vectors behind the scan and reports whether it did.
+// The segment holding the first slot scanned is kept, as a scan which
+// wraps around ends there
+func segmentReleaser(vs ...Vector) func(slot uint64) bool {
+	type scan struct {
+		v          *segmented
+		first, cur uint64
+	}
+	var scans []scan
+	for _, v := range vs {
+		if s, ok := v.(*segmented); ok {
+			scans = append(scans, scan{v: s, first: ^uint64(0)})
+		}
+	}
+	return func(slot uint64) (released bool) {
+		for i := range scans {
+			s := &scans[i]
+			k := s.v.segOf.div(slot)
+			if s.first == ^uint64(0) {
+				s.first, s.cur = k, k
+			} else if k != s.cur {
+				if s.cur != s.first {
+					s.v.release(s.cur)
+					released = true
+				}
+				s.cur = k
+			}
+		}
+		return
+	}
+}
+
+// vectorStream writes a vector in the serialization format of the
+// vectors of an allocator as its elements are set in ascending order.
+// Only the segment being set is held in memory
+type vectorStream struct {
+	v *segmented
+	w io.Writer
+	// the segment being set, those before it are written
+	k   uint64
+	n   int64
+	err error
+}
+
+// newVectorStream returns a stream writing a vector of size elements to
+// w, or nil if alloc's vectors can't be segmented
+func newVectorStream(w io.Writer, alloc VectorAllocateFn, bits uint, size uint64) *vectorStream {
+	v := segmentsOf(alloc, bits, size)
+	if v == nil {
+		return nil
+	}
+	s := &vectorStream{v: v, w: w}
+	s.n, s.err = v.writeHeader(w)
+	return s
+}
+
+// Set sets element ix, which must follow every element set before it
+func (s *vectorStream) Set(ix uint64, val uint64) {
+	s.flush(s.v.segOf.div(ix))
+	s.v.Set(ix, val)
+}
+
+// flush writes and releases the segments before segment k
+func (s *vectorStream) flush(k uint64) {
+	for ; s.k < k; s.k++ {
+		if s.err == nil {
+			var m int64
+			m, s.err = s.v.writeSegment(s.w, s.k)
+			s.n += m
+		}
+		s.v.release(s.k)
+	}
+}
+
+// Close writes the rest of the vector, returning the number of bytes
+// written in all and the first error encountered
+func (s *vectorStream) Close() (int64, error) {
+	s.flush(uint64(len(s.v.segs)))
+	if s.err == nil {
+		var m int64
+		m, s.err = s.v.writeTrailer(s.w)
+		s.n += m
+	}
+	return s.n, s.err
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fillToCapacity inserts pseudo random hashes until one more would
+// double the quotient filter
+func fillToCapacity(qf *Filter, seed uint64) {
+	for hv := seed; qf.entries < qf.maxEntries; hv++ {
+		qf.InsertRawHash(hv*0x9e3779b97f4a7c15, hv&0xff)
+	}
+}
+
+func TestSegmentedDoubling(t *testing.T) {
+	configs := map[string]Config{
+		"unpacked":    {},
+		"packed":      {BitPacked: true},
+		"blocked":     {VectorAllocate: BlockedVectorAllocate},
+		"rank-select": {BitPacked: true, Layout: LayoutRankSelect},
+	}
+	for name, c := range configs {
+		c.BitsOfStoragePerEntry = 8
+		c.ExpectedEntries = segmentSlots
+		// tables are whole unless asked otherwise
+		qf := NewWithConfig(c)
+		fillToCapacity(qf, 0)
+		qf.InsertRawHash(0xffffffff, 1)
+		_, isSegmented := qf.filter.(*segmented)
+		assert.False(t, isSegmented, name)
+
+		c.SegmentedGrowth = true
+		qf = NewWithConfig(c)
+		if !assert.IsType(t, &segmented{}, qf.filter, name) {
+			continue
+		}
+		for i := uint64(0); i < 3; i++ {
+			fillToCapacity(qf, i<<32)
+			qf.InsertRawHash(i<<32|0xffffffff, 1)
+		}
+		if !assert.IsType(t, &segmented{}, qf.filter, name) {
+			continue
+		}
+		assert.NoError(t, qf.Validate(), name)
+		var hashes []uint64
+		qf.eachHashValue(func(hv, slot uint64) {
+			hashes = append(hashes, hv)
+		})
+		assert.Equal(t, qf.Len(), uint64(len(hashes)), name)
+
+		// a segmented filter serializes exactly as a whole one
+		var buf bytes.Buffer
+		_, err := qf.WriteTo(&buf)
+		assert.NoError(t, err)
+		cpy := NewWithConfig(c)
+		_, err = cpy.ReadFrom(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err)
+		_, isSegmented = cpy.filter.(*segmented)
+		assert.False(t, isSegmented, name)
+		assert.Equal(t, qf.Stats(), cpy.Stats())
+		var cpyBuf bytes.Buffer
+		_, err = cpy.WriteTo(&cpyBuf)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(buf.Bytes(), cpyBuf.Bytes()), "%s serialization differs", name)
+
+		name, err := writeQFToTempFile(qf)
+		defer os.Remove(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		ext, err := OpenReadOnlyFromPath(name)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ext.Close()
+		assert.NoError(t, ext.Validate())
+		assert.Equal(t, qf.Stats(), ext.Stats())
+	}
+}
+
+// BenchmarkDoublingPeakHeap reports the peak live heap during the
+// first doubling of a filter, relative to the size of the new table,
+// with whole and with segmented tables
+func BenchmarkDoublingPeakHeap(b *testing.B) {
+	for _, segmented := range []bool{false, true} {
+		name := "whole"
+		if segmented {
+			name = "segmented"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkDoublingPeakHeap(b, Config{
+				BitPacked:             true,
+				BitsOfStoragePerEntry: 16,
+				ExpectedEntries:       1 << 18,
+				SegmentedGrowth:       segmented,
+			})
+		})
+	}
+}
+
+func benchmarkDoublingPeakHeap(b *testing.B, c Config) {
+	var peak uint64
+	heap := func() uint64 {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+	defer func() {
+		testHookDoubling = nil
+	}()
+	var ratio float64
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		testHookDoubling = nil
+		base := heap()
+		qf := NewWithConfig(c)
+		fillToCapacity(qf, 0)
+		peak = heap()
+		testHookDoubling = func() {
+			if h := heap(); h > peak {
+				peak = h
+			}
+		}
+		b.StartTimer()
+
+		qf.InsertRawHash(1<<62, 0)
+
+		b.StopTimer()
+		s := qf.Stats()
+		ratio = float64(peak-base) / float64(s.FilterBytes+s.StorageBytes)
+		runtime.KeepAlive(qf)
+	}
+	b.ReportMetric(ratio, "peak/new")
+}
+
+// BenchmarkSegmentedLookup compares lookups in whole and in segmented
+// tables, which a filter with Config.SegmentedGrowth keeps once grown
+func BenchmarkSegmentedLookup(b *testing.B) {
+	for _, segmented := range []bool{false, true} {
+		name := "whole"
+		if segmented {
+			name = "segmented"
+		}
+		b.Run(name, func(b *testing.B) {
+			qf := NewWithConfig(Config{ExpectedEntries: 1 << 18, SegmentedGrowth: segmented})
+			for _, s := range testStrings {
+				qf.InsertString(s)
+			}
+			numStrings := len(testStrings)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				qf.ContainsString(testStrings[n%numStrings])
+			}
+		})
+	}
+}
@@ -7,13 +7,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 	"unsafe"
 )
 
 // qfVersion is a version number for the
 // on disk representation format.  Any time incompatible
 // changes are made, it is bumped
-const qfVersion = uint64(0x0004)
+const qfVersion = uint64(0x0010)
 
 // QFHeader describes a serialized quotient filter
 type QFHeader struct {
@@ -26,11 +27,56 @@ type QFHeader struct {
 	// length of the hash vector on disk will then be 1 << QBits
 	QBits uint64
 	// the number of bits per bucket of storage represented in the
-	// quotient filter.  May be zero if no external storage is in
-	// use
+	// quotient filter, including EpochBits.  May be zero if no
+	// external storage is in use
 	StorageBits uint64
 	// whether the quotient filters use bitpacked storage
 	BitPacked bool
+	// whether the quotient filters use cache line blocked storage,
+	// see BlockedVectorAllocate
+	Blocked bool
+	// the arrangement of slots and metadata, see Layout.  The block
+	// metadata of the rank and select layout follows the storage
+	Layout uint64
+	// the hash used for integer keys, see IntegerMixer
+	IntegerMixer uint64
+	// whether keys are hashed with SipHash-2-4 keyed by HashKey, see
+	// Config.KeyedHash
+	KeyedHash bool
+	HashKey   [16]byte
+	// the hash function, see HashAlgorithm
+	HashAlgorithm uint64
+	// the lifetime of entries in nanoseconds, and the high bits of
+	// storage holding the epoch of each, see Config.TTL.  Zero when
+	// entries don't expire
+	TTL       int64
+	EpochBits uint64
+	// whether byte string values are stored in an arena, which
+	// follows the rest of the quotient filter, and the size of the
+	// arena and of the values in it which were overwritten.  See
+	// Config.ValueArena
+	ValueArena   bool
+	ArenaBytes   uint64
+	ArenaGarbage uint64
+	// the number of columns, see Config.Columns.  Their schema follows
+	// the header and their vectors follow the storage
+	Columns uint64
+	// whether a key may have many values, and how many, see
+	// Config.Multiset
+	Multiset        bool
+	MaxValuesPerKey uint64
+	// the loading at which the quotient filter doubles, and whether it
+	// may, see Config.LoadFactor and Config.FixedCapacity
+	LoadFactor    float64
+	FixedCapacity bool
+	// the epoch of the last sweep of expired entries, from which the
+	// epoch of each entry is recovered, see Config.TTL
+	EpochBase uint64
+	// the configured width of fingerprints and the width of those of
+	// new entries, when fingerprints are stored in place of remainders,
+	// see Config.FingerprintBits
+	FingerprintBits  uint64
+	FingerprintWidth uint64
 }
 
 // ReadHeaderFromPath reads and returns the header from a serialized quotient filter
@@ -53,19 +99,19 @@ func ReadHeaderFromPath(path string) (*QFHeader, error) {
 // WARNING: the default storage format is very fast, but not portable
 // to architectures of differing word length or endianness
 func (qf *Filter) WriteTo(stream io.Writer) (i int64, err error) {
-	h := QFHeader{
-		Version:     qfVersion,
-		Entries:     qf.entries,
-		QBits:       uint64(qf.qBits),
-		StorageBits: uint64(qf.config.BitsOfStoragePerEntry),
-		BitPacked:   qf.config.BitPacked,
-	}
+	h := qf.header()
 	if err = binary.Write(stream, binary.LittleEndian, h); err != nil {
 		return
 	}
 	i += int64(unsafe.Sizeof(h))
 
-	x, err := qf.filter.WriteTo(stream)
+	x, err := writeColumns(stream, qf.config.Columns)
+	i += x
+	if err != nil {
+		return
+	}
+
+	x, err = qf.filter.WriteTo(stream)
 	i += x
 	if err != nil {
 		return
@@ -79,9 +125,65 @@ func (qf *Filter) WriteTo(stream io.Writer) (i int64, err error) {
 		}
 	}
 
+	for _, col := range qf.columns {
+		x, err = col.WriteTo(stream)
+		i += x
+		if err != nil {
+			return
+		}
+	}
+
+	if qf.rs != nil {
+		for _, v := range qf.rs.vectors() {
+			x, err = v.WriteTo(stream)
+			i += x
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if h.ValueArena {
+		var np int
+		np, err = stream.Write(qf.arena)
+		i += int64(np)
+	}
 	return
 }
 
+// header returns the header describing qf
+func (qf *Filter) header() QFHeader {
+	h := QFHeader{
+		Version:       qfVersion,
+		Entries:       qf.entries,
+		QBits:         uint64(qf.qBits),
+		StorageBits:   uint64(qf.config.BitsOfStoragePerEntry + qf.config.epochBits()),
+		Layout:        uint64(qf.config.Layout),
+		IntegerMixer:  uint64(qf.config.IntegerMixer),
+		KeyedHash:     qf.config.KeyedHash,
+		HashKey:       qf.config.HashKey,
+		HashAlgorithm: uint64(qf.config.HashAlgorithm),
+		Columns:       uint64(len(qf.config.Columns)),
+		LoadFactor:    qf.config.loadFactor(),
+		FixedCapacity: qf.config.FixedCapacity,
+	}
+	if qf.config.Multiset {
+		h.Multiset, h.MaxValuesPerKey = true, qf.config.maxValuesPerKey()
+	}
+	if qf.expiry != nil {
+		h.TTL, h.EpochBits = int64(qf.expiry.ttl()), uint64(qf.expiry.epochBits)
+		h.EpochBase = qf.expiry.base
+	}
+	if qf.config.ValueArena {
+		h.ValueArena, h.ArenaBytes, h.ArenaGarbage = true, uint64(len(qf.arena)), qf.garbage
+	}
+	if qf.fpBits != 0 {
+		h.FingerprintBits, h.FingerprintWidth = uint64(qf.config.FingerprintBits), uint64(qf.fpBits)
+	}
+	h.BitPacked, h.Blocked = vectorFormat(qf.filter)
+	return h
+}
+
 // ReadFrom allows the quotient filter to be read from a stream
 //
 // WARNING: the default storage format is very fast, but not portable
@@ -96,9 +198,61 @@ func (qf *Filter) ReadFrom(stream io.Reader) (i int64, err error) {
 		return i, fmt.Errorf("incompatible file format: version is %d, expected %d",
 			h.Version, qfVersion)
 	}
+	cols, n, err := readColumns(stream, h.Columns)
+	i += n
+	if err != nil {
+		return
+	}
+	qf.config.Columns = cols
+	if !(h.LoadFactor > 0 && h.LoadFactor < 1) {
+		return i, fmt.Errorf("invalid file format, load factor %f", h.LoadFactor)
+	}
+	qf.config.LoadFactor, qf.config.FixedCapacity = h.LoadFactor, h.FixedCapacity
+	qf.config.Multiset, qf.config.MaxValuesPerKey = h.Multiset, uint(h.MaxValuesPerKey)
+	switch Layout(h.Layout) {
+	case LayoutClassic, LayoutRankSelect:
+	default:
+		return i, fmt.Errorf("unsupported quotient filter layout: %s", Layout(h.Layout))
+	}
+	qf.config.Layout = Layout(h.Layout)
+	if !IntegerMixer(h.IntegerMixer).valid() {
+		return i, fmt.Errorf("unsupported integer mixer: %s", IntegerMixer(h.IntegerMixer))
+	}
+	qf.config.IntegerMixer = IntegerMixer(h.IntegerMixer)
+	if qf.hasher, err = h.keyHash(qf.config.HashFn); err != nil {
+		return
+	}
+	qf.config.HashAlgorithm = HashAlgorithm(h.HashAlgorithm)
+	if qf.config.HashAlgorithm != HashCustom {
+		qf.config.HashFn = nil
+	}
+	qf.config.KeyedHash, qf.config.HashKey = h.KeyedHash, h.HashKey
+	if qf.expiry, err = h.expiry(qf.config.Clock); err != nil {
+		return
+	}
+	qf.config.TTL, qf.config.EpochBits = time.Duration(h.TTL), uint(h.EpochBits)
+	if qf.config.VectorAllocate == nil {
+		// read whichever of our vectors was written
+		qf.config.BitPacked = h.BitPacked
+		switch {
+		case h.Blocked:
+			qf.allocfn = BlockedVectorAllocate
+		case h.BitPacked:
+			qf.allocfn = BitPackedVectorAllocate
+		default:
+			qf.allocfn = UnpackedVectorAllocate
+		}
+	}
+	// vectors are always read whole, never segmented
+	qf.filter = qf.allocfn(0, 0)
+	qf.storage = nil
 	qf.entries = h.Entries
+	if h.FingerprintWidth > bitsPerWord-h.QBits || (h.FingerprintWidth == 0) != (h.FingerprintBits == 0) {
+		return i, fmt.Errorf("invalid file format, %d bit fingerprints with %d quotient bits", h.FingerprintWidth, h.QBits)
+	}
+	qf.config.FingerprintBits, qf.fpBits = uint(h.FingerprintBits), uint(h.FingerprintWidth)
 	qf.initForQuotientBits(uint(h.QBits))
-	n, err := qf.filter.ReadFrom(stream)
+	n, err = qf.filter.ReadFrom(stream)
 	i += n
 	if err != nil {
 		return
@@ -106,8 +260,9 @@ func (qf *Filter) ReadFrom(stream io.Reader) (i int64, err error) {
 
 	// read bits
 
+	qf.config.BitsOfStoragePerEntry = 0
 	if h.StorageBits > 0 {
-		qf.config.BitsOfStoragePerEntry = uint(h.StorageBits)
+		qf.config.BitsOfStoragePerEntry = uint(h.StorageBits - h.EpochBits)
 		if qf.storage == nil {
 			qf.storage = qf.allocfn(0, 0)
 		}
@@ -118,5 +273,87 @@ func (qf *Filter) ReadFrom(stream io.Reader) (i int64, err error) {
 		}
 	}
 
+	qf.columns = make([]Vector, len(cols))
+	for j := range qf.columns {
+		qf.columns[j] = qf.allocfn(0, 0)
+		n, err = qf.columns[j].ReadFrom(stream)
+		i += n
+		if err != nil {
+			return
+		}
+	}
+
+	qf.rs = nil
+	if qf.config.Layout == LayoutRankSelect {
+		qf.rs = &rankSelect{
+			occupiedVec: qf.allocfn(0, 0),
+			runendVec:   qf.allocfn(0, 0),
+			offsetVec:   qf.allocfn(0, 0),
+		}
+		for _, v := range qf.rs.vectors() {
+			n, err = v.ReadFrom(stream)
+			i += n
+			if err != nil {
+				return
+			}
+		}
+		qf.rs.bind(qf.filter, qf.size)
+	}
+
+	qf.config.ValueArena, qf.arena, qf.garbage = h.ValueArena, nil, 0
+	if h.ValueArena {
+		qf.arena = make([]byte, h.ArenaBytes)
+		var np int
+		np, err = io.ReadFull(stream, qf.arena)
+		i += int64(np)
+		qf.garbage = h.ArenaGarbage
+	}
 	return
 }
+
+// keyHash returns the hash function recorded in h, custom is used for
+// quotient filters built with Config.HashFn
+func (h *QFHeader) keyHash(custom HashFn) (keyHash, error) {
+	a := HashAlgorithm(h.HashAlgorithm)
+	if h.KeyedHash != (a == HashSipHash) {
+		return keyHash{}, fmt.Errorf("invalid file format, %s is inconsistent with keyed hashing", a)
+	}
+	switch {
+	case a == HashCustom && custom == nil:
+		return keyHash{}, fmt.Errorf("quotient filter was built with a custom hash function, which must be configured to read it")
+	case a > HashCustom:
+		return keyHash{}, fmt.Errorf("invalid file format, %s", a)
+	}
+	return newKeyHash(a, h.HashKey, custom), nil
+}
+
+// expiry returns the expiry of entries recorded in h, or nil if they
+// don't expire
+func (h *QFHeader) expiry(clock func() time.Time) (*expiry, error) {
+	if h.TTL == 0 {
+		return nil, nil
+	}
+	if h.EpochBits > h.StorageBits {
+		return nil, fmt.Errorf("invalid file format, %d epoch bits exceed %d storage bits", h.EpochBits, h.StorageBits)
+	}
+	e, err := newExpiry(time.Duration(h.TTL), uint(h.EpochBits), uint(h.StorageBits-h.EpochBits), clock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file format, %w", err)
+	}
+	e.base = h.EpochBase
+	return e, nil
+}
+
+// vectorFormat reports which of the serialization formats of this
+// package v is written in
+func vectorFormat(v Vector) (isPacked, isBlocked bool) {
+	switch x := v.(type) {
+	case *packed:
+		return true, false
+	case *blocked:
+		return false, true
+	case *segmented:
+		return x.kind == segPacked, x.kind == segBlocked
+	}
+	return false, false
+}
@@ -0,0 +1,169 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"math"
+)
+
+// overlapZ is the number of standard deviations either side of an
+// estimate covering 95% of outcomes
+const overlapZ = 1.96
+
+// hashStream returns a cursor over the hash values of r in ascending
+// order, with the hashing it was built with.  A quotient filter with
+// fingerprints keeps too little of each hash to be compared
+func hashStream(r Reader) (c *hashCursor, h keyHash, mixer IntegerMixer, mixKey uint64, err error) {
+	switch f := r.(type) {
+	case *Filter:
+		if f.fpBits != 0 {
+			return nil, keyHash{}, 0, 0, fmt.Errorf("cannot compare a quotient filter with fingerprints, which keeps too little of each hash")
+		}
+		v := f.view()
+		return newHashCursor(&v, f.rBits), f.hasher, f.config.IntegerMixer, f.config.integerKey(), nil
+	case *Disk:
+		v := f.view(true)
+		return newHashCursor(&v, f.rBits), f.hasher, f.mixer, f.mixKey, nil
+	}
+	return nil, keyHash{}, 0, 0, fmt.Errorf("cannot compare a %T", r)
+}
+
+// hashStreams returns cursors over the hash values of a and b, which
+// must hash keys identically for their hash values to be compared.
+// Quotient filters with custom hash functions are assumed to use the
+// same one
+func hashStreams(a, b Reader) (ca, cb *hashCursor, err error) {
+	ca, ha, ma, ka, err := hashStream(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	cb, hb, mb, kb, err := hashStream(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ha.alg != hb.alg || ha.k0 != hb.k0 || ha.k1 != hb.k1 {
+		return nil, nil, fmt.Errorf("quotient filters hash keys differently (%s and %s, or different keys)", ha.alg, hb.alg)
+	}
+	if ma != mb || ka != kb {
+		return nil, nil, fmt.Errorf("quotient filters hash integer keys differently (%s and %s)", ma, mb)
+	}
+	return ca, cb, nil
+}
+
+// mergeCount walks the hash values of a and b no greater than last,
+// returning the number in both, and the number in a
+func mergeCount(a, b *hashCursor, last uint64) (common, seen uint64) {
+	x, okx := a.nextHash()
+	y, oky := b.nextHash()
+	for okx && x <= last {
+		switch {
+		case !oky || y > last || x < y:
+			seen++
+			x, okx = a.nextHash()
+		case y < x:
+			y, oky = b.nextHash()
+		default:
+			common++
+			seen++
+			x, okx = a.nextHash()
+			y, oky = b.nextHash()
+		}
+	}
+	return common, seen
+}
+
+// OverlapCount returns the number of keys in both a and b, each of
+// which is a Filter or Disk, without building their intersection.  As
+// keys are compared by their full 64 bit hash it is exact but for
+// collisions between hashes, so both must hash keys identically: with
+// the same HashAlgorithm, HashKey and IntegerMixer.  A Disk is read
+// sequentially, once
+func OverlapCount(a, b Reader) (uint64, error) {
+	ca, cb, err := hashStreams(a, b)
+	if err != nil {
+		return 0, err
+	}
+	common, _ := mergeCount(ca, cb, math.MaxUint64)
+	return common, nil
+}
+
+// jaccard returns the Jaccard index of sets of size na and nb with
+// overlap keys in common
+func jaccard(na, nb, overlap float64) float64 {
+	union := na + nb - overlap
+	if union <= 0 {
+		return 0
+	}
+	return overlap / union
+}
+
+// Jaccard returns the Jaccard index of a and b, the number of keys in
+// both over the number in either, see OverlapCount.  It is zero if both
+// are empty
+func Jaccard(a, b Reader) (float64, error) {
+	common, err := OverlapCount(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return jaccard(float64(a.Len()), float64(b.Len()), float64(common)), nil
+}
+
+// OverlapEstimate is the result of EstimateOverlap
+type OverlapEstimate struct {
+	// Overlap is the estimated number of keys in both quotient
+	// filters, which lies within [Low, High] with 95% confidence
+	Overlap, Low, High float64
+	// Jaccard is the estimated Jaccard index, which lies within
+	// [JaccardLow, JaccardHigh] with 95% confidence
+	Jaccard, JaccardLow, JaccardHigh float64
+	// Sampled is the number of keys of the smaller quotient filter
+	// checked against the other
+	Sampled uint64
+}
+
+// EstimateOverlap estimates the number of keys in both a and b, like
+// OverlapCount, reading only the given fraction (in (0, 1]) of the
+// hash space of each.  Hashes are uniformly distributed, so the keys of
+// the smaller quotient filter found there are a random sample of its
+// keys, and the proportion of them also in the other bounds the
+// overlap.  The cost, and the width of the bounds, scale with fraction
+// and the square root of its inverse respectively
+func EstimateOverlap(a, b Reader, fraction float64) (OverlapEstimate, error) {
+	if !(fraction > 0 && fraction <= 1) {
+		return OverlapEstimate{}, fmt.Errorf("sample fraction %v is not within (0, 1]", fraction)
+	}
+	if b.Len() < a.Len() {
+		a, b = b, a
+	}
+	ca, cb, err := hashStreams(a, b)
+	if err != nil {
+		return OverlapEstimate{}, err
+	}
+	last := uint64(math.MaxUint64)
+	if f := math.Ldexp(fraction, bitsPerWord); f < math.Ldexp(1, bitsPerWord) {
+		last = uint64(f)
+	}
+	common, seen := mergeCount(ca, cb, last)
+
+	na, nb := float64(a.Len()), float64(b.Len())
+	est := OverlapEstimate{Sampled: seen, High: na}
+	if seen > 0 {
+		// the Wilson score interval of the proportion of a also in b
+		n, p := float64(seen), float64(common)/float64(seen)
+		z2 := overlapZ * overlapZ
+		center := (p + z2/(2*n)) / (1 + z2/n)
+		half := overlapZ / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+		est.Overlap = p * na
+		est.Low = math.Max(0, center-half) * na
+		est.High = math.Min(1, center+half) * na
+		if seen == a.Len() {
+			// every key was checked
+			est.Low, est.High = est.Overlap, est.Overlap
+		}
+	}
+	est.Jaccard = jaccard(na, nb, est.Overlap)
+	est.JaccardLow = jaccard(na, nb, est.Low)
+	est.JaccardHigh = jaccard(na, nb, est.High)
+	return est, nil
+}
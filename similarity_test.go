@@ -0,0 +1,132 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sortedHashes reads every hash value of qf through a hashCursor
+func sortedHashes(qf *Filter) []uint64 {
+	v := qf.view()
+	c := newHashCursor(&v, qf.rBits)
+	var hvs []uint64
+	for hv, ok := c.nextHash(); ok; hv, ok = c.nextHash() {
+		hvs = append(hvs, hv)
+	}
+	return hvs
+}
+
+func TestHashCursor(t *testing.T) {
+	// keys hashed to the last quotients wrap around the end of the table
+	wrap := func(v []byte) uint64 {
+		i, _ := strconv.Atoi(string(v))
+		if i%3 == 0 {
+			return ^uint64(i)
+		}
+		return Murmur64(v)
+	}
+	for _, c := range []Config{
+		{ExpectedEntries: 40, BitsOfStoragePerEntry: 4, HashFn: wrap},
+		{ExpectedEntries: 40, BitsOfStoragePerEntry: 4, HashFn: wrap, Layout: LayoutRankSelect},
+		{ExpectedEntries: 1000},
+		{ExpectedEntries: 1000, Layout: LayoutRankSelect},
+	} {
+		qf := NewWithConfig(c)
+		assert.Empty(t, sortedHashes(qf))
+		var want []uint64
+		for i := 0; i < 40; i++ {
+			k := []byte(strconv.Itoa(i))
+			qf.Insert(k)
+			want = append(want, qf.hasher.sum(k))
+		}
+		sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+		assert.Equal(t, want, sortedHashes(qf))
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		a := NewWithConfig(Config{ExpectedEntries: 1000, Layout: layout})
+		b := NewWithConfig(Config{ExpectedEntries: 200000, Layout: LayoutRankSelect})
+		for i := 0; i < 3000; i++ {
+			a.InsertString(fmt.Sprintf("k%d", i))
+		}
+		for i := 2000; i < 100000; i++ {
+			b.InsertString(fmt.Sprintf("k%d", i))
+		}
+		n, err := OverlapCount(a, b)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1000), n)
+		j, err := Jaccard(b, a)
+		assert.NoError(t, err)
+		assert.InDelta(t, 1000.0/100000, j, 1e-9)
+
+		// on disk too, in any combination
+		name, err := writeQFToTempFile(a)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		for _, pair := range [][2]Reader{{ext, b}, {b, ext}, {ext, ext}, {a, ext}} {
+			n, err = OverlapCount(pair[0], pair[1])
+			assert.NoError(t, err)
+			if pair[1] == b || pair[0] == b {
+				assert.Equal(t, uint64(1000), n)
+			} else {
+				assert.Equal(t, a.Len(), n)
+			}
+		}
+
+		est, err := EstimateOverlap(a, b, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1000.0, est.Overlap)
+		assert.Equal(t, est.Overlap, est.Low)
+		assert.InDelta(t, j, est.Jaccard, 1e-9)
+		for _, f := range []float64{0.05, 0.2, 0.5} {
+			est, err = EstimateOverlap(b, ext, f)
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, est.Low, 1000.0, "%v", f)
+			assert.GreaterOrEqual(t, est.High, 1000.0, "%v", f)
+			assert.LessOrEqual(t, est.JaccardLow, j)
+			assert.GreaterOrEqual(t, est.JaccardHigh, j)
+			assert.InDelta(t, f*3000, float64(est.Sampled), 3000*f/2)
+		}
+		ext.Close()
+		os.Remove(name)
+	}
+
+	// empty quotient filters don't overlap
+	a, b := New(), New()
+	j, err := Jaccard(a, b)
+	assert.NoError(t, err)
+	assert.Zero(t, j)
+	est, err := EstimateOverlap(a, b, 0.5)
+	assert.NoError(t, err)
+	assert.Zero(t, est.Overlap)
+	_, err = EstimateOverlap(a, b, 0)
+	assert.Error(t, err)
+
+	// hashes are only comparable between quotient filters hashing alike
+	_, err = OverlapCount(a, NewWithConfig(Config{HashAlgorithm: HashXXH3}))
+	assert.Error(t, err)
+	_, err = OverlapCount(a, NewWithConfig(Config{KeyedHash: true}))
+	assert.Error(t, err)
+	_, err = OverlapCount(a, NewWithConfig(Config{IntegerMixer: MixSplitMix64}))
+	assert.Error(t, err)
+
+	// fingerprints keep too little of each hash to be compared
+	fp := NewWithConfig(Config{FingerprintBits: 8})
+	fp.InsertString("k1")
+	_, err = OverlapCount(fp, a)
+	assert.Error(t, err)
+	_, err = Jaccard(a, fp)
+	assert.Error(t, err)
+	_, err = EstimateOverlap(fp, fp, 0.5)
+	assert.Error(t, err)
+}
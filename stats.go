@@ -0,0 +1,162 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"io"
+	"math"
+)
+
+// Stats describes the structure of a quotient filter, and is useful
+// for capacity planning
+type Stats struct {
+	// Size is the number of slots in the quotient filter
+	Size uint64
+	// Entries is the number of occupied slots
+	Entries uint64
+	// LoadFactor is Entries / Size
+	LoadFactor float64
+	// Runs is the number of runs, that is, the number of distinct
+	// quotients present
+	Runs uint64
+	// Clusters is the number of clusters, a cluster being a sequence
+	// of runs in adjacent slots which starts at an unshifted slot
+	Clusters uint64
+	// MaxClusterLength, MeanClusterLength and P99ClusterLength describe
+	// the distribution of cluster lengths in slots
+	MaxClusterLength  uint64
+	MeanClusterLength float64
+	P99ClusterLength  uint64
+	// RunLengthHistogram[n] is the number of runs which are n slots
+	// long
+	RunLengthHistogram []uint64
+	// ShiftedSlots is the number of entries which are not stored in
+	// their home bucket
+	ShiftedSlots uint64
+	// FilterBytes and StorageBytes are the number of bytes used by the
+	// filter (including the block metadata of the rank and select
+	// layout) and the external storage (including any columns)
+	// respectively
+	FilterBytes  uint64
+	StorageBytes uint64
+	// AverageProbeLength is the average number of slots from an entry's
+	// home bucket up to and including the slot which stores it, an
+	// estimate of the cost of a successful lookup
+	AverageProbeLength float64
+}
+
+// Stats scans the quotient filter and reports on its structure
+func (qf *Filter) Stats() Stats {
+	v := qf.view()
+	s := computeStats(&v)
+	for _, vec := range qf.filterVectors() {
+		s.FilterBytes += vectorBytes(vec)
+	}
+	if qf.storage != nil {
+		s.StorageBytes = vectorBytes(qf.storage)
+	}
+	for _, col := range qf.columns {
+		s.StorageBytes += vectorBytes(col)
+	}
+	return s
+}
+
+// Stats scans the quotient filter on disk and reports on its structure
+func (ext *Disk) Stats() Stats {
+	// the scan is sequential, so read through page caches
+	v := ext.view(true)
+	s := computeStats(&v)
+	for _, r := range ext.filterReaders() {
+		s.FilterBytes += r.bytes()
+	}
+	if ext.storageRead != nil {
+		s.StorageBytes = ext.storageRead.bytes()
+	}
+	for _, r := range ext.columnsRead {
+		s.StorageBytes += r.bytes()
+	}
+	return s
+}
+
+// vectorBytes reports the number of bytes of data held by a vector
+func vectorBytes(v Vector) uint64 {
+	switch x := v.(type) {
+	case *unpacked:
+		return uint64(len(*x)) * bytesPerWord
+	case *packed:
+		return uint64(len(x.space)) * bytesPerWord
+	case *blocked:
+		return uint64(len(x.space)) * bytesPerWord
+	case *segmented:
+		return x.words() * bytesPerWord
+	}
+	// a vector of unknown type, measure its serialized size
+	n, _ := v.WriteTo(io.Discard)
+	return uint64(n)
+}
+
+func computeStats(v *slotView) (s Stats) {
+	s.Size = v.size
+	var clusterLengths []uint64
+	var clusterLen, probes uint64
+	endCluster := func() {
+		if clusterLen > 0 {
+			clusterLengths = bump(clusterLengths, clusterLen)
+			s.Clusters++
+			clusterLen = 0
+		}
+	}
+	v.eachRun(func(q, start, end uint64) {
+		length := (end+v.size-start)%v.size + 1
+		if v.rs != nil {
+			// rank and select runs never wrap, but may extend
+			// beyond size into the overflow slots
+			length = end - start + 1
+		}
+		// distance from the home bucket to the start of the run
+		offset := (start + v.size - q) % v.size
+		if start == q {
+			// an unshifted run starts a new cluster
+			endCluster()
+		} else {
+			s.ShiftedSlots++
+		}
+		s.ShiftedSlots += length - 1
+		s.Runs++
+		s.Entries += length
+		s.RunLengthHistogram = bump(s.RunLengthHistogram, length)
+		clusterLen += length
+		probes += length*(offset+1) + length*(length-1)/2
+	})
+	endCluster()
+
+	s.LoadFactor = float64(s.Entries) / float64(s.Size)
+	if s.Clusters > 0 {
+		s.MeanClusterLength = float64(s.Entries) / float64(s.Clusters)
+	}
+	if s.Entries > 0 {
+		s.AverageProbeLength = float64(probes) / float64(s.Entries)
+	}
+	p99 := uint64(math.Ceil(float64(s.Clusters) * 0.99))
+	seen := uint64(0)
+	for length, count := range clusterLengths {
+		if count == 0 {
+			continue
+		}
+		s.MaxClusterLength = uint64(length)
+		if seen < p99 && seen+count >= p99 {
+			s.P99ClusterLength = uint64(length)
+		}
+		seen += count
+	}
+	return
+}
+
+// bump increments hist[n], growing hist as required
+func bump(hist []uint64, n uint64) []uint64 {
+	for uint64(len(hist)) <= n {
+		hist = append(hist, 0)
+	}
+	hist[n]++
+	return hist
+}
@@ -0,0 +1,222 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultEpochBits is the number of bits recording the epoch of
+	// each entry when Config.EpochBits is zero
+	defaultEpochBits = 8
+	// minEpochBits and maxEpochBits bound Config.EpochBits, below
+	// minEpochBits epochs are so long that entries live for much
+	// longer than their TTL
+	minEpochBits = 3
+	maxEpochBits = 32
+)
+
+// expiry records the epoch in which each entry was inserted in the
+// high bits of its storage, see Config.TTL.  Epochs are stored modulo
+// 1<<epochBits, and an entry is live until its age exceeds half that.
+// The full epoch of each entry is recovered from base, the epoch of the
+// last sweep: every entry was inserted less than half the epochs which
+// can be stored either side of it
+type expiry struct {
+	// the bits of storage below the epoch, which hold the value
+	valueBits, epochBits uint
+	// the length of an epoch
+	epoch time.Duration
+	// the epoch of the last sweep, or of the creation of the quotient
+	// filter
+	base  uint64
+	clock func() time.Time
+}
+
+// newExpiry returns the expiry of quotient filters with the given
+// ttl, or nil when it is zero
+func newExpiry(ttl time.Duration, epochBits, valueBits uint, clock func() time.Time) (*expiry, error) {
+	if ttl == 0 {
+		return nil, nil
+	}
+	if epochBits == 0 {
+		epochBits = defaultEpochBits
+	}
+	if epochBits < minEpochBits || epochBits > maxEpochBits || valueBits+epochBits > bitsPerWord {
+		return nil, fmt.Errorf("%d epoch bits are out of range, must be between %d and %d and leave room for %d bits of value",
+			epochBits, minEpochBits, maxEpochBits, valueBits)
+	}
+	epoch := ttl / (1 << (epochBits - 1))
+	if ttl < 0 || epoch <= 0 {
+		return nil, fmt.Errorf("TTL of %s is too short for %d epoch bits", ttl, epochBits)
+	}
+	if clock == nil {
+		clock = time.Now
+	}
+	e := &expiry{valueBits: valueBits, epochBits: epochBits, epoch: epoch, clock: clock}
+	e.base = e.now()
+	return e, nil
+}
+
+// ttl returns the TTL the expiry was created with
+func (e *expiry) ttl() time.Duration {
+	return e.epoch * (1 << (e.epochBits - 1))
+}
+
+// half returns the number of epochs in a TTL, half those which can be
+// stored
+func (e *expiry) half() uint64 {
+	return 1 << (e.epochBits - 1)
+}
+
+// at returns the epoch of t
+func (e *expiry) at(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(e.epoch))
+}
+
+// now returns the current epoch
+func (e *expiry) now() uint64 {
+	return e.at(e.clock())
+}
+
+// inserted returns the epoch in which the entry whose storage holds
+// stored was inserted
+func (e *expiry) inserted(stored uint64) uint64 {
+	lo := e.base - e.half()
+	return lo + (stored>>e.valueBits-lo)&lowMask(uint64(e.epochBits))
+}
+
+// live reports whether the entry whose storage holds stored has not
+// expired by epoch now
+func (e *expiry) live(stored, now uint64) bool {
+	return int64(now-e.inserted(stored)) <= int64(e.half())
+}
+
+// value returns the value held in stored
+func (e *expiry) value(stored uint64) uint64 {
+	return stored & lowMask(uint64(e.valueBits))
+}
+
+// stamp returns the storage of value inserted in epoch now.  Like
+// storage without an epoch, it panics if value is too wide
+func (e *expiry) stamp(value, now uint64) uint64 {
+	if value&^lowMask(uint64(e.valueBits)) != 0 {
+		panic(fmt.Sprintf("attempt to store out of range value.  numeric overflow: %x (%x)", value&^lowMask(uint64(e.valueBits)), value))
+	}
+	return value | (now&lowMask(uint64(e.epochBits)))<<e.valueBits
+}
+
+// due reports whether the quotient filter must be swept before an entry
+// is inserted in epoch now, as inserting it would leave entries more
+// than half the epochs which can be stored either side of base
+func (e *expiry) due(now uint64) bool {
+	return int64(now-e.base) >= int64(e.half())
+}
+
+// swept records a sweep of the entries which expired by epoch now
+func (e *expiry) swept(now uint64) {
+	if int64(now-e.base) > 0 {
+		e.base = now
+	}
+}
+
+// visible adapts the result of a lookup of storage, hiding the entry
+// if it has expired
+func (e *expiry) visible(found bool, stored uint64) (bool, uint64) {
+	if !found || !e.live(stored, e.now()) {
+		return false, 0
+	}
+	return true, e.value(stored)
+}
+
+// Expire removes the entries which have expired by now in one pass
+// over the quotient filter, returning the number removed.  It does
+// nothing unless Config.TTL is set.  Expired entries are already
+// absent to lookups, Expire reclaims their slots in place
+func (qf *Filter) Expire(now time.Time) (removed uint64) {
+	if qf.expiry == nil {
+		return 0
+	}
+	return qf.expire(qf.expiry.at(now))
+}
+
+// expire removes the entries which have expired by epoch now, deleting
+// each in place as the runs are walked in order of quotient.  Deleting
+// only moves the entries which follow it in its cluster, which belong
+// to later runs or to runs wrapped around from the end of the table
+// whose entries are live
+func (qf *Filter) expire(now uint64) (removed uint64) {
+	e := qf.expiry
+	expired := func(slot uint64) bool {
+		stored := qf.storage.Get(slot)
+		if e.live(stored, now) {
+			return false
+		}
+		if qf.config.ValueArena {
+			_, n := arenaSpan(e.value(stored))
+			qf.garbage += n
+		}
+		removed++
+		return true
+	}
+	if rs := qf.rs; rs != nil {
+		// next is the slot following the last run
+		next := uint64(0)
+		for q := uint64(0); ; q++ {
+			if q = rs.nextOccupied(q, qf.size); q == qf.size {
+				break
+			}
+			pos := q
+			if next > pos {
+				pos = next
+			}
+			for {
+				end := rs.runEndFrom(q, pos)
+				if !expired(pos) {
+					if pos == end {
+						next = end + 1
+						break
+					}
+					pos++
+					continue
+				}
+				qf.rsDelete(q, pos)
+				if pos == end {
+					if rs.isOccupied(q) {
+						next = pos
+					}
+					break
+				}
+			}
+		}
+	} else {
+		for q := uint64(0); q < qf.size; q++ {
+			sd := qf.read(q)
+			if !sd.occupied() {
+				continue
+			}
+			s := q
+			if sd.shifted() {
+				s = findStart(q, qf.size, qf.filter.Get)
+			}
+			for {
+				next := s
+				right(&next, qf.size)
+				last := !qf.read(next).continuation()
+				if expired(s) {
+					// the rest of the run moves down into s
+					qf.deleteByHash(q, s)
+				} else {
+					s = next
+				}
+				if last {
+					break
+				}
+			}
+		}
+	}
+	e.swept(now)
+	return removed
+}
@@ -0,0 +1,266 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock which only moves when told to
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestTTL(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		clock := &fakeClock{t: time.Now()}
+		qf := NewWithConfig(Config{
+			BitsOfStoragePerEntry: 10,
+			TTL:                   time.Hour,
+			Clock:                 clock.now,
+			Layout:                layout,
+		})
+		assert.Equal(t, uint(10), qf.BitsOfStoragePerEntry())
+		for i := 0; i < 500; i++ {
+			assert.False(t, qf.InsertWithValue([]byte(fmt.Sprintf("old%d", i)), uint64(i)))
+		}
+		clock.t = clock.t.Add(40 * time.Minute)
+		for i := 0; i < 500; i++ {
+			assert.False(t, qf.InsertWithValue([]byte(fmt.Sprintf("new%d", i)), uint64(500+i)))
+		}
+		found, v := qf.Lookup([]byte("old7"))
+		assert.True(t, found)
+		assert.Equal(t, uint64(7), v)
+		// updating an entry renews it
+		assert.True(t, qf.InsertWithValue([]byte("old8"), 8))
+
+		// the old entries expire within an epoch of their TTL
+		clock.t = clock.t.Add(20*time.Minute + time.Hour/128)
+		assert.False(t, qf.Contains([]byte("old7")))
+		assert.True(t, qf.Contains([]byte("old8")))
+		found, v = qf.Lookup([]byte("new499"))
+		assert.True(t, found)
+		assert.Equal(t, uint64(999), v)
+		found, _ = qf.LookupUint64(1)
+		assert.False(t, found)
+		keys := [][]byte{[]byte("old6"), []byte("new6")}
+		founds, values := make([]bool, 2), make([]uint64, 2)
+		qf.LookupBatch(keys, founds, values)
+		assert.Equal(t, []bool{false, true}, founds)
+		assert.Equal(t, []uint64{0, 506}, values)
+		qf.ContainsBatch(keys, founds)
+		assert.Equal(t, []bool{false, true}, founds)
+
+		// but occupy the quotient filter until swept
+		assert.Equal(t, uint64(1000), qf.Len())
+		assert.Equal(t, uint64(499), qf.Expire(clock.t))
+		assert.Equal(t, uint64(501), qf.Len())
+		assert.NoError(t, qf.Validate())
+		assert.True(t, qf.Contains([]byte("new0")))
+		assert.Zero(t, qf.Expire(clock.t))
+
+		// an expired entry is replaced as though absent
+		assert.False(t, qf.InsertWithValue([]byte("old7"), 77))
+		found, v = qf.Lookup([]byte("old7"))
+		assert.True(t, found)
+		assert.Equal(t, uint64(77), v)
+
+		// doubling drops expired entries too
+		clock.t = clock.t.Add(90 * time.Minute)
+		capacity, newer := qf.Capacity(), uint64(0)
+		for ; qf.Capacity() == capacity; newer++ {
+			qf.InsertString(fmt.Sprintf("newer%d", newer))
+		}
+		assert.Equal(t, newer, qf.Len())
+		assert.NoError(t, qf.Validate())
+		assert.False(t, qf.Contains([]byte("new0")))
+	}
+}
+
+func TestTTLIntegerKeys(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8, TTL: time.Minute, EpochBits: 4, Clock: clock.now})
+	qf.InsertUint64WithValue(1, 0xff)
+	clock.t = clock.t.Add(50 * time.Second)
+	qf.InsertUint64WithValue(2, 2)
+	clock.t = clock.t.Add(20 * time.Second)
+	ids := map[uint64]uint64{}
+	qf.EachUint64(func(id, value uint64) { ids[id] = value })
+	assert.Equal(t, map[uint64]uint64{2: 2}, ids)
+	// values too wide for their bits are refused, as by packed storage,
+	// rather than overwriting the epoch
+	assert.Panics(t, func() { qf.InsertUint64WithValue(3, 0x1ff) })
+	assert.False(t, qf.ContainsUint64(3))
+	assert.Panics(t, func() {
+		NewWithConfig(Config{BitsOfStoragePerEntry: 8, BitPacked: true}).InsertUint64WithValue(3, 0x1ff)
+	})
+}
+
+// epochs wrap around, and the filter is neither swept nor doubled
+func TestTTLWithoutExpire(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		clock := &fakeClock{t: time.Now()}
+		qf := NewWithConfig(Config{BitsOfStoragePerEntry: 8, TTL: time.Hour, Clock: clock.now, Layout: layout})
+		qf.InsertStringWithValue("k", 1)
+		name, err := writeQFToTempFile(qf)
+		assert.NoError(t, err)
+		ext, err := OpenReadOnlyFromPath(name)
+		assert.NoError(t, err)
+		ext.expiry.clock = clock.now
+		// every minute for more than twice the 256 epochs which can be
+		// stored, so that the stored epoch of k comes around again
+		for m := 1; m < 600; m++ {
+			clock.t = clock.t.Add(time.Minute)
+			live := m <= 60
+			assert.Equal(t, live, qf.ContainsString("k"), "%d minutes", m)
+			assert.Equal(t, live, ext.ContainsString("k"), "%d minutes", m)
+		}
+		ext.Close()
+		os.Remove(name)
+
+		// a sweep is made by the first insertion a TTL after the last
+		assert.Equal(t, uint64(1), qf.Len())
+		qf.InsertStringWithValue("j", 2)
+		assert.Equal(t, uint64(1), qf.Len())
+		assert.NoError(t, qf.Validate())
+		for m := 1; m < 600; m++ {
+			clock.t = clock.t.Add(time.Minute)
+			if m%50 == 0 {
+				// insertions every 50 minutes refresh j
+				qf.InsertStringWithValue("j", uint64(m%256))
+			}
+			assert.True(t, qf.ContainsString("j"), "%d minutes", m)
+			assert.False(t, qf.ContainsString("k"), "%d minutes", m)
+		}
+		assert.Equal(t, uint64(1), qf.Len())
+	}
+}
+
+// expiring in place removes expired entries wherever they are in their
+// runs and clusters, including those wrapping around the table
+func TestExpireInPlace(t *testing.T) {
+	for _, layout := range []Layout{LayoutClassic, LayoutRankSelect} {
+		r := rand.New(rand.NewSource(3))
+		clock := &fakeClock{t: time.Now()}
+		qf := NewWithConfig(Config{
+			BitsOfStoragePerEntry: 8, TTL: time.Hour, Clock: clock.now,
+			ExpectedEntries: 200, LoadFactor: 0.9, FixedCapacity: true, Layout: layout,
+			Columns: []Column{{Name: "c", Bits: 16}},
+		})
+		// the round in which each entry was last inserted
+		rounds := map[uint64]int{}
+		for round := 0; round < 20; round++ {
+			// the entries of the round before last expire
+			clock.t = clock.t.Add(40 * time.Minute)
+			n := qf.Len()
+			for hv, inserted := range rounds {
+				if inserted < round-1 {
+					delete(rounds, hv)
+				}
+			}
+			assert.Equal(t, n-uint64(len(rounds)), qf.Expire(clock.t))
+			if !assert.NoError(t, qf.Validate(), "round %d", round) {
+				return
+			}
+			assert.Equal(t, uint64(len(rounds)), qf.Len())
+			for hv, inserted := range rounds {
+				found, slot := qf.entrySlot(hv)
+				assert.True(t, found, "%x missing", hv)
+				assert.Equal(t, uint64(inserted), qf.storage.Get(slot)&0xff)
+				assert.Equal(t, hv&0xffff, qf.columns[0].Get(slot))
+			}
+
+			for i := 0; i < 100; i++ {
+				// few quotients, many at the end of the table
+				q := qf.size - 1 - uint64(r.Intn(20))
+				if r.Intn(3) == 0 {
+					q = uint64(r.Intn(int(qf.size)))
+				}
+				hv := q<<qf.rBits | uint64(r.Intn(1<<16))
+				qf.InsertRawHash(hv, uint64(round))
+				rounds[hv] = round
+				_, slot := qf.entrySlot(hv)
+				qf.columns[0].Set(slot, hv&0xffff)
+			}
+		}
+	}
+}
+
+func TestTTLPersists(t *testing.T) {
+	clock := &fakeClock{t: time.Now().Add(-90 * time.Second)}
+	c := Config{BitsOfStoragePerEntry: 6, TTL: time.Minute, EpochBits: 12, Clock: clock.now}
+	qf := NewWithConfig(c)
+	qf.InsertStringWithValue("stale", 1)
+	clock.t = time.Now()
+	qf.InsertStringWithValue("fresh", 2)
+	qf.InsertUint64WithValue(7, 3)
+	// BuildParallel stamps entries too
+	built, err := BuildParallel(c, [][]byte{[]byte("built")}, []uint64{4}, 1)
+	assert.NoError(t, err)
+	found, v := built.LookupString("built")
+	assert.True(t, found)
+	assert.Equal(t, uint64(4), v)
+
+	var buf bytes.Buffer
+	_, err = qf.WriteTo(&buf)
+	assert.NoError(t, err)
+	cpy := Filter{config: Config{Clock: clock.now}}
+	_, err = cpy.ReadFrom(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, cpy.config.TTL)
+	assert.Equal(t, uint(6), cpy.BitsOfStoragePerEntry())
+
+	name, err := writeQFToTempFile(qf)
+	assert.NoError(t, err)
+	defer os.Remove(name)
+	ext, err := OpenReadOnlyFromPath(name)
+	assert.NoError(t, err)
+	defer ext.Close()
+	assert.Equal(t, uint(6), ext.BitsOfStoragePerEntry())
+	h, err := ReadHeaderFromPath(name)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(18), h.StorageBits)
+	assert.Equal(t, uint64(12), h.EpochBits)
+
+	for _, r := range []Reader{&cpy, ext} {
+		found, v := r.LookupString("fresh")
+		assert.True(t, found)
+		assert.Equal(t, uint64(2), v)
+		assert.False(t, r.ContainsString("stale"))
+		found, v = r.LookupUint64(7)
+		assert.True(t, found)
+		assert.Equal(t, uint64(3), v)
+		founds, values := make([]bool, 2), make([]uint64, 2)
+		r.LookupBatch([][]byte{[]byte("stale"), []byte("fresh")}, founds, values)
+		assert.Equal(t, []bool{false, true}, founds)
+		assert.Equal(t, []uint64{0, 2}, values)
+	}
+	ids := 0
+	ext.EachUint64(func(id, value uint64) { ids++ })
+	assert.Equal(t, 2, ids)
+}
+
+func TestTTLConfig(t *testing.T) {
+	assert.Panics(t, func() { NewWithConfig(Config{TTL: time.Hour, EpochBits: 2}) })
+	assert.Panics(t, func() { NewWithConfig(Config{TTL: time.Hour, EpochBits: 40}) })
+	assert.Panics(t, func() { NewWithConfig(Config{TTL: time.Hour, BitsOfStoragePerEntry: 60}) })
+	assert.Panics(t, func() { NewWithConfig(Config{TTL: 100}) })
+	assert.Panics(t, func() { NewWithConfig(Config{TTL: -time.Hour}) })
+	// storage for the epoch is allocated even without values
+	qf := NewWithConfig(Config{TTL: time.Hour})
+	assert.Zero(t, qf.BitsOfStoragePerEntry())
+	qf.InsertString("x")
+	assert.True(t, qf.ContainsString("x"))
+	assert.Zero(t, New().Expire(time.Now()))
+}
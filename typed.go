@@ -0,0 +1,215 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"unsafe"
+)
+
+// KeyCodec encodes keys of type K into the bytes hashed by a quotient
+// filter.  Distinct keys must have distinct encodings
+type KeyCodec[K any] interface {
+	// AppendKey appends the encoding of k to dst and returns the
+	// extended slice
+	AppendKey(dst []byte, k K) []byte
+}
+
+// IntegerKeyCodec may be implemented by a KeyCodec whose keys are 64
+// bit integers, which are then stored as integer keys (see
+// Filter.InsertUint64) without being encoded
+type IntegerKeyCodec[K any] interface {
+	KeyCodec[K]
+	KeyUint64(k K) uint64
+}
+
+// TypedValue encodes values of type V into the external storage of a
+// quotient filter
+type TypedValue[V any] interface {
+	// Bits reports the number of bits of storage required per entry
+	Bits() uint
+	// Encode returns the storage for v, which must fit in Bits bits
+	Encode(v V) uint64
+	// Decode inverts Encode
+	Decode(x uint64) V
+}
+
+// integer is the set of types handled by IntegerKey
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// unsignedInteger is the set of types handled by UintValue
+type unsignedInteger interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// StringKey encodes string keys as their bytes
+type StringKey struct{}
+
+func (StringKey) AppendKey(dst []byte, k string) []byte {
+	return append(dst, k...)
+}
+
+// IntegerKey stores integer keys as integer keys, hashed by the
+// configured IntegerMixer
+type IntegerKey[K integer] struct{}
+
+func (IntegerKey[K]) AppendKey(dst []byte, k K) []byte {
+	return binary.LittleEndian.AppendUint64(dst, uint64(k))
+}
+
+func (IntegerKey[K]) KeyUint64(k K) uint64 {
+	return uint64(k)
+}
+
+// UUIDKey encodes 16 byte keys such as UUIDs
+type UUIDKey struct{}
+
+func (UUIDKey) AppendKey(dst []byte, k [16]byte) []byte {
+	return append(dst, k[:]...)
+}
+
+// AddrKey encodes IP addresses.  As with netip.Addr equality, an IPv4
+// address and the same address mapped into IPv6 are distinct keys, as
+// are addresses differing only in their zone
+type AddrKey struct{}
+
+func (AddrKey) AppendKey(dst []byte, k netip.Addr) []byte {
+	switch {
+	case k.Is4():
+		a := k.As4()
+		return append(dst, a[:]...)
+	case k.Is6():
+		a := k.As16()
+		return append(append(dst, a[:]...), k.Zone()...)
+	}
+	// the zero Addr
+	return dst
+}
+
+// NoValue is the TypedValue of a typed quotient filter with no external
+// storage
+type NoValue struct{}
+
+func (NoValue) Bits() uint             { return 0 }
+func (NoValue) Encode(struct{}) uint64 { return 0 }
+func (NoValue) Decode(uint64) struct{} { return struct{}{} }
+
+// BoolValue stores a boolean in a single bit
+type BoolValue struct{}
+
+func (BoolValue) Bits() uint { return 1 }
+
+func (BoolValue) Encode(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func (BoolValue) Decode(x uint64) bool { return x != 0 }
+
+// UintValue stores unsigned integers in as many bits as their type
+type UintValue[V unsignedInteger] struct{}
+
+func (UintValue[V]) Bits() uint {
+	var v V
+	return uint(unsafe.Sizeof(v)) * 8
+}
+
+func (UintValue[V]) Encode(v V) uint64 { return uint64(v) }
+func (UintValue[V]) Decode(x uint64) V { return V(x) }
+
+// TypedReader looks up keys of type K, and their values of type V, in a
+// quotient filter in ram or on disk
+type TypedReader[K, V any] struct {
+	r      Reader
+	keys   KeyCodec[K]
+	ints   IntegerKeyCodec[K]
+	values TypedValue[V]
+}
+
+// NewTypedReader wraps r, which must have been populated through a
+// Typed quotient filter with the same codecs
+func NewTypedReader[K, V any](r Reader, keys KeyCodec[K], values TypedValue[V]) *TypedReader[K, V] {
+	if bits := values.Bits(); bits > r.BitsOfStoragePerEntry() {
+		panic(fmt.Sprintf("%d bit values can't be read from a quotient filter with %d bits of storage",
+			bits, r.BitsOfStoragePerEntry()))
+	}
+	t := &TypedReader[K, V]{r: r, keys: keys, values: values}
+	t.ints, _ = keys.(IntegerKeyCodec[K])
+	return t
+}
+
+// Len reports the number of entries in the quotient filter
+func (t *TypedReader[K, V]) Len() uint64 {
+	return t.r.Len()
+}
+
+// Contains returns whether k is contained within the quotient filter
+func (t *TypedReader[K, V]) Contains(k K) bool {
+	_, found := t.Lookup(k)
+	return found
+}
+
+// Lookup searches for k and returns the value stored with it and
+// whether it exists.  The zero V is returned when it does not
+func (t *TypedReader[K, V]) Lookup(k K) (v V, found bool) {
+	var x uint64
+	if t.ints != nil {
+		found, x = t.r.LookupUint64(t.ints.KeyUint64(k))
+	} else {
+		var buf [64]byte
+		found, x = t.r.Lookup(t.keys.AppendKey(buf[:0], k))
+	}
+	if found {
+		v = t.values.Decode(x)
+	}
+	return
+}
+
+// Typed is a quotient filter of keys of type K with values of type V,
+// built on Filter
+type Typed[K, V any] struct {
+	TypedReader[K, V]
+	qf *Filter
+}
+
+// NewTyped allocates a typed quotient filter, c.BitsOfStoragePerEntry
+// is set from values
+func NewTyped[K, V any](c Config, keys KeyCodec[K], values TypedValue[V]) *Typed[K, V] {
+	c.BitsOfStoragePerEntry = values.Bits()
+	qf := NewWithConfig(c)
+	return &Typed[K, V]{*NewTypedReader[K, V](qf, keys, values), qf}
+}
+
+// Filter returns the underlying quotient filter, for instance to write
+// it out.  Keys must only be inserted through t
+func (t *Typed[K, V]) Filter() *Filter {
+	return t.qf
+}
+
+// Insert stores k and v in the quotient filter, returning whether k
+// already existed.  Like Filter.InsertWithValue, it panics with
+// ErrFilterFull if a quotient filter of fixed capacity is full
+func (t *Typed[K, V]) Insert(k K, v V) (update bool) {
+	update, err := t.TryInsert(k, v)
+	if err != nil {
+		panic(err)
+	}
+	return update
+}
+
+// TryInsert is like Insert, see Filter.TryInsertWithValue
+func (t *Typed[K, V]) TryInsert(k K, v V) (update bool, err error) {
+	x := t.values.Encode(v)
+	if t.ints != nil {
+		return t.qf.TryInsertUint64WithValue(t.ints.KeyUint64(k), x)
+	}
+	var buf [64]byte
+	return t.qf.TryInsertWithValue(t.keys.AppendKey(buf[:0], k), x)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedStrings(t *testing.T) {
+	f := NewTyped[string, uint16](Config{}, StringKey{}, UintValue[uint16]{})
+	assert.Equal(t, uint(16), f.Filter().BitsOfStoragePerEntry())
+	for i := 0; i < 1000; i++ {
+		assert.False(t, f.Insert(fmt.Sprintf("key%d", i), uint16(i)))
+	}
+	assert.True(t, f.Insert("key7", 7777))
+	assert.Equal(t, uint64(1000), f.Len())
+	v, found := f.Lookup("key7")
+	assert.True(t, found)
+	assert.Equal(t, uint16(7777), v)
+	v, found = f.Lookup("key999")
+	assert.True(t, found)
+	assert.Equal(t, uint16(999), v)
+	v, found = f.Lookup("nope")
+	assert.False(t, found)
+	assert.Zero(t, v)
+	// typed string keys are plain byte keys
+	assert.True(t, f.Filter().ContainsString("key1"))
+
+	// and may be read back from disk
+	name, err := writeQFToTempFile(f.Filter())
+	assert.NoError(t, err)
+	defer os.Remove(name)
+	ext, err := OpenReadOnlyFromPath(name)
+	assert.NoError(t, err)
+	defer ext.Close()
+	r := NewTypedReader[string, uint16](ext, StringKey{}, UintValue[uint16]{})
+	v, found = r.Lookup("key42")
+	assert.True(t, found)
+	assert.Equal(t, uint16(42), v)
+	assert.False(t, r.Contains("nope"))
+	assert.Panics(t, func() { NewTypedReader[string, uint64](ext, StringKey{}, UintValue[uint64]{}) })
+}
+
+func TestTypedKeys(t *testing.T) {
+	ints := NewTyped[int32, bool](Config{}, IntegerKey[int32]{}, BoolValue{})
+	ints.Insert(-5, true)
+	ints.Insert(5, false)
+	v, found := ints.Lookup(-5)
+	assert.True(t, found)
+	assert.True(t, v)
+	v, found = ints.Lookup(5)
+	assert.True(t, found)
+	assert.False(t, v)
+	assert.False(t, ints.Contains(6))
+	// integer keys use the integer fast path
+	assert.True(t, ints.Filter().ContainsUint64(5))
+	ids := map[uint64]bool{}
+	ints.Filter().EachUint64(func(id, _ uint64) { ids[id] = true })
+	assert.Equal(t, map[uint64]bool{5: true, uint64(0xfffffffffffffffb): true}, ids)
+
+	uuids := NewTyped[[16]byte, struct{}](Config{}, UUIDKey{}, NoValue{})
+	a, b := [16]byte{1, 2, 3}, [16]byte{1, 2, 4}
+	uuids.Insert(a, struct{}{})
+	assert.True(t, uuids.Contains(a))
+	assert.False(t, uuids.Contains(b))
+	assert.Zero(t, uuids.Filter().BitsOfStoragePerEntry())
+
+	addrs := NewTyped[netip.Addr, struct{}](Config{}, AddrKey{}, NoValue{})
+	v4 := netip.MustParseAddr("10.1.2.3")
+	addrs.Insert(v4, struct{}{})
+	addrs.Insert(netip.MustParseAddr("fe80::1%eth0"), struct{}{})
+	assert.True(t, addrs.Contains(netip.MustParseAddr("10.1.2.3")))
+	assert.False(t, addrs.Contains(netip.AddrFrom16(v4.As16())))
+	assert.True(t, addrs.Contains(netip.MustParseAddr("fe80::1%eth0")))
+	assert.False(t, addrs.Contains(netip.MustParseAddr("fe80::1")))
+	assert.False(t, addrs.Contains(netip.Addr{}))
+}
@@ -37,6 +37,12 @@ func (v *unpacked) Get(ix uint64) (val uint64) {
 	return (*v)[ix]
 }
 
+func (v *unpacked) ShiftRight(from, to uint64) {
+	if to > from {
+		copy((*v)[from+1:to+1], (*v)[from:to])
+	}
+}
+
 // unpacked format on disk is:
 // 64 bit len
 // len x 64 bit unsigned integers
@@ -70,6 +76,15 @@ func initUnpackedDiskReader(rdr *os.File) (*unpackedDiskReader, error) {
 	return &unpackedDiskReader{rdr, uint64(cur), uint64(sz)}, err
 }
 
+func (r unpackedDiskReader) withReaderAt(ra io.ReaderAt) extReader {
+	r.r = ra
+	return r
+}
+
+func (r unpackedDiskReader) bytes() uint64 {
+	return r.size * bytesPerWord
+}
+
 func (r unpackedDiskReader) Read(ix uint64) (val uint64, err error) {
 	var data [8]byte
 	off := int64(ix*8 + r.start)
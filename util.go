@@ -4,7 +4,9 @@ package qf
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math/bits"
 	"unsafe"
 )
 
@@ -25,7 +27,13 @@ func writeUintSlice(w io.Writer, v []uint64) (n int64, err error) {
 	if err = binary.Write(w, binary.LittleEndian, uint64(len(v))); err != nil {
 		return
 	}
+	n, err = writeUintWords(w, v)
 	n += 8
+	return
+}
+
+// writeUintWords is like writeUintSlice, without the length prefix
+func writeUintWords(w io.Writer, v []uint64) (n int64, err error) {
 	if isLittleEndian {
 		// ~12x faster
 		data := unsafeUint64SliceToBytes(v)
@@ -42,6 +50,14 @@ func writeUintSlice(w io.Writer, v []uint64) (n int64, err error) {
 }
 
 func readUintSlice(r io.Reader) (v []uint64, n int64, err error) {
+	return readUintSliceInto(r, func(length uint64) []uint64 {
+		return make(unpacked, length)
+	})
+}
+
+// readUintSliceInto is like readUintSlice, reading into a slice
+// allocated by alloc
+func readUintSliceInto(r io.Reader, alloc func(length uint64) []uint64) (v []uint64, n int64, err error) {
 	// read length
 	var length uint64
 	err = binary.Read(r, binary.LittleEndian, &length)
@@ -49,12 +65,12 @@ func readUintSlice(r io.Reader) (v []uint64, n int64, err error) {
 		return
 	}
 	n += 8
-	v = make(unpacked, length)
+	v = alloc(length)
 	if isLittleEndian {
 		// ~15x faster
 		data := unsafeUint64SliceToBytes(v)
 		var np int
-		np, err = r.Read(data)
+		np, err = io.ReadFull(r, data)
 		n += int64(np)
 	} else {
 		err = binary.Read(r, binary.LittleEndian, v)
@@ -65,3 +81,23 @@ func readUintSlice(r io.Reader) (v []uint64, n int64, err error) {
 	}
 	return
 }
+
+// divisor divides by a constant d > 1 using a multiplication by its
+// reciprocal, which is considerably cheaper than a division
+type divisor struct {
+	d, magic uint64
+}
+
+func newDivisor(d uint64) divisor {
+	if d < 2 {
+		panic(fmt.Sprintf("internal inconsistency: divisor of %d", d))
+	}
+	// floor(2^64/d)+1 divides exactly for any x below 2^64/d, which
+	// is larger than any addressable vector
+	return divisor{d, ^uint64(0)/d + 1}
+}
+
+func (d divisor) div(x uint64) uint64 {
+	q, _ := bits.Mul64(x, d.magic)
+	return q
+}
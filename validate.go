@@ -0,0 +1,149 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import "fmt"
+
+// CorruptionError describes an inconsistency in the structure of a
+// quotient filter found by Validate
+type CorruptionError struct {
+	// Slot is the slot at which the inconsistency was detected
+	Slot uint64
+	// Reason describes the inconsistency
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupt quotient filter at slot %d: %s", e.Slot, e.Reason)
+}
+
+func corrupt(slot uint64, format string, args ...interface{}) error {
+	return &CorruptionError{Slot: slot, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validate verifies the structural invariants of the quotient filter,
+// returning a *CorruptionError describing the first inconsistent slot,
+// or an error if the number of entries found does not match the number
+// recorded
+func (qf *Filter) Validate() error {
+	if qf.rs != nil {
+		return rsValidate(&qf.rs.rsReader, qf.size, qf.entries, qf.multisetValues())
+	}
+	return validate(qf.size, qf.entries, qf.filter.Get, qf.multisetValues())
+}
+
+// Validate verifies the structural invariants of the quotient filter
+// on disk, see Filter.Validate
+func (ext *Disk) Validate() error {
+	if ext.rsRead != nil {
+		// i/o errors panic, and are recovered by rsValidate
+		v := ext.view(true)
+		return rsValidate(v.rs, ext.size, ext.entries, ext.multisetValues(v))
+	}
+	// remember the first i/o error rather than panicking, a corrupt
+	// file may well be truncated
+	var rerr error
+	rdr := ext.filterRead.withReaderAt(&pageCache{r: ext.f})
+	read := func(ix uint64) uint64 {
+		v, err := rdr.Read(ix)
+		if err != nil && rerr == nil {
+			rerr = fmt.Errorf("reading slot %d: %w", ix, err)
+		}
+		return v
+	}
+	err := validate(ext.size, ext.entries, read, ext.multisetValues(ext.view(true)))
+	if rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// multisetValues returns the storage of a multiset, which orders the
+// entries with equal remainders, or nil
+func (qf *Filter) multisetValues() readFn {
+	if !qf.config.Multiset {
+		return nil
+	}
+	return qf.storage.Get
+}
+
+// multisetValues returns the storage read through v of a multiset, or
+// nil
+func (ext *Disk) multisetValues(v slotView) readFn {
+	if !ext.multiset {
+		return nil
+	}
+	return v.storage
+}
+
+// inOrder reports whether the entry at slot, with remainder r, follows
+// that at prev, with remainder pr, in a run.  Entries with equal
+// remainders are ordered by their values when values is set
+func inOrder(r, pr, slot, prev uint64, values readFn) bool {
+	if r == pr && values != nil {
+		return values(slot) > values(prev)
+	}
+	return r > pr
+}
+
+func validate(size, entries uint64, read, values readFn) error {
+	// let's start from an unshifted slot
+	start := uint64(0)
+	for n := uint64(0); slotData(read(start)).shifted(); n++ {
+		if n == size {
+			return corrupt(start, "every slot is shifted")
+		}
+		right(&start, size)
+	}
+
+	// a queue of quotients for which runs are pending
+	queue := []uint64{}
+	count := uint64(0)
+	var prev slotData
+	i, prevSlot := start, start
+	for n := uint64(0); n < size; n++ {
+		sd := slotData(read(i))
+		if sd.continuation() {
+			if !sd.shifted() {
+				return corrupt(i, "continuation of a run is not shifted")
+			}
+			if prev.empty() {
+				return corrupt(i, "continuation follows an empty slot")
+			}
+			if !inOrder(sd.r(), prev.r(), i, prevSlot, values) {
+				return corrupt(i, "remainder %x is not greater than its predecessor %x in the run",
+					sd.r(), prev.r())
+			}
+		} else if len(queue) > 0 {
+			queue = queue[1:]
+		}
+		if sd.empty() && len(queue) > 0 {
+			return corrupt(queue[0], "quotient is occupied but has no run")
+		}
+		if sd.occupied() {
+			queue = append(queue, i)
+		}
+		if !sd.empty() {
+			count++
+			if len(queue) == 0 {
+				return corrupt(i, "run starts without an occupied quotient")
+			}
+			home := queue[0]
+			if sd.shifted() && home == i {
+				return corrupt(i, "entry in its home bucket is marked shifted")
+			} else if !sd.shifted() && home != i {
+				return corrupt(i, "entry for quotient %d is not marked shifted", home)
+			}
+		}
+		prev, prevSlot = sd, i
+		right(&i, size)
+	}
+	// we've wrapped back around to start, which begins a new run
+	if len(queue) > 1 {
+		return corrupt(queue[1], "quotient is occupied but has no run")
+	}
+	if count != entries {
+		return fmt.Errorf("corrupt quotient filter: %d entries recorded, %d found", entries, count)
+	}
+	return nil
+}
@@ -23,3 +23,43 @@ type Vector interface {
 	io.WriterTo
 	io.ReaderFrom
 }
+
+// Shifter may be implemented by a Vector which can move a range of
+// elements more efficiently than one at a time.  Vectors which don't
+// implement it are shifted element by element
+type Shifter interface {
+	// ShiftRight moves elements [from, to) up one position, to
+	// [from+1, to].  Element from is left unchanged
+	ShiftRight(from, to uint64)
+}
+
+// shiftRight moves elements [from, to) of v up one position, see Shifter
+func shiftRight(v Vector, from, to uint64) {
+	if s, ok := v.(Shifter); ok {
+		s.ShiftRight(from, to)
+		return
+	}
+	for ix := to; ix > from; ix-- {
+		v.Set(ix, v.Get(ix-1))
+	}
+}
+
+// shiftLeft moves elements (from, to] of v down one position, to
+// [from, to).  Element to is left unchanged
+func shiftLeft(v Vector, from, to uint64) {
+	for ix := from; ix < to; ix++ {
+		v.Set(ix, v.Get(ix+1))
+	}
+}
+
+// shiftRightWrap is like shiftRight but for a circular range of a
+// vector of size elements, which wraps when to is less than from
+func shiftRightWrap(v Vector, from, to, size uint64) {
+	if to >= from {
+		shiftRight(v, from, to)
+		return
+	}
+	shiftRight(v, 0, to)
+	v.Set(0, v.Get(size-1))
+	shiftRight(v, from, size-1)
+}
@@ -0,0 +1,230 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// windowVersion is the version of the serialized WindowFilter, which
+// is followed by each of its quotient filters
+const windowVersion = uint64(0x0001)
+
+// WindowFilter remembers keys for a sliding window of time, such as
+// the last 24 hours.  The window is divided into a ring of buckets,
+// each a Filter holding the keys inserted during its span of time.
+// Keys are inserted into the newest bucket, looked up in all of them,
+// and as time advances the oldest bucket is dropped and its space
+// reused for the next.  A key is therefore remembered for between the
+// window less the span of one bucket and the whole window
+type WindowFilter struct {
+	config Config
+	clock  func() time.Time
+	// the span of each bucket
+	width time.Duration
+	// buckets[i] holds the keys inserted during the span e, where
+	// e%len(buckets) == i, of the spans newest-len(buckets)+1 .. newest
+	buckets []*Filter
+	newest  int64
+}
+
+// NewWindowFilter allocates a WindowFilter remembering keys for window,
+// divided into the given number of buckets.  Each bucket is allocated
+// with c, which is shared by all so that keys hash alike, and time is
+// read from c.Clock
+func NewWindowFilter(c Config, window time.Duration, buckets int) *WindowFilter {
+	if buckets < 1 || window/time.Duration(buckets) <= 0 {
+		panic(fmt.Sprintf("can't divide a window of %s into %d buckets", window, buckets))
+	}
+	first := NewWithConfig(c)
+	w := &WindowFilter{
+		// the hash key generated for the first bucket is used for all
+		config:  first.config,
+		width:   window / time.Duration(buckets),
+		buckets: make([]*Filter, buckets),
+	}
+	w.init()
+	w.newest = w.span(w.clock())
+	for i := range w.buckets {
+		w.buckets[i] = NewWithConfig(w.config)
+	}
+	w.buckets[w.slot(w.newest)] = first
+	return w
+}
+
+// init sets up what is derived from the configuration
+func (w *WindowFilter) init() {
+	w.clock = w.config.Clock
+	if w.clock == nil {
+		w.clock = time.Now
+	}
+}
+
+// span returns the span of time t falls in
+func (w *WindowFilter) span(t time.Time) int64 {
+	ns, width := t.UnixNano(), int64(w.width)
+	if ns < 0 {
+		// round towards negative infinity
+		return (ns - width + 1) / width
+	}
+	return ns / width
+}
+
+// slot returns the position in the ring of the bucket of span e
+func (w *WindowFilter) slot(e int64) int {
+	n := int64(len(w.buckets))
+	return int(((e % n) + n) % n)
+}
+
+// advance drops the buckets which have fallen out of the window as of
+// the current time, and returns the newest bucket.  Should the clock go
+// backwards, keys continue to be inserted into the newest bucket
+func (w *WindowFilter) advance() *Filter {
+	now := w.span(w.clock())
+	if now > w.newest {
+		dropped := now - w.newest
+		if dropped > int64(len(w.buckets)) {
+			dropped = int64(len(w.buckets))
+		}
+		for e := now - dropped + 1; e <= now; e++ {
+			w.buckets[w.slot(e)] = NewWithConfig(w.config)
+		}
+		w.newest = now
+	}
+	return w.buckets[w.slot(w.newest)]
+}
+
+// Window returns the span of time keys are remembered for
+func (w *WindowFilter) Window() time.Duration {
+	return w.width * time.Duration(len(w.buckets))
+}
+
+// Len returns the number of entries in the live buckets, a key
+// inserted into more than one bucket is counted in each
+func (w *WindowFilter) Len() (n uint64) {
+	w.advance()
+	for _, b := range w.buckets {
+		n += b.Len()
+	}
+	return
+}
+
+// Insert stores the key (byte slice) in the newest bucket, renewing it
+// if it is already present, and returns whether it was already present
+// within the window.  Like Filter.Insert, it panics with ErrFilterFull
+// if the quotient filters have a fixed capacity and the newest is full
+func (w *WindowFilter) Insert(key []byte) (seen bool) {
+	newest := w.advance()
+	hv := newest.hasher.sum(key)
+	seen = w.containsHash(hv)
+	newest.InsertRawHash(hv, 0)
+	return seen
+}
+
+// InsertString stores the string key in the newest bucket, see Insert
+func (w *WindowFilter) InsertString(key string) (seen bool) {
+	return w.Insert(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// Contains returns whether the key (byte slice) was inserted within
+// the window
+func (w *WindowFilter) Contains(key []byte) bool {
+	newest := w.advance()
+	return w.containsHash(newest.hasher.sum(key))
+}
+
+// ContainsString returns whether the string key was inserted within
+// the window
+func (w *WindowFilter) ContainsString(key string) bool {
+	return w.Contains(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// containsHash returns whether any bucket contains hv, searching from
+// the newest as recently inserted keys are the most likely repeats
+func (w *WindowFilter) containsHash(hv uint64) bool {
+	for e := w.newest; e > w.newest-int64(len(w.buckets)); e-- {
+		if found, _ := w.buckets[w.slot(e)].LookupRawHash(hv); found {
+			return true
+		}
+	}
+	return false
+}
+
+// windowHeader describes a serialized WindowFilter
+type windowHeader struct {
+	Version uint64
+	// the number of buckets, and the span of each in nanoseconds
+	Buckets uint64
+	Width   int64
+	// the span of the newest bucket, the buckets follow from the
+	// oldest to the newest
+	Newest int64
+}
+
+// WriteTo writes the WindowFilter, including all of its buckets, to a
+// stream, see Filter.WriteTo
+func (w *WindowFilter) WriteTo(stream io.Writer) (i int64, err error) {
+	h := windowHeader{
+		Version: windowVersion,
+		Buckets: uint64(len(w.buckets)),
+		Width:   int64(w.width),
+		Newest:  w.newest,
+	}
+	if err = binary.Write(stream, binary.LittleEndian, h); err != nil {
+		return
+	}
+	i += int64(unsafe.Sizeof(h))
+	for e := w.newest - int64(len(w.buckets)) + 1; e <= w.newest; e++ {
+		x, err := w.buckets[w.slot(e)].WriteTo(stream)
+		i += x
+		if err != nil {
+			return i, err
+		}
+	}
+	return
+}
+
+// ReadFrom reads a WindowFilter written by WriteTo from a stream.  As
+// with Filter.ReadFrom, the HashFn and Clock of a WindowFilter from
+// NewWindowFilter are kept.  Buckets which have fallen out of the
+// window since it was written are dropped, so that a restarted process
+// resumes the same window
+func (w *WindowFilter) ReadFrom(stream io.Reader) (i int64, err error) {
+	var h windowHeader
+	if err = binary.Read(stream, binary.LittleEndian, &h); err != nil {
+		return
+	}
+	i += int64(unsafe.Sizeof(h))
+	if h.Version != windowVersion {
+		return i, fmt.Errorf("incompatible window filter format: version is %d, expected %d",
+			h.Version, windowVersion)
+	}
+	if h.Buckets < 1 || h.Width <= 0 {
+		return i, fmt.Errorf("invalid window filter format, %d buckets of %s", h.Buckets, time.Duration(h.Width))
+	}
+	cpy := WindowFilter{
+		width:   time.Duration(h.Width),
+		buckets: make([]*Filter, h.Buckets),
+		newest:  h.Newest,
+	}
+	for e := cpy.newest - int64(len(cpy.buckets)) + 1; e <= cpy.newest; e++ {
+		b := &Filter{config: Config{HashFn: w.config.HashFn, Clock: w.config.Clock}}
+		x, err := b.ReadFrom(stream)
+		i += x
+		if err != nil {
+			return i, err
+		}
+		cpy.buckets[cpy.slot(e)] = b
+	}
+	// new buckets are created with the configuration the buckets were
+	// written with, so far as it is serialized
+	cpy.config = cpy.buckets[0].config
+	cpy.init()
+	cpy.advance()
+	*w = cpy
+	return
+}
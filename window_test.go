@@ -0,0 +1,86 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowFilter(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	w := NewWindowFilter(Config{Clock: clock.now, KeyedHash: true}, 24*time.Hour, 4)
+	assert.Equal(t, 24*time.Hour, w.Window())
+	for i := 0; i < 1000; i++ {
+		assert.False(t, w.InsertString(fmt.Sprintf("a%d", i)))
+	}
+	assert.True(t, w.InsertString("a1"))
+	assert.Equal(t, uint64(1000), w.Len())
+
+	clock.t = clock.t.Add(6 * time.Hour)
+	assert.False(t, w.Insert([]byte("b")))
+	// seen again, renewing it
+	clock.t = clock.t.Add(12 * time.Hour)
+	assert.True(t, w.InsertString("a2"))
+	assert.True(t, w.ContainsString("a3"))
+	assert.True(t, w.Contains([]byte("b")))
+
+	// the first bucket drops out of the window
+	clock.t = clock.t.Add(6 * time.Hour)
+	assert.False(t, w.ContainsString("a3"))
+	assert.True(t, w.ContainsString("a2"))
+	assert.True(t, w.ContainsString("b"))
+	assert.Equal(t, uint64(2), w.Len())
+
+	// written and read back with other data following it in a
+	// buffered stream
+	var buf bytes.Buffer
+	_, err := w.WriteTo(&buf)
+	assert.NoError(t, err)
+	buf.WriteString("trailer")
+	cpy := NewWindowFilter(Config{Clock: clock.now}, time.Hour, 1)
+	r := bufio.NewReaderSize(&buf, 16)
+	_, err = cpy.ReadFrom(r)
+	assert.NoError(t, err)
+	rest, _ := r.ReadString(0)
+	assert.Equal(t, "trailer", rest)
+	assert.Equal(t, 24*time.Hour, cpy.Window())
+	assert.True(t, cpy.ContainsString("a2"))
+	assert.False(t, cpy.ContainsString("a3"))
+	assert.True(t, cpy.config.KeyedHash)
+	assert.Equal(t, w.config.HashKey, cpy.config.HashKey)
+
+	// a restarted process drops the buckets which expired meanwhile
+	clock.t = clock.t.Add(7 * time.Hour)
+	var restarted WindowFilter
+	restarted.config.Clock = clock.now
+	_, err = restarted.ReadFrom(bytes.NewReader(func() []byte {
+		var b bytes.Buffer
+		w.WriteTo(&b)
+		return b.Bytes()
+	}()))
+	assert.NoError(t, err)
+	assert.False(t, restarted.ContainsString("b"))
+	assert.True(t, restarted.ContainsString("a2"))
+	assert.False(t, restarted.InsertString("c"))
+	assert.True(t, restarted.ContainsString("c"))
+
+	// everything drops out after a long pause, and the clock going
+	// backwards is harmless
+	clock.t = clock.t.Add(100 * time.Hour)
+	assert.False(t, w.ContainsString("a2"))
+	assert.Zero(t, w.Len())
+	clock.t = clock.t.Add(-time.Hour)
+	assert.False(t, w.InsertString("d"))
+	assert.True(t, w.ContainsString("d"))
+
+	assert.Panics(t, func() { NewWindowFilter(Config{}, time.Hour, 0) })
+	assert.Panics(t, func() { NewWindowFilter(Config{}, 3, 4) })
+	_, err = cpy.ReadFrom(bytes.NewReader([]byte("garbage garbage garbage garbage")))
+	assert.Error(t, err)
+}
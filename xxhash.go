@@ -0,0 +1,260 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+
+package qf
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxHash primes
+const (
+	xxPrime32_1 = 0x9e3779b1
+	xxPrime32_2 = 0x85ebca77
+	xxPrime32_3 = 0xc2b2ae3d
+
+	xxPrime64_1 = 0x9e3779b185ebca87
+	xxPrime64_2 = 0xc2b2ae3d27d4eb4f
+	xxPrime64_3 = 0x165667b19e3779f9
+	xxPrime64_4 = 0x85ebca77c2b2ae63
+	xxPrime64_5 = 0x27d4eb2f165667c5
+)
+
+// XXH64 returns the 64 bit xxHash of v with a seed of zero
+func XXH64(v []byte) uint64 {
+	n := uint64(len(v))
+	var h uint64
+	if len(v) >= 32 {
+		// the initial accumulators wrap, so are computed at run time
+		p1 := uint64(xxPrime64_1)
+		v1 := p1 + xxPrime64_2
+		v2 := uint64(xxPrime64_2)
+		v3 := uint64(0)
+		v4 := -p1
+		for ; len(v) >= 32; v = v[32:] {
+			v1 = xx64Round(v1, binary.LittleEndian.Uint64(v[0:8]))
+			v2 = xx64Round(v2, binary.LittleEndian.Uint64(v[8:16]))
+			v3 = xx64Round(v3, binary.LittleEndian.Uint64(v[16:24]))
+			v4 = xx64Round(v4, binary.LittleEndian.Uint64(v[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xx64Merge(h, v1)
+		h = xx64Merge(h, v2)
+		h = xx64Merge(h, v3)
+		h = xx64Merge(h, v4)
+	} else {
+		h = xxPrime64_5
+	}
+	h += n
+
+	for ; len(v) >= 8; v = v[8:] {
+		h ^= xx64Round(0, binary.LittleEndian.Uint64(v))
+		h = bits.RotateLeft64(h, 27)*xxPrime64_1 + xxPrime64_4
+	}
+	if len(v) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(v)) * xxPrime64_1
+		h = bits.RotateLeft64(h, 23)*xxPrime64_2 + xxPrime64_3
+		v = v[4:]
+	}
+	for _, c := range v {
+		h ^= uint64(c) * xxPrime64_5
+		h = bits.RotateLeft64(h, 11) * xxPrime64_1
+	}
+	return xx64Avalanche(h)
+}
+
+func xx64Round(acc, input uint64) uint64 {
+	acc += input * xxPrime64_2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime64_1
+}
+
+func xx64Merge(acc, val uint64) uint64 {
+	acc ^= xx64Round(0, val)
+	return acc*xxPrime64_1 + xxPrime64_4
+}
+
+func xx64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= xxPrime64_2
+	h ^= h >> 29
+	h *= xxPrime64_3
+	h ^= h >> 32
+	return h
+}
+
+// xxh3Secret is the default secret of XXH3
+var xxh3Secret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+const (
+	xxh3StripeLen        = 64
+	xxh3SecretConsume    = 8
+	xxh3StripesPerBlock  = (len(xxh3Secret) - xxh3StripeLen) / xxh3SecretConsume
+	xxh3BlockLen         = xxh3StripeLen * xxh3StripesPerBlock
+	xxh3MidSizeMax       = 240
+	xxh3MidSizeOffset    = 3
+	xxh3MidSizeLast      = 136 - 17
+	xxh3LastStripeOffset = len(xxh3Secret) - xxh3StripeLen - 7
+	xxh3MergeOffset      = 11
+)
+
+// XXH3 returns the 64 bit XXH3 hash of v with a seed of zero and the
+// default secret
+func XXH3(v []byte) uint64 {
+	n := len(v)
+	s := xxh3Secret[:]
+	switch {
+	case n == 0:
+		return xx64Avalanche(le64(s[56:]) ^ le64(s[64:]))
+	case n <= 3:
+		combined := uint32(v[0])<<16 | uint32(v[n>>1])<<24 | uint32(v[n-1]) | uint32(n)<<8
+		flip := uint64(binary.LittleEndian.Uint32(s) ^ binary.LittleEndian.Uint32(s[4:]))
+		return xx64Avalanche(uint64(combined) ^ flip)
+	case n <= 8:
+		in1 := uint64(binary.LittleEndian.Uint32(v))
+		in2 := uint64(binary.LittleEndian.Uint32(v[n-4:]))
+		flip := le64(s[8:]) ^ le64(s[16:])
+		return xxh3rrmxmx((in2+in1<<32)^flip, uint64(n))
+	case n <= 16:
+		lo := le64(v) ^ (le64(s[24:]) ^ le64(s[32:]))
+		hi := le64(v[n-8:]) ^ (le64(s[40:]) ^ le64(s[48:]))
+		acc := uint64(n) + bits.ReverseBytes64(lo) + hi + mulFold64(lo, hi)
+		return xxh3Avalanche(acc)
+	case n <= 128:
+		acc := uint64(n) * xxPrime64_1
+		if n > 32 {
+			if n > 64 {
+				if n > 96 {
+					acc += mix16(v[48:], s[96:])
+					acc += mix16(v[n-64:], s[112:])
+				}
+				acc += mix16(v[32:], s[64:])
+				acc += mix16(v[n-48:], s[80:])
+			}
+			acc += mix16(v[16:], s[32:])
+			acc += mix16(v[n-32:], s[48:])
+		}
+		acc += mix16(v, s)
+		acc += mix16(v[n-16:], s[16:])
+		return xxh3Avalanche(acc)
+	case n <= xxh3MidSizeMax:
+		acc := uint64(n) * xxPrime64_1
+		for i := 0; i < 8; i++ {
+			acc += mix16(v[16*i:], s[16*i:])
+		}
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < n/16; i++ {
+			acc += mix16(v[16*i:], s[16*(i-8)+xxh3MidSizeOffset:])
+		}
+		acc += mix16(v[n-16:], s[xxh3MidSizeLast:])
+		return xxh3Avalanche(acc)
+	}
+	return xxh3Long(v)
+}
+
+func xxh3Long(v []byte) uint64 {
+	n := len(v)
+	s := xxh3Secret[:]
+	acc := [8]uint64{
+		xxPrime32_3, xxPrime64_1, xxPrime64_2, xxPrime64_3,
+		xxPrime64_4, xxPrime32_2, xxPrime64_5, xxPrime32_1,
+	}
+	blocks := (n - 1) / xxh3BlockLen
+	for b := 0; b < blocks; b++ {
+		xxh3Accumulate(&acc, v[b*xxh3BlockLen:], s, xxh3StripesPerBlock)
+		// scramble
+		key := s[len(s)-xxh3StripeLen:]
+		for i := range acc {
+			a := acc[i]
+			a ^= a >> 47
+			a ^= le64(key[8*i:])
+			acc[i] = a * xxPrime32_1
+		}
+	}
+	last := v[blocks*xxh3BlockLen:]
+	stripes := (n - 1 - blocks*xxh3BlockLen) / xxh3StripeLen
+	xxh3Accumulate(&acc, last, s, stripes)
+	xxh3Accumulate(&acc, v[n-xxh3StripeLen:], s[xxh3LastStripeOffset:], 1)
+
+	// merge
+	h := uint64(n) * xxPrime64_1
+	m := s[xxh3MergeOffset:]
+	for i := 0; i < 4; i++ {
+		h += mulFold64(acc[2*i]^le64(m[16*i:]), acc[2*i+1]^le64(m[16*i+8:]))
+	}
+	return xxh3Avalanche(h)
+}
+
+// xxh3Accumulate mixes n 64 byte stripes of in into the accumulators,
+// advancing through key by 8 bytes per stripe
+func xxh3Accumulate(acc *[8]uint64, in, key []byte, n int) {
+	a0, a1, a2, a3, a4, a5, a6, a7 := acc[0], acc[1], acc[2], acc[3], acc[4], acc[5], acc[6], acc[7]
+	for ; n > 0; n-- {
+		s := (*[xxh3StripeLen]byte)(in)
+		k := (*[xxh3StripeLen]byte)(key)
+		v0, v1 := binary.LittleEndian.Uint64(s[0:]), binary.LittleEndian.Uint64(s[8:])
+		v2, v3 := binary.LittleEndian.Uint64(s[16:]), binary.LittleEndian.Uint64(s[24:])
+		v4, v5 := binary.LittleEndian.Uint64(s[32:]), binary.LittleEndian.Uint64(s[40:])
+		v6, v7 := binary.LittleEndian.Uint64(s[48:]), binary.LittleEndian.Uint64(s[56:])
+		k0, k1 := v0^binary.LittleEndian.Uint64(k[0:]), v1^binary.LittleEndian.Uint64(k[8:])
+		k2, k3 := v2^binary.LittleEndian.Uint64(k[16:]), v3^binary.LittleEndian.Uint64(k[24:])
+		k4, k5 := v4^binary.LittleEndian.Uint64(k[32:]), v5^binary.LittleEndian.Uint64(k[40:])
+		k6, k7 := v6^binary.LittleEndian.Uint64(k[48:]), v7^binary.LittleEndian.Uint64(k[56:])
+		a0 += v1 + uint64(uint32(k0))*(k0>>32)
+		a1 += v0 + uint64(uint32(k1))*(k1>>32)
+		a2 += v3 + uint64(uint32(k2))*(k2>>32)
+		a3 += v2 + uint64(uint32(k3))*(k3>>32)
+		a4 += v5 + uint64(uint32(k4))*(k4>>32)
+		a5 += v4 + uint64(uint32(k5))*(k5>>32)
+		a6 += v7 + uint64(uint32(k6))*(k6>>32)
+		a7 += v6 + uint64(uint32(k7))*(k7>>32)
+		in = in[xxh3StripeLen:]
+		key = key[xxh3SecretConsume:]
+	}
+	acc[0], acc[1], acc[2], acc[3], acc[4], acc[5], acc[6], acc[7] = a0, a1, a2, a3, a4, a5, a6, a7
+}
+
+func le64(b []byte) uint64 {
+	return binary.LittleEndian.Uint64(b)
+}
+
+func mix16(v, key []byte) uint64 {
+	return mulFold64(le64(v)^le64(key), le64(v[8:])^le64(key[8:]))
+}
+
+// mulFold64 returns the xor of the high and low words of the 128 bit
+// product of a and b
+func mulFold64(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= 0x165667919e3779f9
+	h ^= h >> 32
+	return h
+}
+
+func xxh3rrmxmx(h, n uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9fb21c651e98df25
+	h ^= (h >> 35) + n
+	h *= 0x9fb21c651e98df25
+	h ^= h >> 28
+	return h
+}